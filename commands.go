@@ -0,0 +1,230 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"kendb/db"
+	"log/slog"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// command is a kendb subcommand, args excludes the command name itself
+type command func(args []string) error
+
+var commands = map[string]command{
+	"backup":  backupCommand,
+	"restore": restoreCommand,
+	"inspect": inspectCommand,
+	"eval":    evalCommand,
+	"query":   queryCommand,
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: kendb <command> [arguments]")
+	fmt.Fprintln(os.Stderr, "commands:")
+	fmt.Fprintln(os.Stderr, "  backup <db> <out>       write an online backup of resources/<db>.ken to <out>")
+	fmt.Fprintln(os.Stderr, "  restore <backup> <db>   rebuild resources/<db>.ken from a backup file")
+	fmt.Fprintln(os.Stderr, "  inspect [-json] <file>  dump the on-disk layout of a .ken file")
+	fmt.Fprintln(os.Stderr, "  eval [flags] <db> <table> <column>")
+	fmt.Fprintln(os.Stderr, "                          measure recall and latency of the column's indexes")
+	fmt.Fprintln(os.Stderr, "  query [-json] <db> <query>")
+	fmt.Fprintln(os.Stderr, "                          run a SELECT or EXPLAIN query, parameters are not supported")
+}
+
+// kendb backup <db> <out>
+func backupCommand(args []string) error {
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		usage()
+		return fmt.Errorf("backup takes 2 arguments, got %d", fs.NArg())
+	}
+	name, out := fs.Arg(0), fs.Arg(1)
+	// InitDB would create an empty database, which is never what a backup wants
+	if _, err := os.Stat(db.Path(name)); err != nil {
+		slog.Error("Database does not exist", "db", name)
+		return err
+	}
+
+	conn, err := db.InitDB(name)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := conn.BackupTo(out); err != nil {
+		return err
+	}
+	slog.Info("Backup written", "db", name, "path", out)
+	return nil
+}
+
+// kendb restore <backup> <db>
+func restoreCommand(args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		usage()
+		return fmt.Errorf("restore takes 2 arguments, got %d", fs.NArg())
+	}
+	in, name := fs.Arg(0), fs.Arg(1)
+	if err := db.RestoreFrom(in, name); err != nil {
+		return err
+	}
+	slog.Info("Database restored", "db", name, "path", db.Path(name))
+	return nil
+}
+
+// kendb inspect [-json] <file>
+func inspectCommand(args []string) error {
+	fs := flag.NewFlagSet("inspect", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print the layout as JSON")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		usage()
+		return fmt.Errorf("inspect takes 1 argument, got %d", fs.NArg())
+	}
+	layout, err := db.InspectFile(fs.Arg(0))
+	if err != nil {
+		// a partial layout is still worth printing when the header is broken
+		if layout == nil {
+			return err
+		}
+		slog.Error("File is damaged", "error", err)
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(layout)
+	}
+	return layout.WriteText(os.Stdout)
+}
+
+// kendb query [-json] <db> <query>
+func queryCommand(args []string) error {
+	fs := flag.NewFlagSet("query", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print the result set as JSON")
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		usage()
+		return fmt.Errorf("query takes 2 arguments, got %d", fs.NArg())
+	}
+	name := fs.Arg(0)
+	if _, err := os.Stat(db.Path(name)); err != nil {
+		slog.Error("Database does not exist", "db", name)
+		return err
+	}
+
+	conn, err := db.InitDB(name)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	rs, err := conn.Execute(fs.Arg(1), nil)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(rs)
+	}
+	return rs.WriteText(os.Stdout)
+}
+
+// kendb eval [-json] [-k n] [-queries n] [-ef list] [-nprobe list] [-l list] <db> <table> <column>
+func evalCommand(args []string) error {
+	fs := flag.NewFlagSet("eval", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	k := fs.Int("k", 10, "results per query, recall is measured at k")
+	queries := fs.Int("queries", 100, "number of column entries sampled as queries")
+	seed := fs.Int64("seed", 1, "seed for query sampling")
+	efs := fs.String("ef", "16,64,256", "comma separated HNSW ef values")
+	nprobes := fs.String("nprobe", "1,8,32", "comma separated IVF nprobe values")
+	beams := fs.String("l", "16,64,256", "comma separated DiskANN beam widths")
+	fs.Parse(args)
+	if fs.NArg() != 3 {
+		usage()
+		return fmt.Errorf("eval takes 3 arguments, got %d", fs.NArg())
+	}
+	name, tableName, columnName := fs.Arg(0), fs.Arg(1), fs.Arg(2)
+	if _, err := os.Stat(db.Path(name)); err != nil {
+		slog.Error("Database does not exist", "db", name)
+		return err
+	}
+
+	conn, err := db.InitDB(name)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	tbl, ok := conn.GetTableByName(tableName)
+	if !ok {
+		return fmt.Errorf("no table %q in %s", tableName, name)
+	}
+	col, ok := tbl.GetColumnByName(columnName)
+	if !ok {
+		return fmt.Errorf("no column %q in table %s", columnName, tableName)
+	}
+
+	// only indexes the column actually has are measured, next to the exact baseline
+	configs := []db.EvalConfig{{}}
+	kinds := col.Indexes()
+	if slices.Contains(kinds, db.HNSWIndex) {
+		values, err := parseInts(*efs)
+		if err != nil {
+			return fmt.Errorf("bad -ef: %w", err)
+		}
+		for _, ef := range values {
+			configs = append(configs, db.EvalConfig{Index: db.HNSWIndex, Ef: ef})
+		}
+	}
+	if slices.Contains(kinds, db.IVFFlatIndex) {
+		values, err := parseInts(*nprobes)
+		if err != nil {
+			return fmt.Errorf("bad -nprobe: %w", err)
+		}
+		for _, nprobe := range values {
+			configs = append(configs, db.EvalConfig{Index: db.IVFFlatIndex, NProbe: nprobe})
+		}
+	}
+	if slices.Contains(kinds, db.DiskANNIndex) {
+		values, err := parseInts(*beams)
+		if err != nil {
+			return fmt.Errorf("bad -l: %w", err)
+		}
+		for _, l := range values {
+			configs = append(configs, db.EvalConfig{Index: db.DiskANNIndex, L: l})
+		}
+	}
+
+	report, err := col.Evaluate(db.EvalOptions{Queries: *queries, K: *k, Seed: *seed, Configs: configs})
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+	return report.WriteText(os.Stdout)
+}
+
+// helper parsing a comma separated list of ints, empty entries are skipped
+func parseInts(s string) ([]int, error) {
+	values := []int{}
+	for _, field := range strings.Split(s, ",") {
+		if field = strings.TrimSpace(field); field == "" {
+			continue
+		}
+		v, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
@@ -0,0 +1,336 @@
+package db
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log/slog"
+	"os"
+)
+
+/*
+Backup stream layout (all integers little endian uint64 unless noted):
+	magic          "KENBAK02"
+	metadataCursor
+	dataCursor
+	metadata       bytes [MetadataRegionStart, metadataCursor)
+	extensionSize
+	extensions     bytes [ExtensionRegionStart, ExtensionRegionStart+extensionSize)
+	numChunks
+	per chunk:     offset, nextChunk, numVectors, size, then size bytes of entries
+	               (index blob chunks use the same record, numVectors is their byte count)
+	checksum       uint32 crc32 (IEEE) of everything above
+Only the used part of every chunk is written, the sparse tail of the file is skipped
+*/
+
+// version 01 streams predate the catalog extension region and are still restorable
+var (
+	backupMagic   = []byte("KENBAK02")
+	backupMagicV1 = []byte("KENBAK01")
+)
+
+// backupCopySize bounds the chunk data copied under one pin, Grow waits for the pin
+// so appends only ever stall behind a single copy
+const backupCopySize = 4 << 20
+
+type backupChunk struct {
+	offset int64
+	header ChunkHeader
+	size   int64  // bytes of entry data following the header
+	data   []byte // copied up front for index blobs, nil for column chunks
+}
+
+// Backup streams a consistent copy of the database to w
+// The catalog, column tails and index blobs are captured under the writer lock, after which
+// appends continue while the column chunks are copied a piece at a time
+func (conn *DB) Backup(w io.Writer) error {
+	unlock := conn.file.lockWriter()
+	r, unpin := conn.file.Pin()
+	metadataCursor, dataCursor := r.metadataCursor(), r.dataCursor()
+	catalog, err := r.read(MetadataRegionStart, metadataCursor-MetadataRegionStart)
+	extensions := []byte{}
+	if cursor := r.extensionCursor(); err == nil && cursor != 0 {
+		extensions, err = r.read(ExtensionRegionStart, cursor-ExtensionRegionStart)
+	}
+	if err != nil {
+		unpin()
+		unlock()
+		return err
+	}
+	catalog, extensions = bytes.Clone(catalog), bytes.Clone(extensions)
+	snap := conn.snapshotLocked(r)
+	// index blobs are replaced and their chunks reused, so they are copied before the lock drops
+	chunks := []backupChunk{}
+	for _, col := range snap.columns {
+		blobs, err := col.indexes.backupChunks(r)
+		if err != nil {
+			unpin()
+			unlock()
+			return err
+		}
+		chunks = append(chunks, blobs...)
+	}
+	// column entries never change once written, only their location is listed here
+	for _, col := range snap.columns {
+		view := col.view()
+		entrySize := 8 + (view.meta.vectorLength * 4)
+		prev := -1
+		view.chunks(r, func(chunkPos int64, header ChunkHeader, count int64) bool {
+			if prev >= 0 {
+				chunks[prev].header.nextChunk = chunkPos
+			}
+			chunks = append(chunks, backupChunk{
+				offset: chunkPos,
+				header: ChunkHeader{nextChunk: 0, numVectors: count},
+				size:   count * entrySize,
+			})
+			prev = len(chunks) - 1
+			return true
+		})
+	}
+	unpin()
+	unlock()
+
+	crc := crc32.NewIEEE()
+	bw := bufio.NewWriter(io.MultiWriter(w, crc))
+	putUint64 := func(v int64) {
+		var buf [8]byte
+		ByteOrder.PutUint64(buf[:], uint64(v))
+		bw.Write(buf[:])
+	}
+
+	bw.Write(backupMagic)
+	putUint64(metadataCursor)
+	putUint64(dataCursor)
+	bw.Write(catalog)
+	putUint64(int64(len(extensions)))
+	bw.Write(extensions)
+	putUint64(int64(len(chunks)))
+	buf := make([]byte, backupCopySize)
+	for _, chunk := range chunks {
+		putUint64(chunk.offset)
+		putUint64(chunk.header.nextChunk)
+		putUint64(chunk.header.numVectors)
+		putUint64(chunk.size)
+		var err error
+		if chunk.data != nil {
+			_, err = bw.Write(chunk.data)
+		} else {
+			err = conn.file.copyEntries(bw, chunk, buf)
+		}
+		if err != nil {
+			slog.Error("Backup write failed", "chunk", chunk.offset, "error", err)
+			return err
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		slog.Error("Backup write failed", "error", err)
+		return err
+	}
+
+	var sum [4]byte
+	ByteOrder.PutUint32(sum[:], crc.Sum32())
+	_, err = w.Write(sum[:])
+	return err
+}
+
+// helper that streams a column chunk's entries to w through buf, pinning the store
+// for one piece at a time and writing it out after the pin is released
+// Offloaded chunks are faulted in and restored as local chunks
+func (s *store) copyEntries(w io.Writer, chunk backupChunk, buf []byte) error {
+	for off := int64(0); off < chunk.size; off += int64(len(buf)) {
+		n := min(int64(len(buf)), chunk.size-off)
+		r, unpin := s.Pin()
+		// the header is read every time, the chunk may have been offloaded in between
+		header := r.chunkHeader(chunk.offset)
+		var data []byte
+		var err error
+		if header.location == Local {
+			data, err = r.read(chunk.offset+ChunkHeaderSize+off, n)
+		} else if data, err = r.entries(chunk.offset, header, off+n); err == nil {
+			data = data[off:]
+		}
+		copy(buf, data)
+		unpin()
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(buf[:n]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BackupTo writes a backup of the database to the file at path
+func (conn *DB) BackupTo(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		slog.Error("Could not create backup file", "path", path, "error", err)
+		return err
+	}
+	if err := conn.Backup(f); err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+	return f.Close()
+}
+
+// Restore reconstructs a .ken file for the named database from a backup stream
+// Refuses to overwrite an existing database, use InitDB to open the result
+func Restore(r io.Reader, filename string) error {
+	path := Path(filename)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		slog.Error("Could not create database file for restore", "path", path, "error", err)
+		return err
+	}
+	if err := restoreInto(f, r); err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+	return f.Close()
+}
+
+// RestoreFrom restores the named database from the backup file at path
+func RestoreFrom(path string, filename string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		slog.Error("Could not open backup file", "path", path, "error", err)
+		return err
+	}
+	defer f.Close()
+	return Restore(f, filename)
+}
+
+// helper that validates the stream while writing it into f at the recorded offsets
+func restoreInto(f *os.File, r io.Reader) error {
+	crc := crc32.NewIEEE()
+	br := bufio.NewReader(r)
+	tr := io.TeeReader(br, crc)
+	readUint64 := func() (int64, error) {
+		var buf [8]byte
+		if _, err := io.ReadFull(tr, buf[:]); err != nil {
+			return 0, err
+		}
+		return int64(ByteOrder.Uint64(buf[:])), nil
+	}
+
+	magic := make([]byte, len(backupMagic))
+	if _, err := io.ReadFull(tr, magic); err != nil || !(bytes.Equal(magic, backupMagic) || bytes.Equal(magic, backupMagicV1)) {
+		slog.Error("Not a kendb backup stream")
+		return fmt.Errorf("invalid backup magic")
+	}
+	metadataCursor, err := readUint64()
+	if err != nil {
+		return err
+	}
+	dataCursor, err := readUint64()
+	if err != nil {
+		return err
+	}
+	if metadataCursor < MetadataRegionStart || metadataCursor > ExtensionRegionStart || dataCursor < DataRegionStart {
+		slog.Error("Corrupt backup header", "metadata cursor", metadataCursor, "data cursor", dataCursor)
+		return fmt.Errorf("invalid backup header")
+	}
+
+	// size the file like InitDB would, the unwritten regions stay sparse
+	size := max(int64(InitialFileSize), dataCursor)
+	if err := f.Truncate(size); err != nil {
+		return err
+	}
+	catalog := make([]byte, metadataCursor-MetadataRegionStart)
+	if _, err := io.ReadFull(tr, catalog); err != nil {
+		return err
+	}
+	if _, err := f.WriteAt(catalog, MetadataRegionStart); err != nil {
+		return err
+	}
+	if bytes.Equal(magic, backupMagic) {
+		extensionSize, err := readUint64()
+		if err != nil {
+			return err
+		}
+		if extensionSize < 0 || extensionSize > ExtensionRegionSize {
+			slog.Error("Corrupt backup extension region", "size", extensionSize)
+			return fmt.Errorf("invalid backup extension region")
+		}
+		extensions := make([]byte, extensionSize)
+		if _, err := io.ReadFull(tr, extensions); err != nil {
+			return err
+		}
+		if _, err := f.WriteAt(extensions, ExtensionRegionStart); err != nil {
+			return err
+		}
+	}
+
+	numChunks, err := readUint64()
+	if err != nil {
+		return err
+	}
+	for range numChunks {
+		offset, err := readUint64()
+		if err != nil {
+			return err
+		}
+		next, err := readUint64()
+		if err != nil {
+			return err
+		}
+		count, err := readUint64()
+		if err != nil {
+			return err
+		}
+		entries, err := readUint64()
+		if err != nil {
+			return err
+		}
+		if offset < DataRegionStart || entries < 0 || entries > ChunkSize-ChunkHeaderSize {
+			slog.Error("Chunk outside data region", "offset", offset, "size", entries)
+			return fmt.Errorf("invalid chunk in backup")
+		}
+		// files written before the data cursor tracked every chunk can have chunks past it,
+		// move the cursor so the restored file never hands those out again
+		if offset+ChunkSize > dataCursor {
+			dataCursor = offset + ChunkSize
+		}
+		if dataCursor > size {
+			size = dataCursor
+			if err := f.Truncate(size); err != nil {
+				return err
+			}
+		}
+		buf := make([]byte, ChunkHeaderSize+entries)
+		header := ChunkHeader{nextChunk: next, numVectors: count}
+		header.WriteTo(buf, 0)
+		if _, err := io.ReadFull(tr, buf[ChunkHeaderSize:]); err != nil {
+			return err
+		}
+		if _, err := f.WriteAt(buf, offset); err != nil {
+			return err
+		}
+	}
+
+	var header [HeaderSize]byte
+	ByteOrder.PutUint64(header[0:8], uint64(metadataCursor))
+	ByteOrder.PutUint64(header[8:16], uint64(dataCursor))
+	if _, err := f.WriteAt(header[:], 0); err != nil {
+		return err
+	}
+
+	// the checksum itself is not part of the summed bytes
+	want := crc.Sum32()
+	var sum [4]byte
+	if _, err := io.ReadFull(br, sum[:]); err != nil {
+		return err
+	}
+	if ByteOrder.Uint32(sum[:]) != want {
+		slog.Error("Backup checksum mismatch")
+		return fmt.Errorf("backup checksum mismatch")
+	}
+	return f.Sync()
+}
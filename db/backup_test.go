@@ -0,0 +1,113 @@
+package db
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// growingWriter adds columns to the database on its first write, growing the file
+// while the backup is still streaming
+type growingWriter struct {
+	bytes.Buffer
+	tbl   *Table
+	grown chan error
+}
+
+func (w *growingWriter) Write(p []byte) (int, error) {
+	if w.grown == nil {
+		w.grown = make(chan error, 1)
+		go func() {
+			for i := 0; i < 4; i++ {
+				if _, err := w.tbl.AddColumn(fmt.Sprintf("grow%d", i), 4); err != nil {
+					w.grown <- err
+					return
+				}
+			}
+			w.grown <- nil
+		}()
+		// Grow waits for every pin, a backup holding one across the stream stalls it
+		select {
+		case err := <-w.grown:
+			w.grown <- err
+		case <-time.After(30 * time.Second):
+			return 0, fmt.Errorf("the file could not grow while the backup was streaming")
+		}
+	}
+	return w.Buffer.Write(p)
+}
+
+func TestBackupRestore(t *testing.T) {
+	conn := openTestDB(t)
+	tbl, err := conn.AddTable("t", 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	col, err := tbl.AddColumn("c", 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendTestVectors(t, col, 500)
+	if err := col.BuildHNSW(HNSWParams{Metric: L2, Seed: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	w := &growingWriter{tbl: tbl}
+	if err := conn.Backup(w); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-w.grown; err != nil {
+		t.Fatal(err)
+	}
+	appendTestVectors(t, col, 10) // not part of the backup
+
+	path := filepath.Join(t.TempDir(), "restored.ken")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := restoreInto(f, &w.Buffer); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	file, err := OpenMMapFile(path, InitialFileSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored, err := Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+
+	rtbl, ok := restored.GetTableByName("t")
+	if !ok {
+		t.Fatal("restored database is missing the table")
+	}
+	rcol, ok := rtbl.GetColumnByName("c")
+	if !ok {
+		t.Fatal("restored table is missing the column")
+	}
+	if rcol.Length() != 500 {
+		t.Fatalf("restored column has %d entries, want 500", rcol.Length())
+	}
+	vecs, err := fetchRange(rcol, 0, 500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, vec := range vecs {
+		if vec.timestamp != uint64(i) || vec.features[0] != float32(i) {
+			t.Fatalf("restored entry %d is %d %v", i, vec.timestamp, vec.features)
+		}
+	}
+	results, err := rcol.SearchHNSW([]float32{42, 42, 42, 42}, 1, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Index != 42 {
+		t.Fatalf("restored index found %v, want entry 42", results)
+	}
+}
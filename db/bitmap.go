@@ -0,0 +1,300 @@
+package db
+
+import (
+	"math/bits"
+	"slices"
+)
+
+/*
+Bitmap layout, following roaring bitmaps:
+	entry indexes are split into a high key (idx >> 16) and 16 low bits
+	every key present has one container holding the low bits of its indexes
+	a container is a sorted []uint16 while it holds at most 4096 values, 8KB of
+	bitset words past that, so sparse and dense variables both stay small
+*/
+
+const (
+	containerBits     = 16
+	containerSize     = 1 << containerBits
+	containerWords    = containerSize / 64
+	arrayContainerMax = 4096 // past this many values a bitset is smaller than the array
+)
+
+// Bitmap is a compressed set of entry indexes
+// Bitmaps returned by the set operations share nothing with their inputs
+type Bitmap struct {
+	keys       []int64 // ascending
+	containers []*container
+}
+
+type container struct {
+	array []uint16 // sorted low bits while sparse
+	words []uint64 // bitset once dense, array is nil then
+	n     int
+}
+
+func NewBitmap() *Bitmap {
+	return &Bitmap{}
+}
+
+// Add sets idx, appending in ascending order is the fast path
+func (b *Bitmap) Add(idx int64) {
+	key, low := idx>>containerBits, uint16(idx)
+	i, ok := b.find(key)
+	if !ok {
+		b.keys = slices.Insert(b.keys, i, key)
+		b.containers = slices.Insert(b.containers, i, &container{})
+	}
+	b.containers[i].add(low)
+}
+
+// AddRange sets every index in [start, end), filling each container it spans in place
+func (b *Bitmap) AddRange(start, end int64) {
+	for start < end {
+		key := start >> containerBits
+		base := key << containerBits
+		i, ok := b.find(key)
+		if !ok {
+			b.keys = slices.Insert(b.keys, i, key)
+			b.containers = slices.Insert(b.containers, i, &container{})
+		}
+		b.containers[i].addRange(start-base, min(end-base, containerSize))
+		start = base + containerSize
+	}
+}
+
+func (b *Bitmap) Contains(idx int64) bool {
+	i, ok := b.find(idx >> containerBits)
+	return ok && b.containers[i].contains(uint16(idx))
+}
+
+// Cardinality returns how many indexes are set
+func (b *Bitmap) Cardinality() int64 {
+	n := int64(0)
+	for _, c := range b.containers {
+		n += int64(c.n)
+	}
+	return n
+}
+
+// Iterate calls fn with every set index in ascending order until fn returns false
+func (b *Bitmap) Iterate(fn func(idx int64) bool) {
+	for i, c := range b.containers {
+		if !c.each(b.keys[i]<<containerBits, fn) {
+			return
+		}
+	}
+}
+
+// And returns the indexes set in both b and other
+func (b *Bitmap) And(other *Bitmap) *Bitmap {
+	return combine(b, other, func(x, y uint64) uint64 { return x & y }, false, false)
+}
+
+// Or returns the indexes set in either b or other
+func (b *Bitmap) Or(other *Bitmap) *Bitmap {
+	return combine(b, other, func(x, y uint64) uint64 { return x | y }, true, true)
+}
+
+// AndNot returns the indexes set in b but not in other
+func (b *Bitmap) AndNot(other *Bitmap) *Bitmap {
+	return combine(b, other, func(x, y uint64) uint64 { return x &^ y }, true, false)
+}
+
+// Xor returns the indexes set in exactly one of b and other
+func (b *Bitmap) Xor(other *Bitmap) *Bitmap {
+	return combine(b, other, func(x, y uint64) uint64 { return x ^ y }, true, true)
+}
+
+// Not returns the indexes in [0, n) that are not set in b
+// A bitmap has no length of its own, so n is usually the column's Length
+func (b *Bitmap) Not(n int64) *Bitmap {
+	all := NewBitmap()
+	all.AddRange(0, n)
+	return all.AndNot(b)
+}
+
+// last returns the largest set index, -1 if the bitmap is empty
+func (b *Bitmap) last() int64 {
+	if len(b.containers) == 0 {
+		return -1
+	}
+	i := len(b.containers) - 1
+	return b.keys[i]<<containerBits | int64(b.containers[i].last())
+}
+
+func (b *Bitmap) find(key int64) (int, bool) {
+	// appends land in the last container, skip the search for them
+	if n := len(b.keys); n > 0 && b.keys[n-1] == key {
+		return n - 1, true
+	}
+	return slices.BinarySearch(b.keys, key)
+}
+
+// push appends a container whose key is above every key already present
+func (b *Bitmap) push(key int64, c *container) {
+	b.keys = append(b.keys, key)
+	b.containers = append(b.containers, c)
+}
+
+// combine merges two bitmaps container by container. op is applied to the bitset
+// words of keys present in both, keepA and keepB say whether a container present
+// on only one side carries over
+func combine(a, b *Bitmap, op func(x, y uint64) uint64, keepA, keepB bool) *Bitmap {
+	out := &Bitmap{}
+	i, j := 0, 0
+	for i < len(a.keys) || j < len(b.keys) {
+		switch {
+		case j == len(b.keys) || (i < len(a.keys) && a.keys[i] < b.keys[j]):
+			if keepA {
+				out.push(a.keys[i], a.containers[i].clone())
+			}
+			i++
+		case i == len(a.keys) || b.keys[j] < a.keys[i]:
+			if keepB {
+				out.push(b.keys[j], b.containers[j].clone())
+			}
+			j++
+		default:
+			x, y := a.containers[i].bitset(), b.containers[j].bitset()
+			words := make([]uint64, containerWords)
+			for w := range words {
+				words[w] = op(x[w], y[w])
+			}
+			if c := containerFromWords(words); c != nil {
+				out.push(a.keys[i], c)
+			}
+			i++
+			j++
+		}
+	}
+	return out
+}
+
+func (c *container) add(low uint16) {
+	if c.words != nil {
+		if bit := uint64(1) << (low % 64); c.words[low/64]&bit == 0 {
+			c.words[low/64] |= bit
+			c.n++
+		}
+		return
+	}
+	if n := len(c.array); n == 0 || c.array[n-1] < low {
+		c.array = append(c.array, low)
+	} else {
+		i, found := slices.BinarySearch(c.array, low)
+		if found {
+			return
+		}
+		c.array = slices.Insert(c.array, i, low)
+	}
+	c.n++
+	if c.n > arrayContainerMax {
+		c.words, c.array = c.bitset(), nil
+	}
+}
+
+// addRange sets the low bits [lo, hi), hi may be containerSize
+// The array is spliced while the result surely fits in one, otherwise the bitset is filled
+func (c *container) addRange(lo, hi int64) {
+	if c.words == nil && int64(c.n)+hi-lo <= arrayContainerMax {
+		from, _ := slices.BinarySearch(c.array, uint16(lo))
+		to := len(c.array)
+		if hi < containerSize {
+			to, _ = slices.BinarySearch(c.array, uint16(hi))
+		}
+		run := make([]uint16, 0, hi-lo)
+		for v := lo; v < hi; v++ {
+			run = append(run, uint16(v))
+		}
+		c.array = slices.Replace(c.array, from, to, run...)
+		c.n = len(c.array)
+		return
+	}
+	words := c.bitset()
+	for v := lo; v < hi; {
+		if v%64 == 0 && hi-v >= 64 {
+			words[v/64] = ^uint64(0)
+			v += 64
+			continue
+		}
+		words[v/64] |= 1 << (v % 64)
+		v++
+	}
+	*c = *containerFromWords(words)
+}
+
+func (c *container) contains(low uint16) bool {
+	if c.words != nil {
+		return c.words[low/64]&(1<<(low%64)) != 0
+	}
+	_, found := slices.BinarySearch(c.array, low)
+	return found
+}
+
+func (c *container) last() uint16 {
+	if c.words == nil {
+		return c.array[len(c.array)-1]
+	}
+	for w := len(c.words) - 1; ; w-- {
+		if c.words[w] != 0 {
+			return uint16(w*64 + 63 - bits.LeadingZeros64(c.words[w]))
+		}
+	}
+}
+
+// bitset returns the container's values as bitset words, sharing them if it is dense
+func (c *container) bitset() []uint64 {
+	if c.words != nil {
+		return c.words
+	}
+	words := make([]uint64, containerWords)
+	for _, v := range c.array {
+		words[v/64] |= 1 << (v % 64)
+	}
+	return words
+}
+
+func (c *container) clone() *container {
+	return &container{array: slices.Clone(c.array), words: slices.Clone(c.words), n: c.n}
+}
+
+func (c *container) each(base int64, fn func(idx int64) bool) bool {
+	if c.words == nil {
+		for _, v := range c.array {
+			if !fn(base | int64(v)) {
+				return false
+			}
+		}
+		return true
+	}
+	for w, word := range c.words {
+		for word != 0 {
+			if !fn(base | int64(w*64+bits.TrailingZeros64(word))) {
+				return false
+			}
+			word &= word - 1
+		}
+	}
+	return true
+}
+
+// containerFromWords packs a bitset into the smaller representation, nil if it is empty
+func containerFromWords(words []uint64) *container {
+	n := 0
+	for _, w := range words {
+		n += bits.OnesCount64(w)
+	}
+	if n == 0 {
+		return nil
+	}
+	if n > arrayContainerMax {
+		return &container{words: words, n: n}
+	}
+	c := &container{array: make([]uint16, 0, n), n: n}
+	(&container{words: words}).each(0, func(idx int64) bool {
+		c.array = append(c.array, uint16(idx))
+		return true
+	})
+	return c
+}
@@ -0,0 +1,129 @@
+package db
+
+import (
+	"slices"
+	"testing"
+)
+
+// bitmapIndexes lists the set indexes in order
+func bitmapIndexes(b *Bitmap) []int64 {
+	out := []int64{}
+	b.Iterate(func(idx int64) bool {
+		out = append(out, idx)
+		return true
+	})
+	return out
+}
+
+// A container is an array up to arrayContainerMax values and a bitset past it, both ways
+func TestBitmapContainerBoundary(t *testing.T) {
+	b := NewBitmap()
+	for i := range int64(arrayContainerMax) {
+		b.Add(2 * i)
+	}
+	if c := b.containers[0]; c.words != nil || c.n != arrayContainerMax {
+		t.Fatalf("%d values are not held in an array", c.n)
+	}
+	b.Add(1)
+	if c := b.containers[0]; c.words == nil || c.array != nil {
+		t.Fatal("a value past the array limit did not switch to a bitset")
+	}
+	if b.Cardinality() != arrayContainerMax+1 || !b.Contains(1) || !b.Contains(2*(arrayContainerMax-1)) || b.Contains(3) {
+		t.Fatalf("bitset holds %d values", b.Cardinality())
+	}
+
+	one := NewBitmap()
+	one.Add(1)
+	back := b.AndNot(one)
+	if c := back.containers[0]; c.words != nil || c.n != arrayContainerMax {
+		t.Fatal("dropping back to the array limit kept the bitset")
+	}
+	want := []int64{}
+	for i := range int64(arrayContainerMax) {
+		want = append(want, 2*i)
+	}
+	if got := bitmapIndexes(back); !slices.Equal(got, want) {
+		t.Fatalf("array after the round trip holds %d values, want %d", len(got), len(want))
+	}
+}
+
+func TestBitmapAddRange(t *testing.T) {
+	cases := []struct {
+		name   string
+		before []int64
+		ranges [][2]int64
+		dense  []bool // representation of each container afterwards
+	}{
+		{"fills an array", nil, [][2]int64{{10, 10 + arrayContainerMax}}, []bool{false}},
+		{"one past the array", nil, [][2]int64{{10, 11 + arrayContainerMax}}, []bool{true}},
+		{"overlap stays an array", []int64{5, 20, 30, 5000}, [][2]int64{{10, 25}, {0, 8}}, []bool{false}},
+		{"grows an array into a bitset", []int64{7, 60000}, [][2]int64{{100, 100 + arrayContainerMax}}, []bool{true}},
+		{"spans containers", []int64{3, 2 * containerSize}, [][2]int64{{containerSize - 5, 2*containerSize + 7}}, []bool{false, true, false}},
+		{"empty and reversed", []int64{9}, [][2]int64{{4, 4}, {8, 2}}, []bool{false}},
+		{"ends on a container", nil, [][2]int64{{containerSize - 64, containerSize}}, []bool{false}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			b := NewBitmap()
+			set := map[int64]bool{}
+			for _, idx := range c.before {
+				b.Add(idx)
+				set[idx] = true
+			}
+			for _, r := range c.ranges {
+				b.AddRange(r[0], r[1])
+				for idx := r[0]; idx < r[1]; idx++ {
+					set[idx] = true
+				}
+			}
+			want := []int64{}
+			for idx := range set {
+				want = append(want, idx)
+			}
+			slices.Sort(want)
+			if got := bitmapIndexes(b); !slices.Equal(got, want) {
+				t.Fatalf("bitmap holds %d indexes, want %d", len(got), len(want))
+			}
+			if b.Cardinality() != int64(len(want)) {
+				t.Fatalf("cardinality %d, want %d", b.Cardinality(), len(want))
+			}
+			if len(b.containers) != len(c.dense) {
+				t.Fatalf("%d containers, want %d", len(b.containers), len(c.dense))
+			}
+			for i, dense := range c.dense {
+				if (b.containers[i].words != nil) != dense {
+					t.Fatalf("container %d dense is %v, want %v", i, !dense, dense)
+				}
+			}
+		})
+	}
+}
+
+// Not covers [0, n) exactly when n ends partway through a container
+func TestBitmapNotPartialContainer(t *testing.T) {
+	n := int64(2*containerSize + 100)
+	b := NewBitmap()
+	for _, idx := range []int64{0, 99, containerSize, 2*containerSize + 50, 2*containerSize + 100, 5 * containerSize} {
+		b.Add(idx)
+	}
+	not := b.Not(n)
+	if got, want := not.Cardinality(), n-4; got != want {
+		t.Fatalf("complement holds %d indexes, want %d", got, want)
+	}
+	for _, idx := range []int64{1, 98, containerSize - 1, containerSize + 1, 2 * containerSize, n - 1} {
+		if !not.Contains(idx) {
+			t.Fatalf("complement is missing %d", idx)
+		}
+	}
+	for _, idx := range []int64{0, 99, containerSize, 2*containerSize + 50, n, 5 * containerSize} {
+		if not.Contains(idx) {
+			t.Fatalf("complement holds %d", idx)
+		}
+	}
+	if last := not.last(); last != n-1 {
+		t.Fatalf("complement ends at %d, want %d", last, n-1)
+	}
+	if got := NewBitmap().Not(0).Cardinality(); got != 0 {
+		t.Fatalf("Not(0) holds %d indexes", got)
+	}
+}
@@ -0,0 +1,50 @@
+package db
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+)
+
+// DirBlobStore keeps blobs as files under a local directory
+// Meant for tests and single-machine setups, keys map directly to relative paths
+type DirBlobStore struct {
+	dir string
+}
+
+// NewDirBlobStore creates the directory if needed
+func NewDirBlobStore(dir string) (*DirBlobStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &DirBlobStore{dir: dir}, nil
+}
+
+func (d *DirBlobStore) path(key string) string {
+	return filepath.Join(d.dir, filepath.FromSlash(key))
+}
+
+// Put writes to a temporary file first so readers never see a partial blob
+func (d *DirBlobStore) Put(ctx context.Context, key string, data []byte) error {
+	path := d.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (d *DirBlobStore) Get(ctx context.Context, key string) ([]byte, error) {
+	return os.ReadFile(d.path(key))
+}
+
+func (d *DirBlobStore) Delete(ctx context.Context, key string) error {
+	err := os.Remove(d.path(key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,59 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"path"
+
+	"cloud.google.com/go/storage"
+)
+
+// GCSBlobStore keeps blobs as objects in a Google Cloud Storage bucket
+type GCSBlobStore struct {
+	bucket *storage.BucketHandle
+	prefix string
+}
+
+// NewGCSBlobStore stores every blob under prefix in the named bucket
+// The client is owned by the caller and must outlive the store
+func NewGCSBlobStore(client *storage.Client, bucket string, prefix string) *GCSBlobStore {
+	return &GCSBlobStore{
+		bucket: client.Bucket(bucket),
+		prefix: prefix,
+	}
+}
+
+func (g *GCSBlobStore) object(key string) *storage.ObjectHandle {
+	return g.bucket.Object(path.Join(g.prefix, key))
+}
+
+func (g *GCSBlobStore) Put(ctx context.Context, key string, data []byte) error {
+	wc := g.object(key).NewWriter(ctx)
+	if _, err := wc.Write(data); err != nil {
+		wc.Close()
+		slog.Error("Could not write blob to cloud storage", "key", key, "error", err)
+		return err
+	}
+	// the object only exists once the writer is closed
+	return wc.Close()
+}
+
+func (g *GCSBlobStore) Get(ctx context.Context, key string) ([]byte, error) {
+	rc, err := g.object(key).NewReader(ctx)
+	if err != nil {
+		slog.Error("Could not read blob from cloud storage", "key", key, "error", err)
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+func (g *GCSBlobStore) Delete(ctx context.Context, key string) error {
+	err := g.object(key).Delete(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,199 @@
+package db
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+)
+
+// BlobStore is the object store sealed chunks are offloaded to
+// Keys are opaque to the store, implementations must be safe for concurrent use
+type BlobStore interface {
+	Put(ctx context.Context, key string, data []byte) error
+	Get(ctx context.Context, key string) ([]byte, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// HolePuncher is implemented by backends that can release the disk space behind a range
+// Backends without it keep the bytes of offloaded chunks around, reads still go remote
+type HolePuncher interface {
+	PunchHole(off int64, n int64) error
+}
+
+// chunks are addressed by their offset, which is unique for the life of a file
+func chunkKey(offset int64) string {
+	return fmt.Sprintf("chunks/%016x", offset)
+}
+
+// tiering ties a blob store to the LRU cache scans fault offloaded chunks into
+type tiering struct {
+	blobs BlobStore
+	cache *chunkCache
+}
+
+// SetBlobStore enables tiered storage for the database
+// cacheChunks bounds how many offloaded chunks are kept in memory after being faulted in
+func (conn *DB) SetBlobStore(blobs BlobStore, cacheChunks int) {
+	conn.file.mu.Lock()
+	defer conn.file.mu.Unlock()
+	conn.file.tier = &tiering{
+		blobs: blobs,
+		cache: newChunkCache(cacheChunks),
+	}
+}
+
+// entries returns n bytes of entry data for the chunk at chunkPos
+// Local chunks are read from storage, remote ones come from the cache or the blob store
+func (r region) entries(chunkPos int64, header ChunkHeader, n int64) ([]byte, error) {
+	if header.location == Local {
+		return r.read(chunkPos+ChunkHeaderSize, n)
+	}
+	if r.tier == nil {
+		return nil, fmt.Errorf("chunk %d is offloaded but no blob store is configured", chunkPos)
+	}
+	data, err := r.tier.cache.get(chunkPos, func() ([]byte, error) {
+		return r.tier.blobs.Get(context.Background(), chunkKey(chunkPos))
+	})
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) < n {
+		return nil, fmt.Errorf("offloaded chunk %d has %d bytes, want %d", chunkPos, len(data), n)
+	}
+	return data[:n], nil
+}
+
+// Offload moves every sealed chunk of the column to the blob store and releases its disk space
+// The tail chunk still takes appends so it always stays local. Returns the number of chunks moved
+func (column *Column) Offload(ctx context.Context) (int, error) {
+	if !column.checkWritable() {
+		return 0, fmt.Errorf("Bruh")
+	}
+
+	// sealed chunks never change again, so the upload can run without the writer lock
+	unlock := column.file.lockWriter()
+	r, unpin := column.file.Pin()
+	meta := column.metadata()
+	entrySize := 8 + (meta.vectorLength * 4)
+	chunks := []int64{}
+	for chunk := meta.firstChunkOffset; chunk != 0; {
+		header := r.chunkHeader(chunk)
+		if header.nextChunk != 0 && header.location == Local {
+			chunks = append(chunks, chunk)
+		}
+		chunk = header.nextChunk
+	}
+	tier := r.tier
+	unpin()
+	unlock()
+
+	if tier == nil {
+		slog.Error("Cannot offload without a blob store", "column", meta.name.String())
+		return 0, fmt.Errorf("no blob store configured")
+	}
+	// each chunk is copied out under its own pin, so Grow is not held off by the uploads
+	for _, chunk := range chunks {
+		r, unpin := column.file.Pin()
+		header := r.chunkHeader(chunk)
+		if header.location != Local {
+			unpin()
+			continue // a concurrent Offload got here first
+		}
+		data, err := r.read(chunk+ChunkHeaderSize, header.numVectors*entrySize)
+		data = slices.Clone(data)
+		unpin()
+		if err != nil {
+			return 0, err
+		}
+		if err := tier.blobs.Put(ctx, chunkKey(chunk), data); err != nil {
+			slog.Error("Chunk upload failed", "column", meta.name.String(), "chunk", chunk, "error", err)
+			return 0, err
+		}
+	}
+
+	// flip the headers and punch the holes once no reader can be looking at the bytes
+	unlock = column.file.lockWriter()
+	defer unlock()
+	moved := 0
+	err := column.file.exclusive(func(r region) error {
+		puncher, canPunch := r.storage.(HolePuncher)
+		for _, chunk := range chunks {
+			header := r.chunkHeader(chunk)
+			if header.location != Local {
+				continue // a concurrent Offload got here first
+			}
+			header.location = Remote
+			if err := r.writeChunkHeader(chunk, header); err != nil {
+				return err
+			}
+			moved++
+			if canPunch {
+				if err := puncher.PunchHole(chunk+ChunkHeaderSize, ChunkSize-ChunkHeaderSize); err != nil {
+					slog.Warn("Could not release offloaded chunk", "chunk", chunk, "error", err)
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return moved, err
+	}
+	slog.Info("Offloaded chunks", "column", meta.name.String(), "chunks", moved)
+	return moved, column.file.commit()
+}
+
+// chunkCache is an LRU of faulted-in chunk data keyed by chunk offset
+type chunkCache struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List // front is most recently used
+	entries  map[int64]*list.Element
+}
+
+type cachedChunk struct {
+	offset int64
+	data   []byte
+}
+
+func newChunkCache(capacity int) *chunkCache {
+	return &chunkCache{
+		capacity: max(capacity, 1),
+		order:    list.New(),
+		entries:  map[int64]*list.Element{},
+	}
+}
+
+// get returns the cached data for offset, calling load on a miss
+// Slices handed out stay valid after eviction, the cache just drops its reference
+func (c *chunkCache) get(offset int64, load func() ([]byte, error)) ([]byte, error) {
+	c.mu.Lock()
+	if el, ok := c.entries[offset]; ok {
+		c.order.MoveToFront(el)
+		c.mu.Unlock()
+		return el.Value.(*cachedChunk).data, nil
+	}
+	c.mu.Unlock()
+
+	// load outside the lock, two scans missing together just fetch twice
+	data, err := load()
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[offset]; ok {
+		c.order.MoveToFront(el)
+		return el.Value.(*cachedChunk).data, nil
+	}
+	c.entries[offset] = c.order.PushFront(&cachedChunk{offset: offset, data: data})
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cachedChunk).offset)
+	}
+	return data, nil
+}
@@ -0,0 +1,70 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// stallingBlobStore holds every Put until release is closed, giving up after a while
+// so a test against a build that deadlocks fails instead of hanging
+type stallingBlobStore struct {
+	BlobStore
+	putting chan struct{}
+	release chan struct{}
+}
+
+func (s *stallingBlobStore) Put(ctx context.Context, key string, data []byte) error {
+	s.putting <- struct{}{}
+	select {
+	case <-s.release:
+		return s.BlobStore.Put(ctx, key, data)
+	case <-time.After(30 * time.Second):
+		return errors.New("upload never released")
+	}
+}
+
+// Uploads run without a pin, so the file grows while one is stalled
+func TestOffloadUploadsUnpinned(t *testing.T) {
+	conn := openTestDB(t)
+	// entries this wide fill a chunk in about a thousand appends
+	col := newTestColumn(t, conn, "wide", 16384)
+	appendTestVectors(t, col, 1100)
+	dir, err := NewDirBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	blobs := &stallingBlobStore{BlobStore: dir, putting: make(chan struct{}), release: make(chan struct{})}
+	conn.SetBlobStore(blobs, 1)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := col.Offload(context.Background())
+		done <- err
+	}()
+	<-blobs.putting
+	tbl, err := conn.AddTable("grow", 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every column takes a chunk, enough of them grows the file
+	for i := 0; i < 4; i++ {
+		if _, err := tbl.AddColumn(fmt.Sprintf("grow%d", i), 4); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(blobs.release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	vecs, err := fetchRange(col, 0, 1100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vecs) != 1100 || vecs[1099].features[0] != 1099 || vecs[0].features[16383] != 0 {
+		t.Fatalf("read %d entries back after the offload", len(vecs))
+	}
+}
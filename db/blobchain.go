@@ -0,0 +1,172 @@
+package db
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+)
+
+/*
+Blob chains hold opaque payloads such as index structures in the data region.
+They reuse the column chunk layout, except that the header's vector count holds
+how many payload bytes follow it in that chunk. Chunks released by a blob go on
+a free list persisted in the catalog, and are handed out again before the data
+cursor moves.
+*/
+
+const (
+	blobChunkCapacity     = ChunkSize - ChunkHeaderSize
+	freeListRecordVersion = 1
+)
+
+const (
+	freeListAdd byte = iota
+	freeListRemove
+)
+
+func freeListRecord(op byte, offsets []int64) catalogRecord {
+	payload := []byte{op}
+	for _, off := range offsets {
+		payload = ByteOrder.AppendUint64(payload, uint64(off))
+	}
+	return catalogRecord{
+		kind:    recordFreeChunks,
+		version: freeListRecordVersion,
+		owner:   dbPropertyOwner,
+		payload: payload,
+	}
+}
+
+// applyFreeList replays a free list record
+func (conn *DB) applyFreeList(rec catalogRecord) error {
+	if rec.version != freeListRecordVersion || len(rec.payload) < 1 || (len(rec.payload)-1)%8 != 0 {
+		return fmt.Errorf("malformed free list record")
+	}
+	for p := rec.payload[1:]; len(p) > 0; p = p[8:] {
+		off := int64(ByteOrder.Uint64(p))
+		if rec.payload[0] == freeListAdd {
+			conn.freeChunks = append(conn.freeChunks, off)
+		} else {
+			conn.freeChunks = slices.DeleteFunc(conn.freeChunks, func(o int64) bool { return o == off })
+		}
+	}
+	return nil
+}
+
+// allocChunk hands out a chunk for a column or a blob, preferring the free list
+// Caller must hold the writer lock and no pins, since the file may grow
+func (conn *DB) allocChunk() (int64, error) {
+	if n := len(conn.freeChunks); n > 0 {
+		off := conn.freeChunks[n-1]
+		conn.freeChunks = conn.freeChunks[:n-1]
+		r, unpin := conn.file.Pin()
+		defer unpin()
+		if err := conn.appendRecord(r, freeListRecord(freeListRemove, []int64{off})); err != nil {
+			conn.freeChunks = append(conn.freeChunks, off)
+			return 0, err
+		}
+		return off, nil
+	}
+
+	r, unpin := conn.file.Pin()
+	pos := r.dataCursor()
+	needsGrow := pos+ChunkSize > r.size()
+	unpin()
+	if needsGrow {
+		if err := conn.file.Grow(ChunkSize * 4); err != nil {
+			return 0, err
+		}
+	}
+	r, unpin = conn.file.Pin()
+	defer unpin()
+	if err := r.setDataCursor(pos+ChunkSize, RIGHT); err != nil {
+		return 0, err
+	}
+	return pos, nil
+}
+
+// writeBlob stores data in a fresh chain and returns its first chunk
+// Caller must hold the writer lock and no pins
+func (conn *DB) writeBlob(data []byte) (int64, error) {
+	numChunks := max(1, (int64(len(data))+blobChunkCapacity-1)/blobChunkCapacity)
+	offsets := make([]int64, 0, numChunks)
+	for range numChunks {
+		off, err := conn.allocChunk()
+		if err != nil {
+			conn.freeBlobChunks(offsets)
+			return 0, err
+		}
+		offsets = append(offsets, off)
+	}
+
+	r, unpin := conn.file.Pin()
+	defer unpin()
+	for i, off := range offsets {
+		part := data[min(int64(i)*blobChunkCapacity, int64(len(data))):min(int64(i+1)*blobChunkCapacity, int64(len(data)))]
+		header := ChunkHeader{numVectors: int64(len(part))}
+		if i+1 < len(offsets) {
+			header.nextChunk = offsets[i+1]
+		}
+		if err := r.write(off+ChunkHeaderSize, part); err != nil {
+			return 0, err
+		}
+		if err := r.writeChunkHeader(off, header); err != nil {
+			return 0, err
+		}
+	}
+	return offsets[0], nil
+}
+
+// blobChunks returns the offsets of every chunk in the chain starting at first
+func (r region) blobChunks(first int64) []int64 {
+	offsets := []int64{}
+	for chunk := first; chunk != 0; chunk = r.chunkHeader(chunk).nextChunk {
+		if slices.Contains(offsets, chunk) {
+			slog.Error("Blob chain loops", "first", first, "chunk", chunk)
+			break
+		}
+		offsets = append(offsets, chunk)
+	}
+	return offsets
+}
+
+// readBlob copies the payload of the chain starting at first
+func (r region) readBlob(first int64) ([]byte, error) {
+	data := []byte{}
+	for _, chunk := range r.blobChunks(first) {
+		header := r.chunkHeader(chunk)
+		payload, err := r.read(chunk+ChunkHeaderSize, min(header.numVectors, blobChunkCapacity))
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, payload...)
+	}
+	return data, nil
+}
+
+// freeBlob releases the chain starting at first back to the free list
+// Caller must hold the writer lock
+func (conn *DB) freeBlob(first int64) error {
+	r, unpin := conn.file.Pin()
+	offsets := r.blobChunks(first)
+	unpin()
+	return conn.freeBlobChunks(offsets)
+}
+
+// helper that punches out and records released chunks, caller must hold the writer lock
+func (conn *DB) freeBlobChunks(offsets []int64) error {
+	if len(offsets) == 0 {
+		return nil
+	}
+	return conn.file.exclusive(func(r region) error {
+		if puncher, ok := r.storage.(HolePuncher); ok {
+			for _, off := range offsets {
+				if err := puncher.PunchHole(off+ChunkHeaderSize, blobChunkCapacity); err != nil {
+					slog.Warn("Could not release freed chunk", "chunk", off, "error", err)
+				}
+			}
+		}
+		conn.freeChunks = append(conn.freeChunks, offsets...)
+		return conn.appendRecord(r, freeListRecord(freeListAdd, offsets))
+	})
+}
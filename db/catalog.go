@@ -0,0 +1,236 @@
+package db
+
+import (
+	"fmt"
+	"log/slog"
+)
+
+/*
+The catalog extension region is a small append-only log of typed records kept
+in the last 4MB of the metadata region, below the data region:
+
+	[ExtensionRegionStart]   magic "KENEXT01", cursor (next free byte)
+	records...               kind uint16, version uint16, length uint32, owner int64, payload
+
+Owner is the catalog offset of the table or column a record belongs to (0 for
+the DB itself). Records are replayed in order on open so later ones win.
+
+The records live in one of two slots splitting the region in half, the one the
+cursor points into. When it fills up the log is rewritten from the in-memory
+state into the other slot and the cursor switched over in one header write, so
+a crash mid compaction still replays the old log.
+*/
+
+const (
+	ExtensionRegionSize  = 4 * 1024 * 1024
+	ExtensionRegionStart = DataRegionStart - ExtensionRegionSize
+	extensionHeaderSize  = 16
+	recordHeaderSize     = 16
+
+	// extensionSlotB is where the second log slot starts, the first starts after the header
+	extensionSlotB = ExtensionRegionStart + ExtensionRegionSize/2
+)
+
+var extensionMagic = []byte("KENEXT01")
+
+type recordKind uint16
+
+const (
+	recordColumnSchema recordKind = iota + 1
+	recordProperty
+	recordIndex
+	recordFreeChunks
+)
+
+type catalogRecord struct {
+	kind    recordKind
+	version uint16
+	owner   int64
+	payload []byte
+}
+
+// helper returning the extension log cursor, 0 when the region was never initialized
+func (r region) extensionCursor() int64 {
+	header := r.slice(ExtensionRegionStart, extensionHeaderSize)
+	if string(header[:8]) != string(extensionMagic) {
+		return 0
+	}
+	return int64(ByteOrder.Uint64(header[8:]))
+}
+
+// extensionSlot returns where the log the cursor points into starts and how far it may
+// extend. A log in the first slot stops short of the second so its cursor never
+// reads as an empty log there
+func extensionSlot(cursor int64) (start, limit int64) {
+	if cursor >= extensionSlotB {
+		return extensionSlotB, DataRegionStart
+	}
+	return ExtensionRegionStart + extensionHeaderSize, extensionSlotB - 1
+}
+
+func (r region) setExtensionCursor(cursor int64) error {
+	header := make([]byte, extensionHeaderSize)
+	copy(header, extensionMagic)
+	ByteOrder.PutUint64(header[8:], uint64(cursor))
+	return r.write(ExtensionRegionStart, header)
+}
+
+// readRecords replays the extension log
+func (r region) readRecords() []catalogRecord {
+	records := []catalogRecord{}
+	cursor := r.extensionCursor()
+	start, _ := extensionSlot(cursor)
+	for off := start; off+recordHeaderSize <= cursor; {
+		header := r.slice(off, recordHeaderSize)
+		length := int64(ByteOrder.Uint32(header[4:8]))
+		if off+recordHeaderSize+length > cursor {
+			slog.Warn("Truncated catalog record, ignoring the rest of the log", "offset", off)
+			break
+		}
+		records = append(records, catalogRecord{
+			kind:    recordKind(ByteOrder.Uint16(header[0:2])),
+			version: ByteOrder.Uint16(header[2:4]),
+			owner:   int64(ByteOrder.Uint64(header[8:16])),
+			payload: append([]byte(nil), r.slice(off+recordHeaderSize, length)...),
+		})
+		off += recordHeaderSize + length
+	}
+	return records
+}
+
+func encodeRecord(rec catalogRecord) []byte {
+	buf := make([]byte, recordHeaderSize+len(rec.payload))
+	ByteOrder.PutUint16(buf[0:], uint16(rec.kind))
+	ByteOrder.PutUint16(buf[2:], rec.version)
+	ByteOrder.PutUint32(buf[4:], uint32(len(rec.payload)))
+	ByteOrder.PutUint64(buf[8:], uint64(rec.owner))
+	copy(buf[recordHeaderSize:], rec.payload)
+	return buf
+}
+
+// appendRecord adds a record to the extension log, compacting it first if it is full
+// Caller must hold the writer lock
+func (conn *DB) appendRecord(r region, rec catalogRecord) error {
+	if r.metadataCursor() > ExtensionRegionStart {
+		slog.Error("Catalog overlaps the extension region, cannot store records")
+		return fmt.Errorf("catalog extension region unavailable")
+	}
+	buf := encodeRecord(rec)
+	cursor := r.extensionCursor()
+	if cursor == 0 {
+		cursor = ExtensionRegionStart + extensionHeaderSize
+	}
+	if _, limit := extensionSlot(cursor); cursor+int64(len(buf)) > limit {
+		// the in-memory state already includes rec, so compaction rewrites it too
+		return conn.compactRecords(r, cursor)
+	}
+	if err := r.write(cursor, buf); err != nil {
+		return err
+	}
+	// the cursor moves last, a torn record is never replayed
+	return r.setExtensionCursor(cursor + int64(len(buf)))
+}
+
+// compactRecords rewrites the live records into the slot cursor is not in
+// The old log stays intact until the cursor moves, see the region layout above
+// Caller must hold the writer lock
+func (conn *DB) compactRecords(r region, cursor int64) error {
+	next := int64(extensionSlotB)
+	if start, _ := extensionSlot(cursor); start == extensionSlotB {
+		next = ExtensionRegionStart + extensionHeaderSize
+	}
+	_, limit := extensionSlot(next)
+	for _, rec := range conn.liveRecords() {
+		buf := encodeRecord(rec)
+		if next+int64(len(buf)) > limit {
+			slog.Error("Catalog extension region is full")
+			return fmt.Errorf("catalog extension region full")
+		}
+		if err := r.write(next, buf); err != nil {
+			return err
+		}
+		next += int64(len(buf))
+	}
+	return r.setExtensionCursor(next)
+}
+
+// liveRecords regenerates the current record set from the in-memory catalog
+func (conn *DB) liveRecords() []catalogRecord {
+	records := conn.props.records(dbPropertyOwner)
+	if len(conn.freeChunks) > 0 {
+		records = append(records, freeListRecord(freeListAdd, conn.freeChunks))
+	}
+	conn.mu.RLock()
+	defer conn.mu.RUnlock()
+	for _, tbl := range conn.tables {
+		records = append(records, tbl.props.records(tbl.meta.offset)...)
+		tbl.mu.RLock()
+		for _, col := range tbl.columns {
+			if schema, ok := col.storedSchema(); ok {
+				records = append(records, schema.record(col.meta.offset))
+			}
+			records = append(records, col.indexes.records(col.meta.offset)...)
+		}
+		tbl.mu.RUnlock()
+	}
+	return records
+}
+
+// applyRecords attaches replayed records to the loaded tables and columns
+func (conn *DB) applyRecords(records []catalogRecord) {
+	columns := map[int64]*Column{}
+	tables := map[int64]*Table{}
+	for _, tbl := range conn.tables {
+		tables[tbl.meta.offset] = tbl
+		for _, col := range tbl.columns {
+			columns[col.meta.offset] = col
+		}
+	}
+	for _, rec := range records {
+		switch rec.kind {
+		case recordColumnSchema:
+			col, ok := columns[rec.owner]
+			if !ok {
+				slog.Warn("Schema record for unknown column", "offset", rec.owner)
+				continue
+			}
+			schema, err := decodeSchema(rec)
+			if err != nil {
+				slog.Warn("Skipping unreadable schema record", "column", col.meta.name.String(), "error", err)
+				continue
+			}
+			col.schema = &schema
+		case recordProperty:
+			op, key, value, err := decodeProperty(rec)
+			if err != nil {
+				slog.Warn("Skipping unreadable property record", "owner", rec.owner, "error", err)
+				continue
+			}
+			props := &conn.props
+			if rec.owner != dbPropertyOwner {
+				tbl, ok := tables[rec.owner]
+				if !ok {
+					slog.Warn("Property record for unknown table", "offset", rec.owner)
+					continue
+				}
+				props = &tbl.props
+			}
+			props.apply(op, key, value)
+		case recordIndex:
+			col, ok := columns[rec.owner]
+			if !ok {
+				slog.Warn("Index record for unknown column", "offset", rec.owner)
+				continue
+			}
+			if err := col.applyIndexRecord(rec); err != nil {
+				slog.Warn("Skipping unreadable index record", "column", col.meta.name.String(), "error", err)
+			}
+		case recordFreeChunks:
+			if err := conn.applyFreeList(rec); err != nil {
+				slog.Warn("Skipping unreadable free list record", "error", err)
+			}
+		default:
+			slog.Warn("Skipping unknown catalog record", "kind", rec.kind)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package db
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fillExtensionSlot rewrites the schema of col with large descriptions until the log
+// has compacted into the second slot, returning the last description
+func fillExtensionSlot(t *testing.T, col *Column) string {
+	t.Helper()
+	var description string
+	for i := 0; i < 3*ExtensionRegionSize/2/60000; i++ {
+		description = strings.Repeat(string(rune('a'+i%26)), 60000)
+		if err := col.SetSchema(Schema{Metric: L2, Description: description}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return description
+}
+
+// Records written before and through compactions replay after a reopen
+func TestCompactedCatalogSurvivesReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.ken")
+	conn := openTestFile(t, path)
+	tbl, err := conn.AddTable("t", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tbl.AddColumnWithSchema("c", 4, Schema{Metric: DotProduct, ModelID: "m"}); err != nil {
+		t.Fatal(err)
+	}
+	filler, err := tbl.AddColumn("filler", 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	description := fillExtensionSlot(t, filler)
+	r, unpin := conn.file.Pin()
+	cursor := r.extensionCursor()
+	unpin()
+	if cursor < extensionSlotB {
+		t.Fatalf("extension cursor %d is still in the first slot", cursor)
+	}
+	if err := conn.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	conn = openTestFile(t, path)
+	defer conn.Close()
+	tbl, _ = conn.GetTableByName("t")
+	col, _ := tbl.GetColumnByName("c")
+	if schema := col.Schema(); schema.Metric != DotProduct || schema.ModelID != "m" {
+		t.Fatalf("schema is %+v after reopen", schema)
+	}
+	filler, _ = tbl.GetColumnByName("filler")
+	if got := filler.Schema().Description; got != description {
+		t.Fatalf("description reads %.8q... after reopen, want %.8q...", got, description)
+	}
+}
+
+// A compaction cut short leaves the log the cursor points at untouched
+func TestInterruptedCompactionKeepsOldLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.ken")
+	conn := openTestFile(t, path)
+	tbl, err := conn.AddTable("t", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tbl.AddColumnWithSchema("c", 4, Schema{Metric: L2, ModelID: "m"}); err != nil {
+		t.Fatal(err)
+	}
+	// a crash after compaction started writing the other slot, before the cursor moved
+	r, unpin := conn.file.Pin()
+	start, _ := extensionSlot(r.extensionCursor())
+	other := int64(extensionSlotB)
+	if start == extensionSlotB {
+		other = ExtensionRegionStart + extensionHeaderSize
+	}
+	err = r.write(other, []byte(strings.Repeat("\xff", 4096)))
+	unpin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	conn = openTestFile(t, path)
+	defer conn.Close()
+	tbl, _ = conn.GetTableByName("t")
+	col, _ := tbl.GetColumnByName("c")
+	if schema := col.Schema(); schema.Metric != L2 || schema.ModelID != "m" {
+		t.Fatalf("schema is %+v after an interrupted compaction", schema)
+	}
+}
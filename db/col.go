@@ -74,107 +74,135 @@ func readVec(b []byte, length int) []float32 {
 }
 
 func (column *Column) AddVector(timestamp int64, vector WriteColumnOptions) error {
+	if !column.checkWritable() {
+		return fmt.Errorf("Bruh")
+	}
+	unlock := column.file.lockWriter()
+	defer unlock()
+
+	// only the writer mutates meta, so holding the writer lock we can read it freely
 	lenInt64, err := safeParse(vector, column.meta.vectorLength)
 	if err != nil {
 		return err
 	}
-	b := column.file.Bytes()
+	// build the entry up front so it lands in storage with a single write
+	entry := make([]byte, 8+lenInt64)
+	ByteOrder.PutUint64(entry, uint64(timestamp))
+	switch vector.Kind {
+	case Bytes:
+		writeVec(entry[8:], vector.bytes)
+	case Floats:
+		writeVec(entry[8:], vector.floats)
+	default:
+		return fmt.Errorf("Bruh")
+	}
+	if err := column.Schema().checkVector(readVec(entry[8:], int(column.meta.vectorLength))); err != nil {
+		slog.Error("Vector does not match column schema", "column", column.meta.name.String(), "error", err)
+		return err
+	}
+
+	r, unpin := column.file.Pin()
 	chunkPos := column.meta.firstChunkOffset
-	header := ReadChunkHeader(b, column.meta.firstChunkOffset)
+	header := r.chunkHeader(column.meta.firstChunkOffset)
 	for header.nextChunk != 0 {
 		chunkPos = header.nextChunk
-		header = ReadChunkHeader(b, header.nextChunk)
+		header = r.chunkHeader(header.nextChunk)
 	}
 	entrySize := int64(8) + (column.meta.vectorLength * 4) // timestamp + vector
 	vectorPos := chunkPos + ChunkHeaderSize + (entrySize * header.numVectors)
 	if (vectorPos+lenInt64+8)-chunkPos <= ChunkSize {
+		defer unpin()
 		// we have enough space in this chunk to add the vector
-		ByteOrder.PutUint64(b[vectorPos:], uint64(timestamp))
-		switch vector.Kind {
-		case Bytes:
-			writeVec(b[vectorPos+8:], vector.bytes)
-		case Floats:
-			writeVec(b[vectorPos+8:], vector.floats)
-		default:
-			return fmt.Errorf("Bruh")
+		if err := r.write(vectorPos, entry); err != nil {
+			return err
 		}
-		column.meta.numVectors++
 		header.numVectors++
-		// update mmap
-		column.meta.WriteTo(b)
-		header.WriteTo(b, chunkPos)
-		return nil
+		// update storage, the entry is fully written before readers can see the new count
+		if err := r.writeChunkHeader(chunkPos, header); err != nil {
+			return err
+		}
+		if err := column.publish(r); err != nil {
+			return err
+		}
+		column.updateIndexes(r)
+		return column.file.commit()
 	}
 	// if we do not have enough space, then we must start a new chunk,
 	// and add the vector to it
-	newChunkPos := GetDataCursorPos(b)
-	// Check if file needs to grow
-	if newChunkPos+ChunkSize > int64(len(b)) {
-		if err := column.file.Grow(ChunkSize * 4); err != nil {
-			return err
-		}
-		b = column.file.Bytes() // refresh after grow
+	unpin() // the file may grow, which waits for every pin, including ours
+	newChunkPos, err := column.conn.allocChunk()
+	if err != nil {
+		return err
 	}
+	r, unpin = column.file.Pin()
+	defer unpin()
 	header.nextChunk = newChunkPos
 
+	// write vector
+	if err := r.write(newChunkPos+ChunkHeaderSize, entry); err != nil {
+		return err
+	}
+
+	// update storage after successful write
 	newChunkHeader := ChunkHeader{
 		nextChunk:  0,
-		numVectors: 0,
+		numVectors: 1,
 	}
-	// set new chunk
-	newChunkHeader.WriteTo(b, newChunkPos)
-
-	// write vector
-	ByteOrder.PutUint64(b[newChunkPos+ChunkHeaderSize:], uint64(timestamp))
-	switch vector.Kind {
-	case Bytes:
-		writeVec(b[newChunkPos+ChunkHeaderSize+8:], vector.bytes)
-	case Floats:
-		writeVec(b[newChunkPos+ChunkHeaderSize+8:], vector.floats)
-	default:
-		return fmt.Errorf("Bruh")
+	if err := r.writeChunkHeader(newChunkPos, newChunkHeader); err != nil {
+		return err
+	}
+	// also update the old header
+	if err := r.writeChunkHeader(chunkPos, header); err != nil {
+		return err
+	}
+	if err := column.publish(r); err != nil {
+		return err
 	}
+	column.updateIndexes(r)
+	return column.file.commit()
+}
 
-	// update mmap after successful write
-	newChunkHeader.numVectors++
+// publish bumps the vector count and makes the new entry visible to readers
+// Caller must hold the writer lock
+func (column *Column) publish(r region) error {
+	column.mu.Lock()
+	defer column.mu.Unlock()
 	column.meta.numVectors++
-	column.meta.WriteTo(b)
-	newChunkHeader.WriteTo(b, newChunkPos)
-	// also update the old header
-	header.WriteTo(b, chunkPos)
-	return nil
+	return r.writeColumnMetadata(column.meta)
 }
 
-func (column *Column) forEach(fn func(idx int64, ts uint64, vec []float32) bool) {
-	b := column.file.Bytes()
-	entrySize := 8 + (column.meta.vectorLength * 4)
+// forEach visits the entries in the column's view at call time,
+// so vectors appended while iterating are not observed
+func (column *Column) forEach(fn func(idx int64, ts uint64, vec []float32) bool) error {
+	view := column.view()
+	r, unpin := column.file.Pin()
+	defer unpin()
+	entrySize := 8 + (view.meta.vectorLength * 4)
 	idx := int64(0)
 
-	currChunk := column.meta.firstChunkOffset
-	for currChunk != 0 {
-		header := ReadChunkHeader(b, currChunk)
-		for i := int64(0); i < header.numVectors; i++ {
-			entryOffset := currChunk + ChunkHeaderSize + (i * entrySize)
-			ts := ByteOrder.Uint64(b[entryOffset:])
-			vec := readVec(b[entryOffset+8:], int(column.meta.vectorLength))
+	return view.walk(r, func(currChunk int64, data []byte, count int64) bool {
+		for i := int64(0); i < count; i++ {
+			entryOffset := i * entrySize
+			ts := ByteOrder.Uint64(data[entryOffset:])
+			vec := readVec(data[entryOffset+8:], int(view.meta.vectorLength))
 			if !fn(idx, ts, vec) {
-				return
+				return false
 			}
 			idx++
 		}
-		currChunk = header.nextChunk
-	}
+		return true
+	})
 }
 
-func (column *Column) PrintColumnEntries() {
-	column.forEach(func(idx int64, ts uint64, vec []float32) bool {
+func (column *Column) PrintColumnEntries() error {
+	return column.forEach(func(idx int64, ts uint64, vec []float32) bool {
 		fmt.Println("Timestamp:", ts, "Vector:", vec)
 		return true
 	})
 }
 
 func (column *Column) Length() int {
-	return int(column.meta.numVectors)
+	return int(column.metadata().numVectors)
 }
 
 // TODO: Implement some mathemtical functions - SUM, AVG, MIN, MAX
@@ -0,0 +1,62 @@
+package db
+
+import "testing"
+
+// freeTestChunk writes a one chunk blob and frees it, returning the chunk
+func freeTestChunk(t *testing.T, conn *DB) int64 {
+	t.Helper()
+	unlock := conn.file.lockWriter()
+	defer unlock()
+	first, err := conn.writeBlob([]byte("scratch"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.freeBlob(first); err != nil {
+		t.Fatal(err)
+	}
+	return first
+}
+
+// Column chunks come off the free list before the file is extended
+func TestColumnChunksReuseFreedBlobs(t *testing.T) {
+	conn := openTestDB(t)
+	tbl, err := conn.AddTable("t", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	freed := freeTestChunk(t, conn)
+	r, unpin := conn.file.Pin()
+	cursor := r.dataCursor()
+	unpin()
+	col, err := tbl.AddColumn("wide", 16384)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if col.meta.firstChunkOffset != freed {
+		t.Fatalf("column starts at chunk %d, want the freed chunk %d", col.meta.firstChunkOffset, freed)
+	}
+
+	// entries this wide fill a chunk in 1023 appends, the next one starts a chunk
+	appendTestVectors(t, col, 1023)
+	freed = freeTestChunk(t, conn)
+	appendTestVectors(t, col, 10)
+	r, unpin = conn.file.Pin()
+	next := r.chunkHeader(col.meta.firstChunkOffset).nextChunk
+	grown := r.dataCursor() - cursor
+	unpin()
+	if next != freed {
+		t.Fatalf("second chunk is %d, want the freed chunk %d", next, freed)
+	}
+	if grown != ChunkSize {
+		t.Fatalf("data cursor moved %d bytes, want one chunk for the scratch blobs", grown)
+	}
+
+	vecs, err := fetchRange(col, 0, 1033)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vecs) != 1033 || vecs[1032].features[0] != 1032 {
+		t.Fatalf("read %d entries back across the reused chunks", len(vecs))
+	}
+}
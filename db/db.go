@@ -9,22 +9,30 @@ import (
 // When the file exists, it will connect to it, if not it creates a new one
 // Do not forget to defer conn.Close() immediatley after!
 func InitDB(filename string) (*DB, error) {
-	path := fmt.Sprintf("resources/%s.ken", filename)
-	initialSize := int64(DataRegionStart + (ChunkSize * 4)) //~270MB
-
-	file, err := OpenMMapFile(path, initialSize)
+	file, err := OpenMMapFile(Path(filename), InitialFileSize)
 	if err != nil {
 		slog.Error("Failed to open mmap file", "file", filename, "error", err)
 		return nil, err
 	}
+	return Open(file)
+}
 
-	b := file.Bytes()
-	cursorPos := GetMetadataCursorPos(b)
+// Open initializes a database connection on top of any storage backend
+// An all-zero backend is formatted as a new database, anything else is loaded
+func Open(backend Storage) (*DB, error) {
+	file := newStore(backend)
+	r, unpin := file.Pin()
+	defer unpin()
+	cursorPos := r.metadataCursor()
 	if cursorPos == 0 {
 		// this means we started a new file, so we set the write offset to 16,
 		// set the num tables to 0, and return an empty DB
-		ByteOrder.PutUint64(b[0:8], MetadataRegionStart) // cursor at position 16
-		ByteOrder.PutUint64(b[8:16], DataRegionStart)    // data region starts 16MB in
+		if err := r.putUint64(0, MetadataRegionStart); err != nil { // cursor at position 16
+			return nil, err
+		}
+		if err := r.putUint64(8, DataRegionStart); err != nil { // data region starts 16MB in
+			return nil, err
+		}
 		return &DB{
 			tables: []*Table{},
 			file:   file,
@@ -32,35 +40,48 @@ func InitDB(filename string) (*DB, error) {
 	}
 
 	// In the case that the file already exists, we must load tables and columns
-	return &DB{
-		tables: loadTables(file),
-		file:   file,
-	}, nil
+	conn := &DB{file: file}
+	conn.tables = loadTables(r, conn)
+	conn.applyRecords(r.readRecords())
+	return conn, nil
+}
+
+// Path returns the location of the .ken file backing the named database
+func Path(filename string) string {
+	return fmt.Sprintf("resources/%s.ken", filename)
 }
 
-// helper to load all table structs by traversing the mapped bytes
-func loadTables(file *MMapFile) []*Table {
+// helper to load all table structs by traversing the catalog
+func loadTables(r region, conn *DB) []*Table {
 	tables := []*Table{}
-	b := file.Bytes()
-	cursorPos := GetMetadataCursorPos(b)
+	cursorPos := r.metadataCursor()
 	offset := int64(MetadataRegionStart)
 	for offset < cursorPos {
-		currTable := Table{
-			meta:    ReadTableMetadata(b, offset),
+		currTable := &Table{
+			meta:    r.tableMetadata(offset),
 			columns: []*Column{},
-			file:    file,
+			file:    conn.file,
+			conn:    conn,
 		}
 		offset += TableMetadataSize
 
-		for range currTable.meta.numColumns {
-			columnMeta := ReadColumnMetadata(b, offset)
+		for i := range currTable.meta.numColumns {
+			columnMeta := r.columnMetadata(offset + i*ColumnMetadataSize)
+			// slots are filled in order and every added column owns a chunk,
+			// so the first slot without one marks the end of the table
+			if columnMeta.firstChunkOffset == 0 {
+				break
+			}
 			currTable.columns = append(currTable.columns, &Column{
-				meta: columnMeta,
-				file: file,
+				meta:    columnMeta,
+				file:    conn.file,
+				conn:    conn,
+				indexes: newIndexSet(),
+				stats:   &columnStats{},
 			})
-			offset += ColumnMetadataSize
 		}
-		tables = append(tables, &currTable)
+		offset += currTable.meta.numColumns * ColumnMetadataSize
+		tables = append(tables, currTable)
 	}
 	return tables
 }
@@ -68,6 +89,12 @@ func loadTables(file *MMapFile) []*Table {
 // Close will terminate a database connection and flush the mapped bytes to disk
 // Call this anytime you make a call to InitDB
 func (conn *DB) Close() error {
+	unlock := conn.file.lockWriter()
+	defer unlock()
+	// an index that fails to save is rebuilt from its last blob on the next load
+	if err := conn.saveAllIndexes(); err != nil {
+		slog.Warn("Could not save indexes on close", "error", err)
+	}
 	err := conn.file.Close()
 	if err != nil {
 		slog.Error("Unable to flush to DB", "error", err)
@@ -77,29 +104,45 @@ func (conn *DB) Close() error {
 }
 
 func (conn *DB) AddTable(tablename string, numColumns int) (*Table, error) {
-	b := conn.file.Bytes()
-	cursorPos := GetMetadataCursorPos(b)
+	unlock := conn.file.lockWriter()
+	defer unlock()
+	r, unpin := conn.file.Pin()
+	defer unpin()
+	cursorPos := r.metadataCursor()
+	if cursorPos+TableMetadataSize+int64(numColumns*ColumnMetadataSize) > ExtensionRegionStart {
+		slog.Error("Catalog is full", "table", tablename, "columns", numColumns)
+		return nil, fmt.Errorf("catalog full")
+	}
 	meta := TableMetadata{
 		name:       MakeName(tablename),
 		numColumns: int64(numColumns),
 		offset:     cursorPos,
 	}
-	meta.WriteTo(b)
+	if err := r.writeTableMetadata(meta); err != nil {
+		return nil, err
+	}
 
-	newTable := Table{
+	newTable := &Table{
 		meta:    meta,
 		columns: []*Column{},
 		file:    conn.file,
+		conn:    conn,
 	}
-	conn.tables = append(conn.tables, &newTable)
+	conn.mu.Lock()
+	conn.tables = append(conn.tables, newTable)
+	conn.mu.Unlock()
 	// update metadata cursor position
 	cursorPos += TableMetadataSize
 	cursorPos += int64(numColumns * ColumnMetadataSize)
-	SetMetadataCursorPos(b, cursorPos, RIGHT)
-	return &newTable, nil
+	if err := r.setMetadataCursor(cursorPos, RIGHT); err != nil {
+		return nil, err
+	}
+	return newTable, conn.file.commit()
 }
 
 func (conn *DB) GetTableByName(name string) (*Table, bool) {
+	conn.mu.RLock()
+	defer conn.mu.RUnlock()
 	for _, table := range conn.tables {
 		if table.meta.name.String() == name {
 			return table, true
@@ -109,6 +152,8 @@ func (conn *DB) GetTableByName(name string) (*Table, bool) {
 }
 
 func (conn *DB) ListTableNames() []string {
+	conn.mu.RLock()
+	defer conn.mu.RUnlock()
 	names := []string{}
 	for _, table := range conn.tables {
 		names = append(names, table.meta.name.String())
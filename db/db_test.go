@@ -0,0 +1,149 @@
+package db
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// openTestDB opens a fresh database on an mmap file in a temporary directory
+func openTestDB(t *testing.T) *DB {
+	t.Helper()
+	conn := openTestFile(t, filepath.Join(t.TempDir(), "test.ken"))
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// openTestFile opens the database at path, creating it if missing
+// Unlike openTestDB the caller closes it, so tests can reopen the file
+func openTestFile(t *testing.T, path string) *DB {
+	t.Helper()
+	file, err := OpenMMapFile(path, InitialFileSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+// newTestColumn adds a table holding a single column of the given length
+func newTestColumn(t *testing.T, conn *DB, name string, vectorLength int64) *Column {
+	t.Helper()
+	tbl, err := conn.AddTable(name, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	col, err := tbl.AddColumn(name, vectorLength)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return col
+}
+
+// appendTestVectors appends n vectors to col, entry i at timestamp i with every
+// feature set to i
+func appendTestVectors(t *testing.T, col *Column, n int) {
+	t.Helper()
+	start := col.Length()
+	vec := make([]float32, col.meta.vectorLength)
+	for i := start; i < start+n; i++ {
+		for j := range vec {
+			vec[j] = float32(i)
+		}
+		opts := WriteColumnOptions{Kind: Floats}
+		if err := opts.AddFloats(vec); err != nil {
+			t.Fatal(err)
+		}
+		if err := col.AddVector(int64(i), opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// fetchRange returns copies of the entries of col with start <= timestamp < end
+func fetchRange(col *Column, start, end int64) ([]Vector, error) {
+	pool := VariablePool{}
+	if err := col.Select(start, end, "range", pool); err != nil {
+		return nil, err
+	}
+	return col.Fetch("range", pool)
+}
+
+// Appends, snapshots and file growth run concurrently, run with -race
+func TestConcurrentAppendSnapshotGrow(t *testing.T) {
+	conn := openTestDB(t)
+	tbl, err := conn.AddTable("t", 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	col, err := tbl.AddColumn("c", 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const appends = 2000
+
+	var wg sync.WaitGroup
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		vec := make([]float32, 4)
+		for i := 0; i < appends; i++ {
+			vec[3] = float32(i)
+			opts := WriteColumnOptions{Kind: Floats}
+			opts.AddFloats(vec)
+			if err := col.AddVector(int64(i), opts); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	go func() {
+		// every column takes a chunk, enough of them grows the file
+		defer wg.Done()
+		for i := 0; i < 6; i++ {
+			if _, err := tbl.AddColumn(fmt.Sprintf("grow%d", i), 4); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			snap := conn.Snapshot()
+			frozen, ok := snap.Column(col)
+			if !ok {
+				t.Error("snapshot is missing the column")
+				return
+			}
+			n := frozen.Length()
+			vecs, err := fetchRange(frozen, 0, appends)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if len(vecs) != n {
+				t.Errorf("snapshot of %d entries fetched %d", n, len(vecs))
+				return
+			}
+			for j, vec := range vecs {
+				if vec.timestamp != uint64(j) || vec.features[3] != float32(j) {
+					t.Errorf("entry %d read back as %d %v", j, vec.timestamp, vec.features)
+					return
+				}
+			}
+		}
+	}()
+	wg.Wait()
+
+	if col.Length() != appends {
+		t.Fatalf("column has %d entries, want %d", col.Length(), appends)
+	}
+	if got := len(tbl.ListColumnNames()); got != 7 {
+		t.Fatalf("table has %d columns, want 7", got)
+	}
+}
@@ -0,0 +1,691 @@
+package db
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"math/rand"
+	"slices"
+
+	"github.com/viterin/vek/vek32"
+)
+
+/*
+The DiskANN index is a Vamana graph kept in its own blob chain, next to a small
+index blob holding product quantization codebooks and one code per entry.
+
+Only the codes live in memory. A search walks the graph from the medoid,
+ordering its beam by the approximate distances the codes give and reading each
+node's neighbors straight from the file, then re-ranks the nodes it expanded
+with the full vectors read from the column.
+
+Graph chain: one fixed size record per node, a uint32 degree followed by R
+uint32 neighbor ids. Records never straddle a chunk, the tail of every chunk is
+padding.
+
+Index blob layout (little endian):
+	magic       "VAMA0001"
+	metric      uint8
+	r, l        uint32
+	alpha       float32
+	seed        int64
+	dim         uint32
+	subspaces   uint32
+	ksub        uint32
+	count       uint64
+	graphCount  uint64
+	medoid      uint64
+	graphFirst  int64
+	codebooks   ksub*dim float32, subspace after subspace
+	codes       count*subspaces bytes
+
+Entries appended after a build are quantized but not linked into the graph,
+searches score them exactly. Rebuild to bring them into the graph.
+*/
+
+var vamanaMagic = []byte("VAMA0001")
+
+// back edges may overflow R by this factor before a node is pruned, which saves
+// most of the pruning work during a build. Lists are cut back to R before flushing
+const vamanaSlack = 1.3
+
+// DiskANNParams configures a DiskANN index
+// Zero fields fall back to R 32, L 64, Alpha 1.2, min(dim, 64) subspaces, a
+// sample of 8192 vectors, 10 iterations and the column schema's metric. Hamming is not
+// supported, binary codes are already as compact as the quantized ones. Building keeps the graph in memory, R*4 bytes per entry,
+// afterwards only the codes are resident
+type DiskANNParams struct {
+	R          int     // maximum out degree of a node
+	L          int     // beam width while building
+	Alpha      float32 // pruning slack of the second pass, above 1 keeps longer edges
+	Subspaces  int     // product quantization subspaces, each entry costs one byte per subspace
+	SampleSize int     // vectors the codebooks are trained on
+	Iterations int     // k-means iterations per codebook
+	Metric     Metric  // metric results are ranked by
+	Seed       int64   // seeds sampling, the initial graph and insertion order
+}
+
+type vamana struct {
+	params     DiskANNParams
+	metric     Metric
+	dim        int
+	pq         *productQuantizer
+	codes      []byte // one code per entry, subspaces bytes each
+	n          int64
+	graphCount int64 // entries linked into the graph, the rest are scored exactly
+	medoid     int64
+
+	graph       [][]uint32 // only held between build and flush
+	marks       *stampSet  // visited set reused across build searches
+	graphFirst  int64
+	graphChunks []int64 // resolved by attach
+}
+
+func newVamana(params DiskANNParams, metric Metric, dim int) *vamana {
+	if params.R <= 0 {
+		params.R = 32
+	}
+	if params.L <= 0 {
+		params.L = 64
+	}
+	if params.Alpha <= 0 {
+		params.Alpha = 1.2
+	}
+	if params.Subspaces <= 0 {
+		params.Subspaces = min(dim, 64)
+	}
+	if params.SampleSize <= 0 {
+		params.SampleSize = 8192
+	}
+	if params.Iterations <= 0 {
+		params.Iterations = 10
+	}
+	return &vamana{params: params, metric: metric, dim: dim}
+}
+
+func (v *vamana) kind() IndexKind { return DiskANNIndex }
+
+func (v *vamana) count() int64 { return v.n }
+
+func (v *vamana) recordSize() int64 { return 4 + 4*int64(v.params.R) }
+
+func (v *vamana) recordsPerChunk() int64 { return blobChunkCapacity / v.recordSize() }
+
+// quantized returns the vector the codes are computed from, unit length for cosine
+func (v *vamana) quantized(vec []float32) []float32 {
+	if v.metric != Cosine {
+		return vec
+	}
+	vec = slices.Clone(vec)
+	normalize(vec)
+	return vec
+}
+
+// buildDistance is what the graph is built with. Pruning needs a true distance,
+// so cosine uses 1 - similarity and dot product falls back to L2
+func (v *vamana) buildDistance(a, b []float32) float32 {
+	switch v.metric {
+	case Cosine:
+		return 1 - vek32.CosineSimilarity(a, b)
+	case L1:
+		return vek32.ManhattanDistance(a, b)
+	default:
+		return vek32.Distance(a, b)
+	}
+}
+
+func (v *vamana) buildDistanceTo(src *vectorSource, vec []float32, idx int64) (float32, error) {
+	other, err := src.vector(idx)
+	if err != nil {
+		return 0, err
+	}
+	return v.buildDistance(vec, other), nil
+}
+
+func (v *vamana) add(src *vectorSource, idx int64) error {
+	if idx != v.n {
+		return fmt.Errorf("diskann index has %d entries, cannot add entry %d", v.n, idx)
+	}
+	if v.pq == nil {
+		return fmt.Errorf("diskann index is not trained")
+	}
+	vec, err := src.vector(idx)
+	if err != nil {
+		return err
+	}
+	code := make([]byte, v.pq.subspaces())
+	v.pq.encode(v.quantized(vec), code)
+	v.codes = append(v.codes, code...)
+	v.n++
+	return nil
+}
+
+// train quantizes every entry of src and builds the graph over them
+func (v *vamana) train(src *vectorSource) error {
+	n := src.len()
+	if n == 0 {
+		return fmt.Errorf("cannot build a diskann index on an empty column")
+	}
+	if n >= math.MaxUint32 {
+		return fmt.Errorf("diskann index is limited to %d entries", math.MaxUint32-1)
+	}
+	params := v.params
+	rng := rand.New(rand.NewSource(params.Seed))
+
+	sampleIdx := rng.Perm(int(n))[:min(int64(params.SampleSize), n)]
+	sample := make([][]float32, len(sampleIdx))
+	for i, idx := range sampleIdx {
+		vec, err := src.vector(int64(idx))
+		if err != nil {
+			return err
+		}
+		sample[i] = slices.Clone(v.quantized(vec))
+		src.yield()
+	}
+	v.pq = trainPQ(sample, params.Subspaces, params.Iterations, rng)
+	for idx := range n {
+		if err := v.add(src, idx); err != nil {
+			return err
+		}
+		src.yield()
+	}
+
+	// the medoid is approximated by the entry closest to the sample mean
+	mean := make([]float32, v.dim)
+	for _, vec := range sample {
+		vek32.Add_Inplace(mean, vec)
+	}
+	vek32.DivNumber_Inplace(mean, float32(len(sample)))
+	bestDist := float32(math.Inf(1))
+	for idx := range n {
+		dist, err := v.buildDistanceTo(src, mean, idx)
+		if err != nil {
+			return err
+		}
+		if dist < bestDist {
+			v.medoid, bestDist = idx, dist
+		}
+		src.yield()
+	}
+
+	// start from a random graph, then refine it with a strict pass and a relaxed one
+	v.graph = make([][]uint32, n)
+	for i := range v.graph {
+		degree := min(int64(params.R), n-1)
+		neighbors := make([]uint32, 0, degree)
+		for int64(len(neighbors)) < degree {
+			j := uint32(rng.Int63n(n))
+			if int64(j) != int64(i) && !slices.Contains(neighbors, j) {
+				neighbors = append(neighbors, j)
+			}
+		}
+		v.graph[i] = neighbors
+	}
+	for _, alpha := range []float32{1, params.Alpha} {
+		for _, p := range rng.Perm(int(n)) {
+			if err := v.insert(src, int64(p), alpha); err != nil {
+				return err
+			}
+			src.yield()
+		}
+	}
+	for j := range v.graph {
+		if len(v.graph[j]) > params.R {
+			if err := v.shrink(src, int64(j), params.Alpha); err != nil {
+				return err
+			}
+		}
+		src.yield()
+	}
+	v.graphCount = n
+	return nil
+}
+
+// insert relinks node p to what a search for it visits and adds the back edges
+func (v *vamana) insert(src *vectorSource, p int64, alpha float32) error {
+	vec, err := src.vector(p)
+	if err != nil {
+		return err
+	}
+	visited, err := v.buildSearch(src, vec)
+	if err != nil {
+		return err
+	}
+	for _, n := range v.graph[p] {
+		dist, err := v.buildDistanceTo(src, vec, int64(n))
+		if err != nil {
+			return err
+		}
+		visited = append(visited, candidate{idx: int64(n), dist: dist})
+	}
+	if v.graph[p], err = v.prune(src, p, visited, alpha); err != nil {
+		return err
+	}
+
+	for _, j := range v.graph[p] {
+		if slices.Contains(v.graph[j], uint32(p)) {
+			continue
+		}
+		v.graph[j] = append(v.graph[j], uint32(p))
+		if float64(len(v.graph[j])) > float64(v.params.R)*vamanaSlack {
+			if err := v.shrink(src, int64(j), alpha); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// shrink prunes the edges of node j back to R
+func (v *vamana) shrink(src *vectorSource, j int64, alpha float32) error {
+	vec, err := src.vector(j)
+	if err != nil {
+		return err
+	}
+	candidates := make([]candidate, 0, len(v.graph[j]))
+	for _, n := range v.graph[j] {
+		dist, err := v.buildDistanceTo(src, vec, int64(n))
+		if err != nil {
+			return err
+		}
+		candidates = append(candidates, candidate{idx: int64(n), dist: dist})
+	}
+	v.graph[j], err = v.prune(src, j, candidates, alpha)
+	return err
+}
+
+// prune keeps at most R candidates, dropping any that a closer kept candidate
+// already covers within a factor of alpha
+func (v *vamana) prune(src *vectorSource, p int64, candidates []candidate, alpha float32) ([]uint32, error) {
+	slices.SortFunc(candidates, compareCandidates)
+	candidates = slices.CompactFunc(candidates, func(a, b candidate) bool { return a.idx == b.idx })
+	kept := []uint32{}
+	for len(candidates) > 0 && len(kept) < v.params.R {
+		best := candidates[0]
+		candidates = candidates[1:]
+		if best.idx == p {
+			continue
+		}
+		kept = append(kept, uint32(best.idx))
+		bestVec, err := src.vector(best.idx)
+		if err != nil {
+			return nil, err
+		}
+		remaining := candidates[:0]
+		for _, c := range candidates {
+			dist, err := v.buildDistanceTo(src, bestVec, c.idx)
+			if err != nil {
+				return nil, err
+			}
+			if alpha*dist > c.dist {
+				remaining = append(remaining, c)
+			}
+		}
+		candidates = remaining
+	}
+	return kept, nil
+}
+
+// buildSearch runs a beam search over the in-memory graph with exact distances
+// and returns every node it expanded
+func (v *vamana) buildSearch(src *vectorSource, vec []float32) ([]candidate, error) {
+	dist, err := v.buildDistanceTo(src, vec, v.medoid)
+	if err != nil {
+		return nil, err
+	}
+	if v.marks == nil {
+		v.marks = &stampSet{stamps: make([]uint32, len(v.graph))}
+	}
+	v.marks.reset()
+	beam := newBeam(v.params.L, candidate{idx: v.medoid, dist: dist}, v.marks)
+	expanded := []candidate{}
+	for {
+		c, ok := beam.next()
+		if !ok {
+			return expanded, nil
+		}
+		expanded = append(expanded, c)
+		for _, n := range v.graph[c.idx] {
+			if !beam.visit(int64(n)) {
+				continue
+			}
+			dist, err := v.buildDistanceTo(src, vec, int64(n))
+			if err != nil {
+				return nil, err
+			}
+			beam.offer(candidate{idx: int64(n), dist: dist})
+		}
+	}
+}
+
+// neighbors reads the out edges of node from the graph chain
+func (v *vamana) neighbors(r region, node int64) ([]uint32, error) {
+	perChunk := v.recordsPerChunk()
+	if node/perChunk >= int64(len(v.graphChunks)) {
+		return nil, fmt.Errorf("diskann node %d outside the graph", node)
+	}
+	chunk := v.graphChunks[node/perChunk]
+	record, err := r.read(chunk+ChunkHeaderSize+(node%perChunk)*v.recordSize(), v.recordSize())
+	if err != nil {
+		return nil, err
+	}
+	degree := int(ByteOrder.Uint32(record))
+	if degree > v.params.R {
+		return nil, fmt.Errorf("diskann node %d has degree %d, limit is %d", node, degree, v.params.R)
+	}
+	neighbors := make([]uint32, degree)
+	for i := range neighbors {
+		neighbors[i] = ByteOrder.Uint32(record[4+i*4:])
+	}
+	return neighbors, nil
+}
+
+// flush writes the graph to its own chain and drops it from memory
+func (v *vamana) flush(conn *DB) error {
+	if v.graph == nil {
+		return nil
+	}
+	perChunk, size := v.recordsPerChunk(), v.recordSize()
+	numChunks := max(1, (int64(len(v.graph))+perChunk-1)/perChunk)
+	data := make([]byte, (numChunks-1)*blobChunkCapacity+(int64(len(v.graph))-(numChunks-1)*perChunk)*size)
+	for node, neighbors := range v.graph {
+		off := int64(node)/perChunk*blobChunkCapacity + int64(node)%perChunk*size
+		ByteOrder.PutUint32(data[off:], uint32(len(neighbors)))
+		for i, n := range neighbors {
+			ByteOrder.PutUint32(data[off+4+int64(i)*4:], n)
+		}
+	}
+	first, err := conn.writeBlob(data)
+	if err != nil {
+		return err
+	}
+	v.graphFirst, v.graph, v.marks = first, nil, nil
+	return nil
+}
+
+func (v *vamana) attach(r region) error {
+	v.graphChunks = r.blobChunks(v.graphFirst)
+	if need := (v.graphCount + v.recordsPerChunk() - 1) / v.recordsPerChunk(); int64(len(v.graphChunks)) < need {
+		return fmt.Errorf("diskann graph has %d chunks, want %d", len(v.graphChunks), need)
+	}
+	return nil
+}
+
+func (v *vamana) chains() []int64 {
+	return []int64{v.graphFirst}
+}
+
+// search walks the graph with approximate distances, then ranks what it expanded
+// and every entry appended since the build by their full vectors
+func (v *vamana) search(src *vectorSource, query []float32, k int, l int, allow func(int64) bool) ([]SearchResult, error) {
+	metric := v.metric
+	results := newResultHeap(int(min(int64(k), src.len())), metric)
+	score := func(idx int64) error {
+		if !allow(idx) {
+			return nil
+		}
+		entry, err := src.entry(idx)
+		if err != nil {
+			return err
+		}
+		results.offer(SearchResult{
+			Index:     idx,
+			Timestamp: ByteOrder.Uint64(entry),
+			Score:     metric.Score(readVec(entry[8:], src.vecLen), query),
+		})
+		return nil
+	}
+
+	if v.graphCount > 0 {
+		subspaces := int64(v.pq.subspaces())
+		table := v.pq.table(v.quantized(query), metric)
+		approx := func(idx int64) float32 {
+			return v.pq.distance(table, v.codes[idx*subspaces:(idx+1)*subspaces])
+		}
+		beam := newBeam(max(l, k), candidate{idx: v.medoid, dist: approx(v.medoid)}, mapSet{})
+		for {
+			c, ok := beam.next()
+			if !ok {
+				break
+			}
+			if err := score(c.idx); err != nil {
+				return nil, err
+			}
+			neighbors, err := v.neighbors(src.r, c.idx)
+			if err != nil {
+				return nil, err
+			}
+			for _, n := range neighbors {
+				if int64(n) < v.graphCount && beam.visit(int64(n)) {
+					beam.offer(candidate{idx: int64(n), dist: approx(int64(n))})
+				}
+			}
+		}
+	}
+	for idx := v.graphCount; idx < min(v.n, src.len()); idx++ {
+		if err := score(idx); err != nil {
+			return nil, err
+		}
+	}
+	return results.sorted(), nil
+}
+
+func (v *vamana) encode() []byte {
+	var buf bytes.Buffer
+	put := func(x uint64, size int) {
+		var b [8]byte
+		ByteOrder.PutUint64(b[:], x)
+		buf.Write(b[:size])
+	}
+	buf.Write(vamanaMagic)
+	buf.WriteByte(byte(v.metric))
+	put(uint64(v.params.R), 4)
+	put(uint64(v.params.L), 4)
+	put(uint64(math.Float32bits(v.params.Alpha)), 4)
+	put(uint64(v.params.Seed), 8)
+	put(uint64(v.dim), 4)
+	put(uint64(v.pq.subspaces()), 4)
+	put(uint64(v.pq.ksub), 4)
+	put(uint64(v.n), 8)
+	put(uint64(v.graphCount), 8)
+	put(uint64(v.medoid), 8)
+	put(uint64(v.graphFirst), 8)
+	for _, codebook := range v.pq.codebooks {
+		for _, x := range codebook {
+			put(uint64(math.Float32bits(x)), 4)
+		}
+	}
+	buf.Write(v.codes)
+	return buf.Bytes()
+}
+
+func decodeVamana(b []byte) (*vamana, error) {
+	const headerSize = 73
+	if len(b) < headerSize || !bytes.Equal(b[:8], vamanaMagic) {
+		return nil, fmt.Errorf("not a diskann index")
+	}
+	metric := Metric(b[8])
+	if err := metric.validate(); err != nil {
+		return nil, err
+	}
+	params := DiskANNParams{
+		R:     int(ByteOrder.Uint32(b[9:])),
+		L:     int(ByteOrder.Uint32(b[13:])),
+		Alpha: math.Float32frombits(ByteOrder.Uint32(b[17:])),
+		Seed:  int64(ByteOrder.Uint64(b[21:])),
+	}
+	dim := int(ByteOrder.Uint32(b[29:]))
+	subspaces := int(ByteOrder.Uint32(b[33:]))
+	ksub := int(ByteOrder.Uint32(b[37:]))
+	params.Subspaces = subspaces
+	v := newVamana(params, metric, dim)
+	v.n = int64(ByteOrder.Uint64(b[41:]))
+	v.graphCount = int64(ByteOrder.Uint64(b[49:]))
+	v.medoid = int64(ByteOrder.Uint64(b[57:]))
+	v.graphFirst = int64(ByteOrder.Uint64(b[65:]))
+	if params.R <= 0 || subspaces <= 0 || subspaces > dim || ksub <= 0 || ksub > 256 ||
+		v.graphCount > v.n || (v.graphCount > 0 && v.medoid >= v.graphCount) {
+		return nil, fmt.Errorf("corrupt diskann header")
+	}
+
+	p := b[headerSize:]
+	if int64(len(p)) != int64(ksub*dim*4)+v.n*int64(subspaces) {
+		return nil, fmt.Errorf("diskann index has %d payload bytes, want %d", len(p), int64(ksub*dim*4)+v.n*int64(subspaces))
+	}
+	v.pq = &productQuantizer{
+		dim:       dim,
+		ksub:      ksub,
+		bounds:    make([]int, subspaces+1),
+		codebooks: make([][]float32, subspaces),
+	}
+	for i := range v.pq.bounds {
+		v.pq.bounds[i] = i * dim / subspaces
+	}
+	for s := range v.pq.codebooks {
+		codebook := make([]float32, ksub*(v.pq.bounds[s+1]-v.pq.bounds[s]))
+		for i := range codebook {
+			codebook[i] = math.Float32frombits(ByteOrder.Uint32(p))
+			p = p[4:]
+		}
+		v.pq.codebooks[s] = codebook
+	}
+	v.codes = slices.Clone(p)
+	for _, c := range v.codes {
+		if int(c) >= ksub {
+			return nil, fmt.Errorf("diskann code %d out of range", c)
+		}
+	}
+	return v, nil
+}
+
+// BuildDiskANN builds a disk-resident graph index over the column and persists it, replacing any existing one
+// Only the compressed codes stay in memory, so it suits columns far larger than RAM
+func (column *Column) BuildDiskANN(params DiskANNParams) error {
+	metric, err := column.metricOr(params.Metric)
+	if err != nil {
+		return err
+	}
+	if metric == Hamming {
+		return fmt.Errorf("diskann does not support hamming distance")
+	}
+	index := newVamana(params, metric, int(column.metadata().vectorLength))
+	return column.buildIndex(index, index.train)
+}
+
+// SearchDiskANN returns the k entries closest to query using the column's DiskANN index, best first
+// l is the beam width, larger values trade speed for recall and it is raised to k if smaller
+func (column *Column) SearchDiskANN(query []float32, k int, l int) ([]SearchResult, error) {
+	return column.SearchDiskANNFiltered(query, k, l, nil)
+}
+
+// SearchDiskANNFiltered is SearchDiskANN restricted to the entries admitted by filter
+// The beam is widened by the inverse of the filter's selectivity like ef is for HNSW
+func (column *Column) SearchDiskANNFiltered(query []float32, k int, l int, filter *Filter) ([]SearchResult, error) {
+	if k <= 0 {
+		return nil, fmt.Errorf("k must be positive, got %d", k)
+	}
+	if err := column.ValidateQuery(query); err != nil {
+		return nil, err
+	}
+	bitmap, admitted, err := filter.resolve(column)
+	if err != nil {
+		return nil, err
+	}
+	var results []SearchResult
+	err = column.withIndex(DiskANNIndex, func(src *vectorSource, index vectorIndex) error {
+		graph := index.(*vamana)
+		if bitmap != nil && admitted < exactFilterLimit {
+			var err error
+			results, err = column.scanTopK(query, k, graph.metric, bitmap)
+			return err
+		}
+		limit := src.len()
+		l = widen(max(l, k), limit, admitted)
+		var err error
+		results, err = graph.search(src, query, k, l, func(idx int64) bool { return idx < limit && admits(bitmap, idx) })
+		return err
+	})
+	return results, err
+}
+
+// visitSet tracks the nodes a graph search has seen
+// visit reports whether idx is new and marks it seen
+type visitSet interface {
+	visit(idx int64) bool
+}
+
+// mapSet is sized by what a search touches, for searches over graphs too big for a dense set
+type mapSet map[int64]struct{}
+
+func (s mapSet) visit(idx int64) bool {
+	if _, ok := s[idx]; ok {
+		return false
+	}
+	s[idx] = struct{}{}
+	return true
+}
+
+// stampSet is a dense set that resets in constant time by bumping a generation
+type stampSet struct {
+	stamps []uint32
+	gen    uint32
+}
+
+func (s *stampSet) reset() {
+	s.gen++
+	if s.gen == 0 {
+		clear(s.stamps)
+		s.gen = 1
+	}
+}
+
+func (s *stampSet) visit(idx int64) bool {
+	if s.stamps[idx] == s.gen {
+		return false
+	}
+	s.stamps[idx] = s.gen
+	return true
+}
+
+// beam is the bounded candidate list of a best-first graph search
+// It keeps the closest candidates sorted and remembers which it already expanded
+type beam struct {
+	visitSet
+	size     int
+	items    []candidate
+	expanded []bool
+}
+
+func newBeam(size int, start candidate, seen visitSet) *beam {
+	seen.visit(start.idx)
+	return &beam{
+		visitSet: seen,
+		size:     size,
+		items:    []candidate{start},
+		expanded: []bool{false},
+	}
+}
+
+// offer inserts c if the beam has room or c beats its farthest candidate
+func (b *beam) offer(c candidate) {
+	if len(b.items) >= b.size && compareCandidates(c, b.items[len(b.items)-1]) >= 0 {
+		return
+	}
+	i, _ := slices.BinarySearchFunc(b.items, c, compareCandidates)
+	b.items = slices.Insert(b.items, i, c)
+	b.expanded = slices.Insert(b.expanded, i, false)
+	if len(b.items) > b.size {
+		b.items, b.expanded = b.items[:b.size], b.expanded[:b.size]
+	}
+}
+
+// next marks the closest unexpanded candidate expanded and returns it
+func (b *beam) next() (candidate, bool) {
+	for i, done := range b.expanded {
+		if !done {
+			b.expanded[i] = true
+			return b.items[i], true
+		}
+	}
+	return candidate{}, false
+}
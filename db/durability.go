@@ -0,0 +1,207 @@
+package db
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Durability selects when appended data is forced to stable storage
+type Durability int
+
+const (
+	// DurabilityOS leaves write-back to the OS, data is flushed on Grow, Close and Sync
+	DurabilityOS Durability = iota
+	// DurabilityAlways flushes the dirty ranges of every write before it returns
+	DurabilityAlways
+	// DurabilityGroup flushes dirty ranges from a background goroutine every interval
+	DurabilityGroup
+)
+
+// RangeFlusher is implemented by backends that can flush part of themselves
+// Backends without it fall back to a full Flush
+type RangeFlusher interface {
+	FlushRange(off int64, n int64) error
+}
+
+var pageSize = int64(os.Getpagesize())
+
+// maxDirtyRanges caps the ranges a dirty set holds between flushes. Past it they are
+// merged, and if that leaves more than half of it they collapse into one covering range
+// DurabilityOS only takes them on Sync, so without the cap the set grows with every write
+const maxDirtyRanges = 1024
+
+// dirtySet collects the byte ranges written since the last flush
+type dirtySet struct {
+	mu     sync.Mutex
+	ranges [][2]int64 // [start, end)
+}
+
+func (d *dirtySet) add(off int64, n int64) {
+	d.mu.Lock()
+	d.ranges = append(d.ranges, [2]int64{off, off + n})
+	if len(d.ranges) > maxDirtyRanges {
+		d.ranges = mergeRanges(d.ranges)
+		if len(d.ranges) > maxDirtyRanges/2 {
+			// merged ranges are sorted and disjoint, so the last one ends furthest out
+			d.ranges = [][2]int64{{d.ranges[0][0], d.ranges[len(d.ranges)-1][1]}}
+		}
+	}
+	d.mu.Unlock()
+}
+
+// take returns the dirty ranges widened to whole pages and merged, and resets the set
+func (d *dirtySet) take() [][2]int64 {
+	d.mu.Lock()
+	ranges := d.ranges
+	d.ranges = nil
+	d.mu.Unlock()
+	if len(ranges) == 0 {
+		return nil
+	}
+	return mergeRanges(ranges)
+}
+
+// putBack returns ranges a failed flush took to the set
+func (d *dirtySet) putBack(ranges [][2]int64) {
+	for _, rng := range ranges {
+		d.add(rng[0], rng[1]-rng[0])
+	}
+}
+
+// mergeRanges widens ranges to whole pages, sorts them and merges any that touch
+// The slice is reused for the result
+func mergeRanges(ranges [][2]int64) [][2]int64 {
+	for i := range ranges {
+		ranges[i][0] -= ranges[i][0] % pageSize
+	}
+	slices.SortFunc(ranges, func(a, b [2]int64) int {
+		return int(a[0] - b[0])
+	})
+	merged := ranges[:1]
+	for _, r := range ranges[1:] {
+		last := &merged[len(merged)-1]
+		if r[0] <= last[1] {
+			last[1] = max(last[1], r[1])
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+// flusher is the background group commit goroutine
+type flusher struct {
+	stop chan struct{}
+	done chan struct{}
+}
+
+// SetDurability switches the durability mode, interval is only used by DurabilityGroup
+// and must be positive there. Pending writes are flushed before the mode changes
+func (conn *DB) SetDurability(mode Durability, interval time.Duration) error {
+	if mode == DurabilityGroup && interval <= 0 {
+		slog.Error("Group commit needs a positive interval", "interval", interval)
+		return fmt.Errorf("group commit interval must be positive, got %s", interval)
+	}
+	s := conn.file
+	s.durabilityMu.Lock()
+	defer s.durabilityMu.Unlock()
+
+	if s.flusher != nil {
+		close(s.flusher.stop)
+		<-s.flusher.done
+		s.flusher = nil
+	}
+	if err := s.syncDirty(); err != nil {
+		return err
+	}
+	s.durability = mode
+	if mode != DurabilityGroup {
+		return nil
+	}
+
+	f := &flusher{stop: make(chan struct{}), done: make(chan struct{})}
+	s.flusher = f
+	go func() {
+		defer close(f.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-f.stop:
+				return
+			case <-ticker.C:
+				if err := s.syncDirty(); err != nil {
+					slog.Error("Background flush failed", "error", err)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// Sync flushes everything written so far, use it at checkpoints
+func (conn *DB) Sync() error {
+	return conn.file.syncDirty()
+}
+
+// commit is called by writers after their last write
+// In DurabilityAlways mode it does not return until the write is stable
+func (s *store) commit() error {
+	if s.mode() != DurabilityAlways {
+		return nil
+	}
+	return s.syncDirty()
+}
+
+func (s *store) mode() Durability {
+	s.durabilityMu.Lock()
+	defer s.durabilityMu.Unlock()
+	return s.durability
+}
+
+// syncDirty flushes the dirty ranges, range-limited when the backend supports it
+// Syncs run one at a time. A sync that finds the set empty may still have had its
+// writes taken by one in flight, waiting for that one is what makes commit safe
+func (s *store) syncDirty() error {
+	s.syncMu.Lock()
+	defer s.syncMu.Unlock()
+	ranges := s.dirty.take()
+	if len(ranges) == 0 {
+		return nil
+	}
+	r, unpin := s.Pin()
+	defer unpin()
+
+	rf, ok := r.storage.(RangeFlusher)
+	if !ok {
+		if err := r.storage.Flush(); err != nil {
+			s.dirty.putBack(ranges)
+			return err
+		}
+		return nil
+	}
+	for i, rng := range ranges {
+		if err := rf.FlushRange(rng[0], min(rng[1], r.size())-rng[0]); err != nil {
+			// put back what is left so the next flush retries it
+			s.dirty.putBack(ranges[i:])
+			slog.Error("Range flush failed", "offset", rng[0], "length", rng[1]-rng[0], "error", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// stopFlusher ends the background goroutine, used on Close
+func (s *store) stopFlusher() {
+	s.durabilityMu.Lock()
+	defer s.durabilityMu.Unlock()
+	if s.flusher != nil {
+		close(s.flusher.stop)
+		<-s.flusher.done
+		s.flusher = nil
+	}
+}
@@ -0,0 +1,133 @@
+package db
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSetDurabilityRejectsBadInterval(t *testing.T) {
+	conn := openTestDB(t)
+	if err := conn.SetDurability(DurabilityAlways, 0); err != nil {
+		t.Fatal(err)
+	}
+	for _, interval := range []time.Duration{0, -time.Second} {
+		if err := conn.SetDurability(DurabilityGroup, interval); err == nil {
+			t.Fatalf("group commit accepted an interval of %s", interval)
+		}
+	}
+	// a rejected switch leaves the previous mode in place
+	if mode := conn.file.mode(); mode != DurabilityAlways {
+		t.Fatalf("mode is %d after a rejected switch, want DurabilityAlways", mode)
+	}
+
+	if err := conn.SetDurability(DurabilityGroup, time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	col := newTestColumn(t, conn, "c", 4)
+	appendTestVectors(t, col, 10)
+	time.Sleep(5 * time.Millisecond)
+	if err := conn.SetDurability(DurabilityOS, 0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDirtySetStaysBounded(t *testing.T) {
+	d := &dirtySet{}
+	// every other page, so nothing merges until the set collapses
+	const writes = 10 * maxDirtyRanges
+	for i := int64(0); i < writes; i++ {
+		d.add(2*i*pageSize, 8)
+		if len(d.ranges) > maxDirtyRanges {
+			t.Fatalf("dirty set holds %d ranges after %d writes", len(d.ranges), i+1)
+		}
+	}
+	ranges := d.take()
+	first, last := ranges[0], ranges[len(ranges)-1]
+	if first[0] != 0 || last[1] < 2*(writes-1)*pageSize+8 {
+		t.Fatalf("dirty ranges %v do not cover every write", ranges)
+	}
+
+	// appends in DurabilityOS mode are only flushed on Sync
+	conn := openTestDB(t)
+	col := newTestColumn(t, conn, "c", 4)
+	appendTestVectors(t, col, 5000)
+	if n := len(conn.file.dirty.ranges); n > maxDirtyRanges {
+		t.Fatalf("dirty set holds %d ranges after 5000 appends", n)
+	}
+	if err := conn.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(conn.file.dirty.ranges); n != 0 {
+		t.Fatalf("dirty set holds %d ranges after Sync", n)
+	}
+}
+
+// flakyFlusher is a memory backend whose range flushes can be held or failed
+type flakyFlusher struct {
+	*MemoryStorage
+	hold    chan struct{} // when set, flushes wait for it to close
+	started chan struct{} // closed by the first flush
+	failAt  int64         // flushes starting here fail, -1 for none
+	once    sync.Once
+}
+
+func (f *flakyFlusher) FlushRange(off int64, n int64) error {
+	f.once.Do(func() { close(f.started) })
+	if f.hold != nil {
+		<-f.hold
+	}
+	if off == f.failAt {
+		return errors.New("flush failed")
+	}
+	return nil
+}
+
+// A commit whose ranges were taken by a sync still in flight waits for that sync
+func TestCommitWaitsForInflightSync(t *testing.T) {
+	backend := &flakyFlusher{MemoryStorage: NewMemoryStorage(16 * pageSize), hold: make(chan struct{}), started: make(chan struct{}), failAt: -1}
+	s := newStore(backend)
+	s.durability = DurabilityAlways
+	s.dirty.add(0, 8)
+
+	synced := make(chan error, 1)
+	go func() { synced <- s.syncDirty() }()
+	<-backend.started
+	committed := make(chan error, 1)
+	go func() { committed <- s.commit() }()
+	select {
+	case <-committed:
+		t.Fatal("commit returned while the flush covering its write was in flight")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(backend.hold)
+	if err := <-synced; err != nil {
+		t.Fatal(err)
+	}
+	if err := <-committed; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// A failed flush puts back every range it had not flushed yet
+func TestFailedSyncKeepsRemainingRanges(t *testing.T) {
+	backend := &flakyFlusher{MemoryStorage: NewMemoryStorage(16 * pageSize), started: make(chan struct{}), failAt: 2 * pageSize}
+	s := newStore(backend)
+	for _, page := range []int64{0, 2, 4, 6} {
+		s.dirty.add(page*pageSize, 8)
+	}
+	if err := s.syncDirty(); err == nil {
+		t.Fatal("sync succeeded past a failed flush")
+	}
+	ranges := s.dirty.take()
+	want := [][2]int64{{2 * pageSize, 2*pageSize + 8}, {4 * pageSize, 4*pageSize + 8}, {6 * pageSize, 6*pageSize + 8}}
+	if len(ranges) != len(want) {
+		t.Fatalf("dirty set holds %v after the failure, want %v", ranges, want)
+	}
+	for i := range want {
+		if ranges[i] != want[i] {
+			t.Fatalf("dirty set holds %v after the failure, want %v", ranges, want)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package db
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"slices"
+	"text/tabwriter"
+	"time"
+)
+
+// EvalConfig is one search setup measured by Evaluate
+// Index 0 runs the exact scan, which doubles as the latency baseline
+type EvalConfig struct {
+	Name   string    // label in the report, derived from the other fields when empty
+	Index  IndexKind // HNSWIndex, IVFFlatIndex, DiskANNIndex or 0 for an exact scan
+	Ef     int       // candidate list size for HNSW
+	NProbe int       // lists probed for IVF
+	L      int       // beam width for DiskANN
+}
+
+func (cfg EvalConfig) label() string {
+	if cfg.Name != "" {
+		return cfg.Name
+	}
+	switch cfg.Index {
+	case 0:
+		return "exact"
+	case HNSWIndex:
+		return fmt.Sprintf("hnsw ef=%d", cfg.Ef)
+	case IVFFlatIndex:
+		return fmt.Sprintf("ivf-flat nprobe=%d", cfg.NProbe)
+	case DiskANNIndex:
+		return fmt.Sprintf("diskann l=%d", cfg.L)
+	default:
+		return cfg.Index.String()
+	}
+}
+
+// EvalOptions controls an evaluation run
+// Zero fields fall back to 100 queries, k 10 and the metric from the column schema
+type EvalOptions struct {
+	Queries int    // number of column entries sampled as queries
+	K       int    // results per query, recall is measured at k
+	Metric  Metric // metric for the ground truth, should match the one the indexes were built with
+	Seed    int64  // seeds query sampling
+	Configs []EvalConfig
+}
+
+// EvalResult reports how one config did against the exact ground truth
+type EvalResult struct {
+	Name   string        `json:"name"`
+	Recall float64       `json:"recall"`
+	QPS    float64       `json:"qps"`
+	P50    time.Duration `json:"p50_ns"`
+	P99    time.Duration `json:"p99_ns"`
+}
+
+// EvalReport is the outcome of Evaluate
+type EvalReport struct {
+	Column  string       `json:"column"`
+	Vectors int64        `json:"vectors"`
+	Queries int          `json:"queries"`
+	K       int          `json:"k"`
+	Metric  string       `json:"metric"`
+	Results []EvalResult `json:"results"`
+}
+
+// Evaluate samples queries from the column, computes their exact top k with a full scan
+// and measures recall@k, throughput and latency of every config against it
+// Runs on a snapshot so appends during the run do not skew recall
+func (column *Column) Evaluate(opts EvalOptions) (*EvalReport, error) {
+	if opts.Queries <= 0 {
+		opts.Queries = 100
+	}
+	if opts.K <= 0 {
+		opts.K = 10
+	}
+	metric, err := column.metricOr(opts.Metric)
+	if err != nil {
+		return nil, err
+	}
+	frozen := column.Snapshot()
+	meta := frozen.view().meta
+	if meta.numVectors == 0 {
+		return nil, fmt.Errorf("column %s is empty", meta.name.String())
+	}
+
+	// queries are copied out so they do not alias storage while pins come and go
+	rng := rand.New(rand.NewSource(opts.Seed))
+	queries := make([][]float32, opts.Queries)
+	truth := make([][]SearchResult, opts.Queries)
+	r, unpin := frozen.file.Pin()
+	src := newVectorSource(r, frozen.view())
+	for i := range queries {
+		vec, err := src.vector(rng.Int63n(meta.numVectors))
+		if err != nil {
+			unpin()
+			return nil, err
+		}
+		queries[i] = slices.Clone(vec)
+	}
+	unpin()
+	for i, q := range queries {
+		var err error
+		if truth[i], err = frozen.scanTopK(q, opts.K, metric, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	report := &EvalReport{
+		Column:  meta.name.String(),
+		Vectors: meta.numVectors,
+		Queries: opts.Queries,
+		K:       opts.K,
+		Metric:  metric.String(),
+		Results: []EvalResult{},
+	}
+	for _, cfg := range opts.Configs {
+		result, err := frozen.evaluateConfig(cfg, queries, truth, opts.K, metric)
+		if err != nil {
+			return report, err
+		}
+		report.Results = append(report.Results, result)
+	}
+	return report, nil
+}
+
+// helper that runs every query through one config and scores it against the ground truth
+func (column *Column) evaluateConfig(cfg EvalConfig, queries [][]float32, truth [][]SearchResult, k int, metric Metric) (EvalResult, error) {
+	search := func(q []float32) ([]SearchResult, error) {
+		switch cfg.Index {
+		case 0:
+			return column.TopK(q, k, metric)
+		case HNSWIndex:
+			return column.SearchHNSW(q, k, cfg.Ef)
+		case IVFFlatIndex:
+			return column.SearchIVF(q, k, cfg.NProbe)
+		case DiskANNIndex:
+			return column.SearchDiskANN(q, k, cfg.L)
+		default:
+			return nil, fmt.Errorf("cannot evaluate %s indexes", cfg.Index)
+		}
+	}
+
+	latencies := make([]time.Duration, len(queries))
+	hits, total := 0, 0
+	start := time.Now()
+	for i, q := range queries {
+		began := time.Now()
+		results, err := search(q)
+		latencies[i] = time.Since(began)
+		if err != nil {
+			return EvalResult{}, err
+		}
+		want := map[int64]bool{}
+		for _, res := range truth[i] {
+			want[res.Index] = true
+		}
+		for _, res := range results {
+			if want[res.Index] {
+				hits++
+			}
+		}
+		total += len(truth[i])
+	}
+	elapsed := time.Since(start)
+
+	slices.Sort(latencies)
+	result := EvalResult{
+		Name:   cfg.label(),
+		Recall: 1,
+		QPS:    float64(len(queries)) / elapsed.Seconds(),
+		P50:    latencies[len(latencies)*50/100],
+		P99:    latencies[min(len(latencies)-1, len(latencies)*99/100)],
+	}
+	if total > 0 {
+		result.Recall = float64(hits) / float64(total)
+	}
+	return result, nil
+}
+
+// WriteText prints the report as an aligned table
+func (report *EvalReport) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "column %q, %d vectors, %d queries, recall@%d, metric %s\n\n",
+		report.Column, report.Vectors, report.Queries, report.K, report.Metric)
+	fmt.Fprintf(tw, "config\trecall\tqps\tp50\tp99\n")
+	for _, res := range report.Results {
+		fmt.Fprintf(tw, "%s\t%.4f\t%.1f\t%s\t%s\n", res.Name, res.Recall, res.QPS, res.P50, res.P99)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,93 @@
+package db
+
+import (
+	"bytes"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// Recall is measured against the exact scan: probing every list finds all of it,
+// and an index with its lists emptied finds none
+func TestEvaluateRecall(t *testing.T) {
+	conn := openTestDB(t)
+	tbl, err := conn.AddTable("t", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	col, err := tbl.AddColumnWithSchema("c", 4, Schema{Metric: L2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rng := rand.New(rand.NewSource(1))
+	for i := range 300 {
+		opts := WriteColumnOptions{Kind: Floats}
+		if err := opts.AddFloats([]float32{rng.Float32(), rng.Float32(), rng.Float32(), rng.Float32()}); err != nil {
+			t.Fatal(err)
+		}
+		if err := col.AddVector(int64(i), opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := col.BuildIVF(IVFParams{Lists: 8, Metric: L2, Seed: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := EvalOptions{Queries: 20, K: 5, Seed: 1, Configs: []EvalConfig{
+		{},
+		{Index: IVFFlatIndex, NProbe: 8},
+		{Name: "narrow", Index: IVFFlatIndex, NProbe: 1},
+	}}
+	report, err := col.Evaluate(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Column != "c" || report.Vectors != 300 || report.Queries != 20 || report.K != 5 || report.Metric != "l2" {
+		t.Fatalf("report is %+v", report)
+	}
+	if len(report.Results) != 3 {
+		t.Fatalf("report has %d results, want one per config", len(report.Results))
+	}
+	for i, want := range []struct {
+		name   string
+		recall float64
+	}{{"exact", 1}, {"ivf-flat nprobe=8", 1}} {
+		if got := report.Results[i]; got.Name != want.name || got.Recall != want.recall || got.QPS <= 0 || got.P99 < got.P50 {
+			t.Fatalf("result %d is %+v, want %s at recall %v", i, got, want.name, want.recall)
+		}
+	}
+	if narrow := report.Results[2]; narrow.Name != "narrow" || narrow.Recall >= 1 {
+		t.Fatalf("probing one of 8 lists is %+v, want some of the truth missed", narrow)
+	}
+
+	clear(col.indexes.loaded[IVFFlatIndex].(*ivf).lists)
+	report, err = col.Evaluate(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := report.Results[1]; got.Recall != 0 {
+		t.Fatalf("emptied index recalled %v", got.Recall)
+	}
+
+	var text bytes.Buffer
+	if err := report.WriteText(&text); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`column "c", 300 vectors, 20 queries, recall@5, metric l2`, "exact  ", "ivf-flat nprobe=8  0.0000"} {
+		if !strings.Contains(text.String(), want) {
+			t.Fatalf("report text is missing %q:\n%s", want, text.String())
+		}
+	}
+
+	// a column without entries or an index that is not built cannot be evaluated
+	empty, err := tbl.AddColumnWithSchema("empty", 4, Schema{Metric: L2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := empty.Evaluate(EvalOptions{}); err == nil {
+		t.Fatal("an empty column was evaluated")
+	}
+	if _, err := col.Evaluate(EvalOptions{Queries: 1, Configs: []EvalConfig{{Index: HNSWIndex, Ef: 16}}}); err == nil {
+		t.Fatal("a column without an hnsw index was evaluated on one")
+	}
+}
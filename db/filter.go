@@ -0,0 +1,147 @@
+package db
+
+import (
+	"fmt"
+	"math"
+)
+
+// Filter restricts a search to a subset of a column's entries
+// Entries appended after a bitmap was built have no bit and are never admitted
+type Filter struct {
+	pool    VariablePool
+	varName string
+	ranged  bool
+	startTs int64
+	endTs   int64
+}
+
+// BitmapFilter admits the entries set in a VariablePool variable, such as one built by Select
+func BitmapFilter(pool VariablePool, varName string) *Filter {
+	return &Filter{pool: pool, varName: varName}
+}
+
+// TimeRangeFilter admits entries with startTs <= timestamp < endTs, the same bounds as Select
+func TimeRangeFilter(startTs int64, endTs int64) *Filter {
+	return &Filter{ranged: true, startTs: startTs, endTs: endTs}
+}
+
+// exactFilterLimit is the number of admitted entries below which filtered index
+// searches fall back to an exact scan. Graph and list traversals lose recall
+// when most of what they visit is filtered out, and a scan this small is cheap
+const exactFilterLimit = 4096
+
+// resolve returns the bitmap of admitted entries and how many bits are set
+// A nil filter resolves to a nil bitmap, which admits everything
+func (f *Filter) resolve(column *Column) (*Bitmap, int64, error) {
+	if f == nil {
+		return nil, column.view().meta.numVectors, nil
+	}
+	var bitmap *Bitmap
+	if f.ranged {
+		pool := VariablePool{}
+		if err := column.Select(f.startTs, f.endTs, "range", pool); err != nil {
+			return nil, 0, err
+		}
+		bitmap = pool["range"]
+	} else {
+		var err error
+		if bitmap, err = f.pool.lookup(f.varName); err != nil {
+			return nil, 0, err
+		}
+	}
+	return bitmap, bitmap.Cardinality(), nil
+}
+
+// admits reports whether entry idx passes the bitmap from resolve
+func admits(bitmap *Bitmap, idx int64) bool {
+	return bitmap == nil || bitmap.Contains(idx)
+}
+
+// TopKFiltered is TopK restricted to the entries admitted by filter
+func (column *Column) TopKFiltered(query []float32, k int, metric Metric, filter *Filter) ([]SearchResult, error) {
+	if k <= 0 {
+		return nil, fmt.Errorf("k must be positive, got %d", k)
+	}
+	if err := column.ValidateQuery(query); err != nil {
+		return nil, err
+	}
+	metric, err := column.metricOr(metric)
+	if err != nil {
+		return nil, err
+	}
+	bitmap, _, err := filter.resolve(column)
+	if err != nil {
+		return nil, err
+	}
+	return column.scanTopK(query, k, metric, bitmap)
+}
+
+// SearchHNSWFiltered is SearchHNSW restricted to the entries admitted by filter
+// Filtered out nodes are still traversed so the graph stays connected, and ef is
+// widened by the inverse of the filter's selectivity to keep recall up
+func (column *Column) SearchHNSWFiltered(query []float32, k int, ef int, filter *Filter) ([]SearchResult, error) {
+	if k <= 0 {
+		return nil, fmt.Errorf("k must be positive, got %d", k)
+	}
+	if err := column.ValidateQuery(query); err != nil {
+		return nil, err
+	}
+	bitmap, admitted, err := filter.resolve(column)
+	if err != nil {
+		return nil, err
+	}
+	var results []SearchResult
+	err = column.withIndex(HNSWIndex, func(src *vectorSource, index vectorIndex) error {
+		graph := index.(*hnsw)
+		if bitmap != nil && admitted < exactFilterLimit {
+			var err error
+			results, err = column.scanTopK(query, k, graph.metric, bitmap)
+			return err
+		}
+		limit := src.len()
+		ef = widen(max(ef, k), limit, admitted)
+		var err error
+		results, err = graph.search(src, query, k, ef, func(idx int64) bool { return idx < limit && admits(bitmap, idx) })
+		return err
+	})
+	return results, err
+}
+
+// SearchIVFFiltered is SearchIVF restricted to the entries admitted by filter
+// nprobe is widened like ef is for HNSW, and lists keep being probed past it
+// until k admitted entries are found
+func (column *Column) SearchIVFFiltered(query []float32, k int, nprobe int, filter *Filter) ([]SearchResult, error) {
+	if k <= 0 {
+		return nil, fmt.Errorf("k must be positive, got %d", k)
+	}
+	if err := column.ValidateQuery(query); err != nil {
+		return nil, err
+	}
+	bitmap, admitted, err := filter.resolve(column)
+	if err != nil {
+		return nil, err
+	}
+	var results []SearchResult
+	err = column.withIndex(IVFFlatIndex, func(src *vectorSource, index vectorIndex) error {
+		lists := index.(*ivf)
+		if bitmap != nil && admitted < exactFilterLimit {
+			var err error
+			results, err = column.scanTopK(query, k, lists.metric, bitmap)
+			return err
+		}
+		limit := src.len()
+		nprobe = widen(max(nprobe, 1), limit, admitted)
+		var err error
+		results, err = lists.search(src, query, k, nprobe, func(idx int64) bool { return idx < limit && admits(bitmap, idx) })
+		return err
+	})
+	return results, err
+}
+
+// widen scales a candidate list size by the inverse of the admitted fraction, capped at n
+func widen(size int, n int64, admitted int64) int {
+	if admitted <= 0 || admitted >= n {
+		return size
+	}
+	return int(min(float64(n), math.Ceil(float64(size)*float64(n)/float64(admitted))))
+}
@@ -0,0 +1,82 @@
+package db
+
+import (
+	"slices"
+	"testing"
+)
+
+// Below exactFilterLimit admitted entries the filtered index searches scan exactly.
+// Emptying the graph and the lists shows which path ran: only the scan can still find anything
+func TestFilteredSearchFallsBackToExactScan(t *testing.T) {
+	conn := openTestDB(t)
+	tbl, err := conn.AddTable("t", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	col, err := tbl.AddColumnWithSchema("c", 2, Schema{Metric: L2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range int64(exactFilterLimit + 500) {
+		opts := WriteColumnOptions{Kind: Floats}
+		if err := opts.AddFloats([]float32{float32(i), 0}); err != nil {
+			t.Fatal(err)
+		}
+		if err := col.AddVector(i, opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := col.BuildHNSW(HNSWParams{M: 8, EfConstruction: 32, Metric: L2, Seed: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := col.BuildIVF(IVFParams{Lists: 16, Metric: L2, Seed: 1}); err != nil {
+		t.Fatal(err)
+	}
+	graph := col.indexes.loaded[HNSWIndex].(*hnsw)
+	for node := range graph.links {
+		clear(graph.links[node])
+	}
+	lists := col.indexes.loaded[IVFFlatIndex].(*ivf)
+	clear(lists.lists)
+
+	query := []float32{105, 0}
+	searches := map[string]func(f *Filter) ([]SearchResult, error){
+		"hnsw": func(f *Filter) ([]SearchResult, error) { return col.SearchHNSWFiltered(query, 3, 16, f) },
+		"ivf":  func(f *Filter) ([]SearchResult, error) { return col.SearchIVFFiltered(query, 3, 1, f) },
+	}
+	for name, search := range searches {
+		few := TimeRangeFilter(100, 100+exactFilterLimit-1)
+		want, err := col.TopKFiltered(query, 3, L2, few)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := search(few)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(want) != 3 || !slices.Equal(got, want) {
+			t.Fatalf("%s below the limit returned %v, want the exact %v", name, got, want)
+		}
+
+		// a bitmap filter falls back the same way, even with fewer entries than k
+		pool := VariablePool{}
+		if err := col.Select(2000, 2002, "two", pool); err != nil {
+			t.Fatal(err)
+		}
+		got, err = search(BitmapFilter(pool, "two"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 2 || got[0].Index != 2000 || got[1].Index != 2001 {
+			t.Fatalf("%s over two admitted entries returned %v", name, got)
+		}
+
+		got, err = search(TimeRangeFilter(100, 100+exactFilterLimit))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) == 3 {
+			t.Fatalf("%s at the limit returned %v through an emptied index", name, got)
+		}
+	}
+}
@@ -0,0 +1,489 @@
+package db
+
+import (
+	"bytes"
+	"container/heap"
+	"fmt"
+	"math"
+	"math/rand"
+	"slices"
+)
+
+/*
+HNSW (Malkov & Yashunin) keeps a stack of proximity graphs, each layer holding
+a random subset of the one below it. Searches descend greedily through the
+sparse upper layers and then run a bounded best-first search on layer 0.
+
+Blob layout (little endian):
+	magic          "HNSW0001"
+	metric         uint8
+	m              uint32
+	efConstruction uint32
+	seed           int64
+	count          uint64
+	entry          int64 (-1 when empty)
+	maxLevel       uint32
+	per node:      level uint8, then per layer 0..level a uint32 degree and the neighbor ids
+*/
+
+var hnswMagic = []byte("HNSW0001")
+
+// HNSWParams configures an HNSW index
+// Zero fields fall back to M 16, EfConstruction 200 and the column schema's metric
+type HNSWParams struct {
+	M              int    // neighbors per node on the upper layers, layer 0 keeps 2*M
+	EfConstruction int    // candidate list size while inserting
+	Metric         Metric // metric the graph is built and searched with
+	Seed           int64  // seeds level assignment so builds are reproducible
+}
+
+type hnsw struct {
+	params   HNSWParams
+	metric   Metric
+	rng      *rand.Rand
+	levelMul float64
+	entry    int64 // -1 when the graph is empty
+	maxLevel int
+	links    [][][]uint32 // node -> layer -> neighbors
+}
+
+func newHNSW(params HNSWParams, metric Metric) *hnsw {
+	if params.M <= 0 {
+		params.M = 16
+	}
+	if params.EfConstruction <= 0 {
+		params.EfConstruction = 200
+	}
+	return &hnsw{
+		params:   params,
+		metric:   metric,
+		rng:      rand.New(rand.NewSource(params.Seed)),
+		levelMul: 1 / math.Log(float64(max(params.M, 2))),
+		entry:    -1,
+	}
+}
+
+func (h *hnsw) kind() IndexKind { return HNSWIndex }
+
+func (h *hnsw) count() int64 { return int64(len(h.links)) }
+
+// distance orders candidates, smaller is closer for every metric
+func (h *hnsw) distance(a, b []float32) float32 {
+	score := h.metric.Score(a, b)
+	if h.metric.HigherIsBetter() {
+		return -score
+	}
+	return score
+}
+
+// score turns a distance back into the metric's own value
+func (h *hnsw) score(dist float32) float32 {
+	if h.metric.HigherIsBetter() {
+		return -dist
+	}
+	return dist
+}
+
+func (h *hnsw) maxDegree(layer int) int {
+	if layer == 0 {
+		return 2 * h.params.M
+	}
+	return h.params.M
+}
+
+func (h *hnsw) add(src *vectorSource, idx int64) error {
+	if idx != h.count() {
+		return fmt.Errorf("hnsw index has %d entries, cannot add entry %d", h.count(), idx)
+	}
+	if idx >= math.MaxUint32 {
+		return fmt.Errorf("hnsw index is full")
+	}
+	vec, err := src.vector(idx)
+	if err != nil {
+		return err
+	}
+	level := int(-math.Log(1-h.rng.Float64()) * h.levelMul)
+	h.links = append(h.links, make([][]uint32, level+1))
+	if h.entry < 0 {
+		h.entry, h.maxLevel = idx, level
+		return nil
+	}
+
+	ep := candidate{idx: h.entry}
+	if ep.dist, err = h.distanceTo(src, vec, h.entry); err != nil {
+		return err
+	}
+	for layer := h.maxLevel; layer > level; layer-- {
+		if ep, err = h.greedy(src, vec, ep, layer); err != nil {
+			return err
+		}
+	}
+	entryPoints := []candidate{ep}
+	for layer := min(level, h.maxLevel); layer >= 0; layer-- {
+		found, err := h.searchLayer(src, vec, entryPoints, h.params.EfConstruction, layer, nil)
+		if err != nil {
+			return err
+		}
+		neighbors, err := h.selectNeighbors(src, found, h.params.M)
+		if err != nil {
+			return err
+		}
+		h.links[idx][layer] = neighbors
+		for _, n := range neighbors {
+			if err := h.connect(src, int64(n), uint32(idx), layer); err != nil {
+				return err
+			}
+		}
+		entryPoints = found
+	}
+	if level > h.maxLevel {
+		h.entry, h.maxLevel = idx, level
+	}
+	return nil
+}
+
+// connect adds a back link from node to neighbor, pruning node's list if it overflows
+func (h *hnsw) connect(src *vectorSource, node int64, neighbor uint32, layer int) error {
+	links := append(h.links[node][layer], neighbor)
+	if len(links) <= h.maxDegree(layer) {
+		h.links[node][layer] = links
+		return nil
+	}
+	vec, err := src.vector(node)
+	if err != nil {
+		return err
+	}
+	candidates := make([]candidate, 0, len(links))
+	for _, n := range links {
+		dist, err := h.distanceTo(src, vec, int64(n))
+		if err != nil {
+			return err
+		}
+		candidates = append(candidates, candidate{idx: int64(n), dist: dist})
+	}
+	pruned, err := h.selectNeighbors(src, candidates, h.maxDegree(layer))
+	if err != nil {
+		return err
+	}
+	h.links[node][layer] = pruned
+	return nil
+}
+
+// selectNeighbors applies the diversity heuristic: a candidate is kept only if it is
+// closer to the new node than to any neighbor already kept. Pruned candidates top
+// the list up so sparse regions still get m links
+func (h *hnsw) selectNeighbors(src *vectorSource, candidates []candidate, m int) ([]uint32, error) {
+	sorted := slices.Clone(candidates)
+	slices.SortFunc(sorted, compareCandidates)
+	kept := []candidate{}
+	pruned := []candidate{}
+	for _, c := range sorted {
+		if len(kept) >= m {
+			break
+		}
+		vec, err := src.vector(c.idx)
+		if err != nil {
+			return nil, err
+		}
+		diverse := true
+		for _, k := range kept {
+			dist, err := h.distanceTo(src, vec, k.idx)
+			if err != nil {
+				return nil, err
+			}
+			if dist < c.dist {
+				diverse = false
+				break
+			}
+		}
+		if diverse {
+			kept = append(kept, c)
+		} else {
+			pruned = append(pruned, c)
+		}
+	}
+	for _, c := range pruned {
+		if len(kept) >= m {
+			break
+		}
+		kept = append(kept, c)
+	}
+	neighbors := make([]uint32, len(kept))
+	for i, c := range kept {
+		neighbors[i] = uint32(c.idx)
+	}
+	return neighbors, nil
+}
+
+func (h *hnsw) distanceTo(src *vectorSource, vec []float32, idx int64) (float32, error) {
+	other, err := src.vector(idx)
+	if err != nil {
+		return 0, err
+	}
+	return h.distance(vec, other), nil
+}
+
+// greedy walks layer towards vec until no neighbor is closer
+// The graph can be ahead of src, nodes past its end are skipped
+func (h *hnsw) greedy(src *vectorSource, vec []float32, ep candidate, layer int) (candidate, error) {
+	for improved := true; improved; {
+		improved = false
+		for _, n := range h.links[ep.idx][layer] {
+			if int64(n) >= src.len() {
+				continue
+			}
+			dist, err := h.distanceTo(src, vec, int64(n))
+			if err != nil {
+				return ep, err
+			}
+			if dist < ep.dist {
+				ep, improved = candidate{idx: int64(n), dist: dist}, true
+			}
+		}
+	}
+	return ep, nil
+}
+
+// searchLayer is a best-first search keeping the ef closest nodes found
+// Nodes rejected by allow are still traversed but never returned, nodes past the
+// end of src are neither
+func (h *hnsw) searchLayer(src *vectorSource, vec []float32, entryPoints []candidate, ef int, layer int, allow func(int64) bool) ([]candidate, error) {
+	visited := make([]uint64, (len(h.links)+63)/64)
+	seen := func(idx int64) bool {
+		word, bit := idx/64, uint64(1)<<(idx%64)
+		if visited[word]&bit != 0 {
+			return true
+		}
+		visited[word] |= bit
+		return false
+	}
+	accepted := func(idx int64) bool { return allow == nil || allow(idx) }
+
+	frontier := &candidateHeap{}
+	results := &candidateHeap{farthest: true}
+	for _, ep := range entryPoints {
+		if seen(ep.idx) {
+			continue
+		}
+		heap.Push(frontier, ep)
+		if accepted(ep.idx) {
+			heap.Push(results, ep)
+		}
+	}
+	for frontier.Len() > 0 {
+		c := heap.Pop(frontier).(candidate)
+		if results.Len() >= ef && c.dist > results.items[0].dist {
+			break
+		}
+		for _, n := range h.links[c.idx][layer] {
+			if int64(n) >= src.len() || seen(int64(n)) {
+				continue
+			}
+			dist, err := h.distanceTo(src, vec, int64(n))
+			if err != nil {
+				return nil, err
+			}
+			if results.Len() < ef || dist < results.items[0].dist {
+				next := candidate{idx: int64(n), dist: dist}
+				heap.Push(frontier, next)
+				if accepted(next.idx) {
+					heap.Push(results, next)
+					if results.Len() > ef {
+						heap.Pop(results)
+					}
+				}
+			}
+		}
+	}
+	return results.items, nil
+}
+
+// entryWithin returns the entry point and top layer for a search over the first n nodes
+// Snapshots share the live graph, whose entry can be a node appended after theirs,
+// the highest node within n then takes its place. -1 when there is none
+func (h *hnsw) entryWithin(n int64) (int64, int) {
+	if h.entry < n {
+		return h.entry, h.maxLevel
+	}
+	entry, top := int64(-1), -1
+	for idx := range min(n, int64(len(h.links))) {
+		if level := len(h.links[idx]) - 1; level > top {
+			entry, top = idx, level
+		}
+	}
+	return entry, top
+}
+
+// search returns up to k accepted nodes closest to query, best first
+func (h *hnsw) search(src *vectorSource, query []float32, k int, ef int, allow func(int64) bool) ([]SearchResult, error) {
+	entry, top := h.entryWithin(src.len())
+	if entry < 0 {
+		return []SearchResult{}, nil
+	}
+	ep := candidate{idx: entry}
+	var err error
+	if ep.dist, err = h.distanceTo(src, query, entry); err != nil {
+		return nil, err
+	}
+	for layer := top; layer > 0; layer-- {
+		if ep, err = h.greedy(src, query, ep, layer); err != nil {
+			return nil, err
+		}
+	}
+	found, err := h.searchLayer(src, query, []candidate{ep}, max(ef, k), 0, allow)
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(found, compareCandidates)
+	results := make([]SearchResult, 0, min(k, len(found)))
+	for _, c := range found[:min(k, len(found))] {
+		ts, err := src.timestamp(c.idx)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, SearchResult{Index: c.idx, Timestamp: ts, Score: h.score(c.dist)})
+	}
+	return results, nil
+}
+
+func (h *hnsw) encode() []byte {
+	var buf bytes.Buffer
+	put := func(v uint64, size int) {
+		var b [8]byte
+		ByteOrder.PutUint64(b[:], v)
+		buf.Write(b[:size])
+	}
+	buf.Write(hnswMagic)
+	buf.WriteByte(byte(h.metric))
+	put(uint64(h.params.M), 4)
+	put(uint64(h.params.EfConstruction), 4)
+	put(uint64(h.params.Seed), 8)
+	put(uint64(h.count()), 8)
+	put(uint64(h.entry), 8)
+	put(uint64(h.maxLevel), 4)
+	for _, layers := range h.links {
+		buf.WriteByte(byte(len(layers) - 1))
+		for _, neighbors := range layers {
+			put(uint64(len(neighbors)), 4)
+			for _, n := range neighbors {
+				put(uint64(n), 4)
+			}
+		}
+	}
+	return buf.Bytes()
+}
+
+func decodeHNSW(b []byte) (*hnsw, error) {
+	if len(b) < 45 || !bytes.Equal(b[:8], hnswMagic) {
+		return nil, fmt.Errorf("not an hnsw index")
+	}
+	metric := Metric(b[8])
+	if err := metric.validate(); err != nil {
+		return nil, err
+	}
+	params := HNSWParams{
+		M:              int(ByteOrder.Uint32(b[9:])),
+		EfConstruction: int(ByteOrder.Uint32(b[13:])),
+		Seed:           int64(ByteOrder.Uint64(b[17:])),
+	}
+	count := int64(ByteOrder.Uint64(b[25:]))
+	h := newHNSW(params, metric)
+	h.entry = int64(ByteOrder.Uint64(b[33:]))
+	h.maxLevel = int(ByteOrder.Uint32(b[41:]))
+	// reseed so levels drawn after a reload do not repeat the ones already drawn
+	h.rng = rand.New(rand.NewSource(params.Seed + count))
+
+	p := b[45:]
+	truncated := fmt.Errorf("hnsw index truncated")
+	h.links = make([][][]uint32, 0, count)
+	for range count {
+		if len(p) < 1 {
+			return nil, truncated
+		}
+		layers := make([][]uint32, int(p[0])+1)
+		p = p[1:]
+		for layer := range layers {
+			if len(p) < 4 {
+				return nil, truncated
+			}
+			degree := int64(ByteOrder.Uint32(p))
+			p = p[4:]
+			if int64(len(p)) < degree*4 {
+				return nil, truncated
+			}
+			neighbors := make([]uint32, degree)
+			for i := range neighbors {
+				neighbors[i] = ByteOrder.Uint32(p[i*4:])
+				if int64(neighbors[i]) >= count {
+					return nil, fmt.Errorf("hnsw neighbor %d out of range", neighbors[i])
+				}
+			}
+			p = p[degree*4:]
+			layers[layer] = neighbors
+		}
+		h.links = append(h.links, layers)
+	}
+	if (count == 0) != (h.entry < 0) || h.entry >= count || (count > 0 && len(h.links[h.entry])-1 != h.maxLevel) {
+		return nil, fmt.Errorf("hnsw entry point %d invalid", h.entry)
+	}
+	return h, nil
+}
+
+// BuildHNSW builds an HNSW index over the column and persists it, replacing any existing one
+// The graph is built from a snapshot so appends continue meanwhile, they are
+// indexed once the build finishes and by every AddVector after that
+func (column *Column) BuildHNSW(params HNSWParams) error {
+	metric, err := column.metricOr(params.Metric)
+	if err != nil {
+		return err
+	}
+	return column.buildIndex(newHNSW(params, metric), nil)
+}
+
+// SearchHNSW returns the k entries closest to query using the column's HNSW index, best first
+// ef bounds the candidate list, larger values trade speed for recall and it is raised to k if smaller
+func (column *Column) SearchHNSW(query []float32, k int, ef int) ([]SearchResult, error) {
+	return column.SearchHNSWFiltered(query, k, ef, nil)
+}
+
+// candidate is a node with its distance to the current query
+type candidate struct {
+	idx  int64
+	dist float32
+}
+
+func compareCandidates(a, b candidate) int {
+	switch {
+	case a.dist < b.dist:
+		return -1
+	case a.dist > b.dist:
+		return 1
+	default:
+		return int(a.idx - b.idx)
+	}
+}
+
+// candidateHeap pops the closest candidate first, or the farthest when farthest is set
+type candidateHeap struct {
+	items    []candidate
+	farthest bool
+}
+
+func (h *candidateHeap) Len() int { return len(h.items) }
+
+func (h *candidateHeap) Less(i, j int) bool {
+	if h.farthest {
+		return h.items[i].dist > h.items[j].dist
+	}
+	return h.items[i].dist < h.items[j].dist
+}
+
+func (h *candidateHeap) Swap(i, j int) { h.items[i], h.items[j] = h.items[j], h.items[i] }
+
+func (h *candidateHeap) Push(x any) { h.items = append(h.items, x.(candidate)) }
+
+func (h *candidateHeap) Pop() any {
+	last := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	return last
+}
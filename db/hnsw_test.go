@@ -0,0 +1,50 @@
+package db
+
+import (
+	"context"
+	"testing"
+)
+
+// A snapshot shares the live graph, searching it after appends must stay within its entries
+func TestSnapshotHNSWSearchAfterAppends(t *testing.T) {
+	conn := openTestDB(t)
+	col := newTestColumn(t, conn, "c", 4)
+	// past exactFilterLimit so the planner picks the index over an exact scan
+	const frozenLen = 4200
+	appendTestVectors(t, col, frozenLen)
+	if err := col.BuildHNSW(HNSWParams{M: 8, Metric: L2, Seed: 1}); err != nil {
+		t.Fatal(err)
+	}
+	frozen := col.Snapshot()
+	appendTestVectors(t, col, 3000)
+	// with this seed a node appended after the snapshot becomes the entry point
+	if entry := col.indexes.loaded[HNSWIndex].(*hnsw).entry; entry < frozenLen {
+		t.Fatalf("entry point %d is still inside the snapshot", entry)
+	}
+
+	results, err := frozen.SearchHNSW([]float32{250, 250, 250, 250}, 5, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 5 || results[0].Index != 250 {
+		t.Fatalf("snapshot search returned %v, want entry 250 first", results)
+	}
+
+	// entries past the snapshot are the closest ones, none of them may come back
+	far := []float32{6000, 6000, 6000, 6000}
+	res, err := Query(frozen).Near(far).Metric(L2).Limit(3).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if op := res.Plan.Root.Operator; op != "hnsw-probe" {
+		t.Fatalf("planner chose %s, want the hnsw index", op)
+	}
+	if len(res.Entries) != 3 || res.Entries[0].Index != frozenLen-1 {
+		t.Fatalf("planned snapshot query returned %v, want entry %d first", res.Entries, frozenLen-1)
+	}
+	for _, entry := range res.Entries {
+		if entry.Index >= frozenLen {
+			t.Fatalf("snapshot query returned entry %d appended after it", entry.Index)
+		}
+	}
+}
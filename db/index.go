@@ -0,0 +1,634 @@
+package db
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+)
+
+/*
+Approximate indexes are kept in memory per column and persisted as blob chains
+in the data region. The catalog holds one record per index pointing at its
+chain, along with how many entries were indexed when it was saved. Entries
+appended after a save are indexed again when the index is next loaded, so a
+stale blob is never wrong, only behind.
+*/
+
+// IndexKind identifies the type of an approximate index
+type IndexKind uint8
+
+const (
+	HNSWIndex IndexKind = iota + 1
+	IVFFlatIndex
+	DiskANNIndex
+)
+
+func (kind IndexKind) String() string {
+	switch kind {
+	case HNSWIndex:
+		return "hnsw"
+	case IVFFlatIndex:
+		return "ivf-flat"
+	case DiskANNIndex:
+		return "diskann"
+	default:
+		return fmt.Sprintf("IndexKind(%d)", uint8(kind))
+	}
+}
+
+// vectorIndex is implemented by every approximate index a column can carry
+// Entries are always added in column order, so count is also the next index to add
+type vectorIndex interface {
+	kind() IndexKind
+	count() int64
+	add(src *vectorSource, idx int64) error
+	encode() []byte
+}
+
+// diskIndex is implemented by indexes that keep part of their structure in blob chains
+// of their own rather than in the encoded blob. flush writes that structure out before
+// the index is first saved, attach points the index at it once it is in the file, and
+// chains lists the blobs so they are backed up and freed along with the index
+type diskIndex interface {
+	flush(conn *DB) error
+	attach(r region) error
+	chains() []int64
+}
+
+// storedIndex locates a persisted index blob
+type storedIndex struct {
+	first  int64   // first chunk of the blob chain
+	length int64   // blob size in bytes
+	count  int64   // entries indexed when the blob was written
+	chains []int64 // first chunks of the blobs owned by a diskIndex
+}
+
+// indexSet holds a column's indexes, shared between the live column and its frozen copies
+// mu guards the maps and the loaded indexes themselves, writers hold it exclusively while adding
+type indexSet struct {
+	mu     sync.RWMutex
+	loaded map[IndexKind]vectorIndex
+	stored map[IndexKind]storedIndex
+}
+
+func newIndexSet() *indexSet {
+	return &indexSet{
+		loaded: map[IndexKind]vectorIndex{},
+		stored: map[IndexKind]storedIndex{},
+	}
+}
+
+// version 1 records predate disk indexes and carry no chains
+const indexRecordVersion = 2
+
+const (
+	indexPut byte = iota
+	indexDrop
+)
+
+func indexRecord(owner int64, op byte, kind IndexKind, stored storedIndex) catalogRecord {
+	payload := []byte{op, byte(kind)}
+	payload = ByteOrder.AppendUint64(payload, uint64(stored.first))
+	payload = ByteOrder.AppendUint64(payload, uint64(stored.length))
+	payload = ByteOrder.AppendUint64(payload, uint64(stored.count))
+	payload = ByteOrder.AppendUint32(payload, uint32(len(stored.chains)))
+	for _, chain := range stored.chains {
+		payload = ByteOrder.AppendUint64(payload, uint64(chain))
+	}
+	return catalogRecord{
+		kind:    recordIndex,
+		version: indexRecordVersion,
+		owner:   owner,
+		payload: payload,
+	}
+}
+
+// applyIndexRecord replays an index record onto the column's set
+func (column *Column) applyIndexRecord(rec catalogRecord) error {
+	p := rec.payload
+	if len(p) < 26 || rec.version > indexRecordVersion || (rec.version == 1 && len(p) != 26) {
+		return fmt.Errorf("malformed index record")
+	}
+	kind := IndexKind(p[1])
+	if p[0] == indexDrop {
+		delete(column.indexes.stored, kind)
+		return nil
+	}
+	stored := storedIndex{
+		first:  int64(ByteOrder.Uint64(p[2:])),
+		length: int64(ByteOrder.Uint64(p[10:])),
+		count:  int64(ByteOrder.Uint64(p[18:])),
+	}
+	if rec.version >= 2 {
+		if len(p) < 30 || int64(len(p)) != 30+int64(ByteOrder.Uint32(p[26:]))*8 {
+			return fmt.Errorf("malformed index record")
+		}
+		for c := p[30:]; len(c) > 0; c = c[8:] {
+			stored.chains = append(stored.chains, int64(ByteOrder.Uint64(c)))
+		}
+	}
+	column.indexes.stored[kind] = stored
+	return nil
+}
+
+// records returns the catalog records for every persisted index
+func (set *indexSet) records(owner int64) []catalogRecord {
+	set.mu.RLock()
+	defer set.mu.RUnlock()
+	records := []catalogRecord{}
+	for kind, stored := range set.stored {
+		records = append(records, indexRecord(owner, indexPut, kind, stored))
+	}
+	return records
+}
+
+// vectorSource gives random access to the entries of a column view
+// Every chunk but the tail holds the same number of entries, so an index maps
+// straight to its chunk. Only valid while the region is pinned
+type vectorSource struct {
+	r         region
+	view      columnView
+	chunks    []int64
+	cache     *chunkCache // entry bytes of recently used chunks
+	perChunk  int64
+	entrySize int64
+	vecLen    int
+
+	// set when the source owns its pin, see yield
+	file   *store
+	unpin  func()
+	yields int
+}
+
+// yieldEvery is how many yield calls an owned pin is held across
+const yieldEvery = 1024
+
+// sourceCacheChunks bounds the chunks a source keeps read
+// Backends that are not mapped copy each chunk out, so this caps what a build holds
+const sourceCacheChunks = 4
+
+// pinnedSource pins file and returns a source over view that owns the pin
+// Long builds call yield between steps so Grow, and the appends behind it, are not
+// held off for the whole build. Call release when done
+func pinnedSource(file *store, view columnView) *vectorSource {
+	r, unpin := file.Pin()
+	src := newVectorSource(r, view)
+	src.file, src.unpin = file, unpin
+	return src
+}
+
+// yield trades an owned pin for a fresh one every yieldEvery calls, letting waiting
+// Grow and Close calls through. Slices read before a yield are invalid after it
+// A no-op for sources that do not own their pin
+func (src *vectorSource) yield() {
+	if src.file == nil {
+		return
+	}
+	if src.yields++; src.yields%yieldEvery != 0 {
+		return
+	}
+	src.r, src.unpin = src.file.repin(src.unpin)
+	src.cache = newChunkCache(sourceCacheChunks) // cached chunks alias the old mapping
+}
+
+// release drops the pin of a source from pinnedSource
+func (src *vectorSource) release() {
+	src.unpin()
+}
+
+func newVectorSource(r region, view columnView) *vectorSource {
+	src := &vectorSource{
+		r:         r,
+		view:      view,
+		entrySize: 8 + view.meta.vectorLength*4,
+		vecLen:    int(view.meta.vectorLength),
+	}
+	src.perChunk = (ChunkSize - ChunkHeaderSize) / src.entrySize
+	for chunk := view.meta.firstChunkOffset; chunk != 0; chunk = r.chunkHeader(chunk).nextChunk {
+		src.chunks = append(src.chunks, chunk)
+		if chunk == view.tailChunk {
+			break
+		}
+	}
+	src.cache = newChunkCache(sourceCacheChunks)
+	return src
+}
+
+// len is the number of entries visible through the source
+func (src *vectorSource) len() int64 {
+	return src.view.meta.numVectors
+}
+
+// entry returns the raw bytes of entry idx, timestamp first
+func (src *vectorSource) entry(idx int64) ([]byte, error) {
+	if idx < 0 || idx >= src.len() || idx/src.perChunk >= int64(len(src.chunks)) {
+		return nil, fmt.Errorf("entry %d out of range", idx)
+	}
+	c, i := idx/src.perChunk, idx%src.perChunk
+	chunk := src.chunks[c]
+	data, err := src.cache.get(chunk, func() ([]byte, error) {
+		header := src.r.chunkHeader(chunk)
+		return src.r.entries(chunk, header, min(header.numVectors, src.perChunk)*src.entrySize)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if (i+1)*src.entrySize > int64(len(data)) {
+		return nil, fmt.Errorf("entry %d out of range", idx)
+	}
+	return data[i*src.entrySize : (i+1)*src.entrySize], nil
+}
+
+// vector returns the features of entry idx
+func (src *vectorSource) vector(idx int64) ([]float32, error) {
+	entry, err := src.entry(idx)
+	if err != nil {
+		return nil, err
+	}
+	return readVec(entry[8:], src.vecLen), nil
+}
+
+// timestamp returns the timestamp of entry idx
+func (src *vectorSource) timestamp(idx int64) (uint64, error) {
+	entry, err := src.entry(idx)
+	if err != nil {
+		return 0, err
+	}
+	return ByteOrder.Uint64(entry), nil
+}
+
+// catchUp indexes every entry of src the index has not seen yet
+func catchUp(index vectorIndex, src *vectorSource) error {
+	for idx := index.count(); idx < src.len(); idx++ {
+		if err := index.add(src, idx); err != nil {
+			return err
+		}
+		src.yield()
+	}
+	return nil
+}
+
+// decodeIndex rebuilds an index of the given kind from its blob
+func decodeIndex(kind IndexKind, b []byte) (vectorIndex, error) {
+	switch kind {
+	case HNSWIndex:
+		return decodeHNSW(b)
+	case IVFFlatIndex:
+		return decodeIVF(b)
+	case DiskANNIndex:
+		return decodeVamana(b)
+	default:
+		return nil, fmt.Errorf("unknown index kind %d", kind)
+	}
+}
+
+// loadIndexLocked returns the column's index of the given kind, loading it from its blob
+// and indexing entries appended since it was saved. The caller must hold r pinned
+// and must hold column.indexes.mu for writing
+func (column *Column) loadIndexLocked(r region, kind IndexKind) (vectorIndex, error) {
+	set := column.indexes
+	index, ok := set.loaded[kind]
+	if !ok {
+		stored, ok := set.stored[kind]
+		if !ok {
+			return nil, fmt.Errorf("column %s has no %s index", column.meta.name.String(), kind)
+		}
+		blob, err := r.readBlob(stored.first)
+		if err != nil {
+			slog.Error("Could not read index", "column", column.meta.name.String(), "index", kind, "error", err)
+			return nil, err
+		}
+		if int64(len(blob)) != stored.length {
+			slog.Error("Index blob is truncated", "column", column.meta.name.String(), "index", kind, "size", len(blob), "want", stored.length)
+			return nil, fmt.Errorf("index blob truncated")
+		}
+		decoded, err := decodeIndex(kind, blob)
+		if err == nil {
+			if disk, ok := decoded.(diskIndex); ok {
+				err = disk.attach(r)
+			}
+		}
+		if err != nil {
+			slog.Error("Could not decode index", "column", column.meta.name.String(), "index", kind, "error", err)
+			return nil, err
+		}
+		index = decoded
+	}
+	if err := catchUp(index, newVectorSource(r, column.view())); err != nil {
+		return nil, err
+	}
+	set.loaded[kind] = index
+	return index, nil
+}
+
+// withIndex runs fn against the column's index of the given kind with the region pinned
+// fn must only read the index
+func (column *Column) withIndex(kind IndexKind, fn func(src *vectorSource, index vectorIndex) error) error {
+	r, unpin := column.file.Pin()
+	defer unpin()
+	set := column.indexes
+	set.mu.RLock()
+	index, ok := set.loaded[kind]
+	if ok && index.count() >= column.view().meta.numVectors {
+		defer set.mu.RUnlock()
+		return fn(newVectorSource(r, column.view()), index)
+	}
+	set.mu.RUnlock()
+
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	index, err := column.loadIndexLocked(r, kind)
+	if err != nil {
+		return err
+	}
+	return fn(newVectorSource(r, column.view()), index)
+}
+
+// updateIndexes adds the newest entries to every loaded index
+// Caller must hold the writer lock and r pinned
+func (column *Column) updateIndexes(r region) {
+	set := column.indexes
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	if len(set.loaded) == 0 {
+		return
+	}
+	src := newVectorSource(r, column.view())
+	for kind, index := range set.loaded {
+		if err := catchUp(index, src); err != nil {
+			// the entry is already committed, the index catches up from its blob on the next load
+			slog.Warn("Could not update index, dropping it from memory", "column", column.meta.name.String(), "index", kind, "error", err)
+			delete(set.loaded, kind)
+		}
+	}
+}
+
+// buildIndex fills index from a snapshot of the column so appends continue meanwhile,
+// then installs it under the writer lock. train runs first over the same snapshot
+func (column *Column) buildIndex(index vectorIndex, train func(src *vectorSource) error) error {
+	if !column.checkWritable() {
+		return fmt.Errorf("Bruh")
+	}
+	if err := column.fillIndex(index, train); err != nil {
+		slog.Error("Could not build index", "column", column.meta.name.String(), "index", index.kind(), "error", err)
+		return err
+	}
+
+	unlock := column.file.lockWriter()
+	defer unlock()
+	return column.installIndex(index)
+}
+
+// fillIndex trains index and adds every entry of a snapshot of the column to it
+// The pin is yielded between entries so the file can grow while the build runs
+func (column *Column) fillIndex(index vectorIndex, train func(src *vectorSource) error) error {
+	src := pinnedSource(column.file, column.Snapshot().view())
+	defer src.release()
+	if train != nil {
+		if err := train(src); err != nil {
+			return err
+		}
+	}
+	return catchUp(index, src)
+}
+
+// installIndex makes index the column's index of its kind and persists it
+// Caller must hold the writer lock and no pins
+func (column *Column) installIndex(index vectorIndex) error {
+	set := column.indexes
+	disk, isDisk := index.(diskIndex)
+	if isDisk {
+		// writing the blob can grow the file, so it happens before any pin is taken
+		if err := disk.flush(column.conn); err != nil {
+			return err
+		}
+	}
+	r, unpin := column.file.Pin()
+	set.mu.Lock()
+	var err error
+	if isDisk {
+		err = disk.attach(r)
+	}
+	if err == nil {
+		err = catchUp(index, newVectorSource(r, column.view()))
+	}
+	if err == nil {
+		set.loaded[index.kind()] = index
+	}
+	set.mu.Unlock()
+	unpin()
+	if err != nil {
+		return err
+	}
+	return column.saveIndexLocked(index.kind())
+}
+
+// Indexes lists the kinds of index the column carries
+func (column *Column) Indexes() []IndexKind {
+	set := column.indexes
+	set.mu.RLock()
+	defer set.mu.RUnlock()
+	kinds := []IndexKind{}
+	for kind := range set.loaded {
+		kinds = append(kinds, kind)
+	}
+	for kind := range set.stored {
+		if _, ok := set.loaded[kind]; !ok {
+			kinds = append(kinds, kind)
+		}
+	}
+	slices.Sort(kinds)
+	return kinds
+}
+
+// SaveIndexes persists every index that has entries its blob does not
+// Indexes are also saved on Close, call this to bound the work redone after a crash
+func (column *Column) SaveIndexes() error {
+	if !column.checkWritable() {
+		return fmt.Errorf("Bruh")
+	}
+	unlock := column.file.lockWriter()
+	defer unlock()
+	return column.saveIndexesLocked()
+}
+
+// helper to save every stale index, caller must hold the writer lock and no pins
+func (column *Column) saveIndexesLocked() error {
+	column.indexes.mu.RLock()
+	kinds := []IndexKind{}
+	for kind, index := range column.indexes.loaded {
+		if stored, ok := column.indexes.stored[kind]; !ok || stored.count != index.count() {
+			kinds = append(kinds, kind)
+		}
+	}
+	column.indexes.mu.RUnlock()
+	for _, kind := range kinds {
+		if err := column.saveIndexLocked(kind); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// helper that writes a new blob for the index and frees the old one once the record points away from it
+// Caller must hold the writer lock and no pins
+func (column *Column) saveIndexLocked(kind IndexKind) error {
+	set := column.indexes
+	// the writer lock keeps appends out, so the index cannot change underneath us
+	set.mu.RLock()
+	index, ok := set.loaded[kind]
+	old, hadOld := set.stored[kind]
+	var blob []byte
+	var count int64
+	if ok {
+		blob, count = index.encode(), index.count()
+	}
+	set.mu.RUnlock()
+	if !ok {
+		return nil
+	}
+
+	first, err := column.conn.writeBlob(blob)
+	if err != nil {
+		slog.Error("Could not write index blob", "column", column.meta.name.String(), "index", kind, "error", err)
+		return err
+	}
+	stored := storedIndex{first: first, length: int64(len(blob)), count: count}
+	if disk, ok := index.(diskIndex); ok {
+		stored.chains = disk.chains()
+	}
+	set.mu.Lock()
+	set.stored[kind] = stored
+	set.mu.Unlock()
+	r, unpin := column.file.Pin()
+	err = column.conn.appendRecord(r, indexRecord(column.meta.offset, indexPut, kind, stored))
+	unpin()
+	if err != nil {
+		set.mu.Lock()
+		if hadOld {
+			set.stored[kind] = old
+		} else {
+			delete(set.stored, kind)
+		}
+		set.mu.Unlock()
+		column.conn.freeBlob(first)
+		return err
+	}
+	if hadOld {
+		// a rebuilt disk index brings new chains, the ones it kept stay allocated
+		if err := column.conn.freeStored(old, stored.chains); err != nil {
+			return err
+		}
+	}
+	return column.file.commit()
+}
+
+// DropIndex removes the column's index of the given kind and releases its blob
+func (column *Column) DropIndex(kind IndexKind) error {
+	if !column.checkWritable() {
+		return fmt.Errorf("Bruh")
+	}
+	unlock := column.file.lockWriter()
+	defer unlock()
+
+	set := column.indexes
+	set.mu.Lock()
+	_, loaded := set.loaded[kind]
+	stored, hadStored := set.stored[kind]
+	delete(set.loaded, kind)
+	delete(set.stored, kind)
+	set.mu.Unlock()
+	if !loaded && !hadStored {
+		return fmt.Errorf("column %s has no %s index", column.meta.name.String(), kind)
+	}
+	if !hadStored {
+		return nil
+	}
+	r, unpin := column.file.Pin()
+	err := column.conn.appendRecord(r, indexRecord(column.meta.offset, indexDrop, kind, storedIndex{}))
+	unpin()
+	if err != nil {
+		return err
+	}
+	if err := column.conn.freeStored(stored, nil); err != nil {
+		return err
+	}
+	return column.file.commit()
+}
+
+// freeStored releases an index blob and every chain it owns that is not in keep
+// Caller must hold the writer lock and no pins
+func (conn *DB) freeStored(stored storedIndex, keep []int64) error {
+	if err := conn.freeBlob(stored.first); err != nil {
+		return err
+	}
+	for _, chain := range stored.chains {
+		if slices.Contains(keep, chain) {
+			continue
+		}
+		if err := conn.freeBlob(chain); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// saveAllIndexes persists the indexes of every column, caller must hold the writer lock
+func (conn *DB) saveAllIndexes() error {
+	conn.mu.RLock()
+	columns := []*Column{}
+	for _, tbl := range conn.tables {
+		tbl.mu.RLock()
+		columns = append(columns, tbl.columns...)
+		tbl.mu.RUnlock()
+	}
+	conn.mu.RUnlock()
+	for _, col := range columns {
+		if err := col.saveIndexesLocked(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// backupChunks copies the chunks of every persisted index blob and the chains it owns
+func (set *indexSet) backupChunks(r region) ([]backupChunk, error) {
+	set.mu.RLock()
+	defer set.mu.RUnlock()
+	chunks := []backupChunk{}
+	for _, stored := range set.stored {
+		for _, chunk := range r.blobChunks(stored.first) {
+			copied, err := blobBackupChunk(r, chunk)
+			if err != nil {
+				return nil, err
+			}
+			chunks = append(chunks, copied)
+		}
+		for _, chain := range stored.chains {
+			for _, chunk := range r.blobChunks(chain) {
+				copied, err := blobBackupChunk(r, chunk)
+				if err != nil {
+					return nil, err
+				}
+				chunks = append(chunks, copied)
+			}
+		}
+	}
+	return chunks, nil
+}
+
+// helper describing one blob chunk for a backup
+func blobBackupChunk(r region, chunk int64) (backupChunk, error) {
+	header := r.chunkHeader(chunk)
+	data, err := r.read(chunk+ChunkHeaderSize, min(header.numVectors, blobChunkCapacity))
+	if err != nil {
+		return backupChunk{}, err
+	}
+	return backupChunk{
+		offset: chunk,
+		header: header,
+		size:   int64(len(data)),
+		data:   slices.Clone(data),
+	}, nil
+}
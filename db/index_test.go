@@ -0,0 +1,85 @@
+package db
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// A long build yields its pin, so the file grows and appends go on while it runs
+func TestBuildIndexYieldsPin(t *testing.T) {
+	conn := openTestDB(t)
+	tbl, err := conn.AddTable("t", 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	col, err := tbl.AddColumn("c", 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendTestVectors(t, col, 8*yieldEvery)
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() { done <- col.BuildHNSW(HNSWParams{M: 8, EfConstruction: 32, Metric: L2, Seed: 1}) }()
+	// wait for the build to take its pin
+	for pinned := false; !pinned; time.Sleep(time.Millisecond) {
+		conn.file.mu.Lock()
+		pinned = conn.file.pins > 0
+		conn.file.mu.Unlock()
+	}
+	// every column takes a chunk, enough of them grows the file
+	for i := 0; i < 4; i++ {
+		if _, err := tbl.AddColumn(fmt.Sprintf("grow%d", i), 8); err != nil {
+			t.Fatal(err)
+		}
+	}
+	appendTestVectors(t, col, 10)
+	grown := time.Since(start)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	// the first yield comes an eighth of the way in, holding the pin throughout
+	// only lets the file grow once the build is all but done
+	if built := time.Since(start); grown > built/2 {
+		t.Fatalf("the file grew %s into a %s build", grown, built)
+	}
+
+	results, err := col.SearchHNSW([]float32{5, 5, 5, 5, 5, 5, 5, 5}, 1, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Index != 5 {
+		t.Fatalf("search after the build returned %v, want entry 5", results)
+	}
+}
+
+// A source over an unmapped backend holds at most its cache of chunk copies
+func TestVectorSourceCacheIsBounded(t *testing.T) {
+	conn, err := Open(NewMemoryStorage(InitialFileSize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	col := newTestColumn(t, conn, "c", 16384)
+	appendTestVectors(t, col, 1100)
+
+	src := pinnedSource(conn.file, col.Snapshot().view())
+	defer src.release()
+	if len(src.chunks) < 2 {
+		t.Fatalf("column spans %d chunks, want at least 2", len(src.chunks))
+	}
+	src.cache = newChunkCache(1)
+	for _, idx := range []int64{0, 1099, 1, 1098, 500} {
+		vec, err := src.vector(idx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if vec[0] != float32(idx) || vec[len(vec)-1] != float32(idx) {
+			t.Fatalf("entry %d reads %v..%v", idx, vec[0], vec[len(vec)-1])
+		}
+		if n := src.cache.order.Len(); n > 1 {
+			t.Fatalf("source caches %d chunks, want at most 1", n)
+		}
+	}
+}
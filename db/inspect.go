@@ -0,0 +1,276 @@
+package db
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+)
+
+// Layout is a dump of the on-disk structure of a .ken file
+type Layout struct {
+	Path           string            `json:"path"`
+	FileSize       int64             `json:"fileSize"`
+	MetadataCursor int64             `json:"metadataCursor"`
+	DataCursor     int64             `json:"dataCursor"`
+	Properties     map[string]string `json:"properties,omitempty"`
+	Tables         []TableLayout     `json:"tables"`
+	Usage          SpaceUsage        `json:"usage"`
+}
+
+type TableLayout struct {
+	Name        string            `json:"name"`
+	Offset      int64             `json:"offset"`
+	ColumnSlots int64             `json:"columnSlots"`
+	Properties  map[string]string `json:"properties,omitempty"`
+	Columns     []ColumnLayout    `json:"columns"`
+}
+
+type ColumnLayout struct {
+	Name         string        `json:"name"`
+	Offset       int64         `json:"offset"`
+	VectorLength int64         `json:"vectorLength"`
+	NumVectors   int64         `json:"numVectors"`
+	FirstChunk   int64         `json:"firstChunk"`
+	Schema       *Schema       `json:"schema,omitempty"`
+	Chunks       []ChunkLayout `json:"chunks"`
+	Errors       []string      `json:"errors,omitempty"`
+}
+
+type ChunkLayout struct {
+	Offset     int64   `json:"offset"`
+	Next       int64   `json:"next"`
+	NumVectors int64   `json:"numVectors"`
+	Location   string  `json:"location"`
+	UsedBytes  int64   `json:"usedBytes"`
+	FillRatio  float64 `json:"fillRatio"`
+	// timestamps are only known for local chunks
+	MinTimestamp uint64 `json:"minTimestamp,omitempty"`
+	MaxTimestamp uint64 `json:"maxTimestamp,omitempty"`
+}
+
+type SpaceUsage struct {
+	FileBytes       int64   `json:"fileBytes"`
+	CatalogBytes    int64   `json:"catalogBytes"`
+	ExtensionBytes  int64   `json:"extensionBytes"`
+	Chunks          int64   `json:"chunks"`
+	OffloadedChunks int64   `json:"offloadedChunks"`
+	ChunkBytes      int64   `json:"chunkBytes"`
+	UsedChunkBytes  int64   `json:"usedChunkBytes"`
+	FillRatio       float64 `json:"fillRatio"`
+}
+
+func (loc ChunkLocation) String() string {
+	switch loc {
+	case Local:
+		return "local"
+	case Remote:
+		return "remote"
+	default:
+		return fmt.Sprintf("unknown(%d)", uint8(loc))
+	}
+}
+
+// InspectFile reads the layout of a .ken file without opening it as a database
+// The file is never written, so it is safe to run against a broken or live file
+func InspectFile(path string) (*Layout, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	layout, err := Inspect(f, info.Size())
+	if layout != nil {
+		layout.Path = path
+	}
+	return layout, err
+}
+
+// Inspect reads the layout of a .ken image of the given size
+func Inspect(ra io.ReaderAt, size int64) (*Layout, error) {
+	if size < DataRegionStart {
+		return nil, fmt.Errorf("file is %d bytes, smaller than the metadata region", size)
+	}
+	conn := &DB{file: newStore(&readOnlyStorage{ra: ra, size: size})}
+	r, unpin := conn.file.Pin()
+	defer unpin()
+
+	layout := &Layout{
+		FileSize:       size,
+		MetadataCursor: r.metadataCursor(),
+		DataCursor:     r.dataCursor(),
+	}
+	if layout.MetadataCursor < MetadataRegionStart || layout.MetadataCursor > ExtensionRegionStart {
+		return layout, fmt.Errorf("metadata cursor %d outside the catalog", layout.MetadataCursor)
+	}
+	conn.tables = loadTables(r, conn)
+	conn.applyRecords(r.readRecords())
+	layout.Properties = conn.ListProperties()
+
+	usage := &layout.Usage
+	usage.FileBytes = size
+	usage.CatalogBytes = layout.MetadataCursor - MetadataRegionStart
+	if cursor := r.extensionCursor(); cursor != 0 {
+		start, _ := extensionSlot(cursor)
+		usage.ExtensionBytes = extensionHeaderSize + cursor - start
+	}
+	for _, tbl := range conn.tables {
+		tl := TableLayout{
+			Name:        tbl.meta.name.String(),
+			Offset:      tbl.meta.offset,
+			ColumnSlots: tbl.meta.numColumns,
+			Properties:  tbl.ListProperties(),
+			Columns:     []ColumnLayout{},
+		}
+		for _, col := range tbl.columns {
+			cl := inspectColumn(r, col)
+			for _, chunk := range cl.Chunks {
+				usage.Chunks++
+				usage.ChunkBytes += ChunkSize
+				usage.UsedChunkBytes += chunk.UsedBytes
+				if chunk.Location == Remote.String() {
+					usage.OffloadedChunks++
+				}
+			}
+			tl.Columns = append(tl.Columns, cl)
+		}
+		layout.Tables = append(layout.Tables, tl)
+	}
+	if usage.ChunkBytes > 0 {
+		usage.FillRatio = float64(usage.UsedChunkBytes) / float64(usage.ChunkBytes)
+	}
+	return layout, nil
+}
+
+// helper walking a column's chunk chain defensively, problems are recorded rather than fatal
+func inspectColumn(r region, col *Column) ColumnLayout {
+	meta := col.meta
+	cl := ColumnLayout{
+		Name:         meta.name.String(),
+		Offset:       meta.offset,
+		VectorLength: meta.vectorLength,
+		NumVectors:   meta.numVectors,
+		FirstChunk:   meta.firstChunkOffset,
+		Chunks:       []ChunkLayout{},
+	}
+	if schema, ok := col.storedSchema(); ok {
+		cl.Schema = &schema
+	}
+
+	entrySize := 8 + (meta.vectorLength * 4)
+	seen := map[int64]bool{}
+	total := int64(0)
+	for chunk := meta.firstChunkOffset; chunk != 0; {
+		if chunk < DataRegionStart || chunk+ChunkSize > r.size() {
+			cl.Errors = append(cl.Errors, fmt.Sprintf("chunk offset %d outside the data region", chunk))
+			break
+		}
+		if seen[chunk] {
+			cl.Errors = append(cl.Errors, fmt.Sprintf("chunk chain loops back to %d", chunk))
+			break
+		}
+		seen[chunk] = true
+
+		header := r.chunkHeader(chunk)
+		used := header.numVectors * entrySize
+		cc := ChunkLayout{
+			Offset:     chunk,
+			Next:       header.nextChunk,
+			NumVectors: header.numVectors,
+			Location:   header.location.String(),
+			UsedBytes:  ChunkHeaderSize + used,
+			FillRatio:  float64(ChunkHeaderSize+used) / ChunkSize,
+		}
+		if used > ChunkSize-ChunkHeaderSize {
+			cl.Errors = append(cl.Errors, fmt.Sprintf("chunk %d claims %d vectors, more than fit", chunk, header.numVectors))
+		} else if header.location == Local && header.numVectors > 0 {
+			if data, err := r.read(chunk+ChunkHeaderSize, used); err != nil {
+				cl.Errors = append(cl.Errors, fmt.Sprintf("chunk %d cannot be read: %v", chunk, err))
+			} else {
+				cc.MinTimestamp, cc.MaxTimestamp = ^uint64(0), 0
+				for i := int64(0); i < header.numVectors; i++ {
+					ts := ByteOrder.Uint64(data[i*entrySize:])
+					cc.MinTimestamp, cc.MaxTimestamp = min(cc.MinTimestamp, ts), max(cc.MaxTimestamp, ts)
+				}
+			}
+		}
+		total += header.numVectors
+		cl.Chunks = append(cl.Chunks, cc)
+		chunk = header.nextChunk
+	}
+	if total != meta.numVectors {
+		cl.Errors = append(cl.Errors, fmt.Sprintf("chunks hold %d vectors, metadata says %d", total, meta.numVectors))
+	}
+	return cl
+}
+
+// WriteText prints the layout in a human readable form
+func (layout *Layout) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "file\t%s (%d bytes)\n", layout.Path, layout.FileSize)
+	fmt.Fprintf(tw, "metadata cursor\t%d\n", layout.MetadataCursor)
+	fmt.Fprintf(tw, "data cursor\t%d\n", layout.DataCursor)
+	for k, v := range layout.Properties {
+		fmt.Fprintf(tw, "property\t%s = %s\n", k, v)
+	}
+	for _, tbl := range layout.Tables {
+		fmt.Fprintf(tw, "\ntable %q\toffset %d, %d/%d column slots used\n", tbl.Name, tbl.Offset, len(tbl.Columns), tbl.ColumnSlots)
+		for k, v := range tbl.Properties {
+			fmt.Fprintf(tw, "  property\t%s = %s\n", k, v)
+		}
+		for _, col := range tbl.Columns {
+			fmt.Fprintf(tw, "  column %q\toffset %d, vector length %d, %d vectors, first chunk %d\n",
+				col.Name, col.Offset, col.VectorLength, col.NumVectors, col.FirstChunk)
+			if col.Schema != nil {
+				fmt.Fprintf(tw, "    schema\trevision %d, element %s, metric %s, normalized %t, unit %s, model %q\n",
+					col.Schema.Revision, col.Schema.Element, col.Schema.Metric, col.Schema.Normalized, col.Schema.TimestampUnit, col.Schema.ModelID)
+			}
+			for _, chunk := range col.Chunks {
+				fmt.Fprintf(tw, "    chunk %d\t-> %d\t%d vectors\t%s\t%.1f%% full\tts [%d, %d]\n",
+					chunk.Offset, chunk.Next, chunk.NumVectors, chunk.Location, chunk.FillRatio*100, chunk.MinTimestamp, chunk.MaxTimestamp)
+			}
+			for _, e := range col.Errors {
+				fmt.Fprintf(tw, "    ERROR\t%s\n", e)
+			}
+		}
+	}
+	u := layout.Usage
+	fmt.Fprintf(tw, "\nusage\tcatalog %d bytes, extensions %d bytes\n", u.CatalogBytes, u.ExtensionBytes)
+	fmt.Fprintf(tw, "\t%d chunks (%d offloaded), %d of %d chunk bytes used (%.1f%%)\n",
+		u.Chunks, u.OffloadedChunks, u.UsedChunkBytes, u.ChunkBytes, u.FillRatio*100)
+	return tw.Flush()
+}
+
+// readOnlyStorage adapts an io.ReaderAt so the catalog readers can run over it
+type readOnlyStorage struct {
+	ra   io.ReaderAt
+	size int64
+}
+
+func (s *readOnlyStorage) ReadAt(p []byte, off int64) (int, error) {
+	return s.ra.ReadAt(p, off)
+}
+
+func (s *readOnlyStorage) WriteAt(p []byte, off int64) (int, error) {
+	return 0, fmt.Errorf("storage is read only")
+}
+
+func (s *readOnlyStorage) Size() int64 {
+	return s.size
+}
+
+func (s *readOnlyStorage) Grow(additionalBytes int64) error {
+	return fmt.Errorf("storage is read only")
+}
+
+func (s *readOnlyStorage) Flush() error {
+	return nil
+}
+
+func (s *readOnlyStorage) Close() error {
+	return nil
+}
@@ -0,0 +1,261 @@
+package db
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"math/rand"
+	"slices"
+)
+
+/*
+IVF-Flat partitions a column with k-means and keeps one inverted list of entry
+indexes per centroid. Only the centroids and the lists live in memory, the
+vectors themselves are read from the column when a list is scanned.
+
+Blob layout (little endian):
+	magic       "IVFF0001"
+	metric      uint8
+	seed        int64
+	dim         uint32
+	numLists    uint32
+	count       uint64
+	centroids   numLists*dim float32
+	per list:   uint32 length, then the entry indexes as uint32
+*/
+
+var ivfMagic = []byte("IVFF0001")
+
+// IVFParams configures an IVF-Flat index
+// Zero fields fall back to sqrt(n) lists, a sample of 256 vectors per list, 20 iterations
+// and the column schema's metric
+type IVFParams struct {
+	Lists      int    // number of k-means centroids and inverted lists
+	SampleSize int    // vectors k-means is trained on, at least Lists
+	Iterations int    // k-means iterations
+	Metric     Metric // metric vectors are assigned and searched with
+	Seed       int64  // seeds sampling and centroid initialization
+}
+
+type ivf struct {
+	params    IVFParams
+	metric    Metric
+	dim       int
+	centroids [][]float32
+	lists     [][]uint32
+	n         int64
+}
+
+func (index *ivf) kind() IndexKind { return IVFFlatIndex }
+
+func (index *ivf) count() int64 { return index.n }
+
+func (index *ivf) add(src *vectorSource, idx int64) error {
+	if idx != index.n {
+		return fmt.Errorf("ivf index has %d entries, cannot add entry %d", index.n, idx)
+	}
+	if idx >= math.MaxUint32 {
+		return fmt.Errorf("ivf index is full")
+	}
+	vec, err := src.vector(idx)
+	if err != nil {
+		return err
+	}
+	list := nearestCentroid(index.centroids, vec, index.metric)
+	index.lists[list] = append(index.lists[list], uint32(idx))
+	index.n++
+	return nil
+}
+
+// train runs k-means over a random sample of src to place the centroids
+func (index *ivf) train(src *vectorSource) error {
+	n := src.len()
+	params := &index.params
+	if params.Lists <= 0 {
+		params.Lists = max(1, int(math.Sqrt(float64(n))))
+	}
+	if params.SampleSize <= 0 {
+		params.SampleSize = params.Lists * 256
+	}
+	if params.Iterations <= 0 {
+		params.Iterations = 20
+	}
+	if n < int64(params.Lists) {
+		return fmt.Errorf("need at least %d vectors to train %d lists, column has %d", params.Lists, params.Lists, n)
+	}
+	// k-means starts every centroid on a distinct sample vector
+	if params.SampleSize < params.Lists {
+		return fmt.Errorf("sample size %d is smaller than the %d lists it trains", params.SampleSize, params.Lists)
+	}
+
+	rng := rand.New(rand.NewSource(params.Seed))
+	sampleIdx := rng.Perm(int(n))[:min(int64(params.SampleSize), n)]
+	sample := make([][]float32, len(sampleIdx))
+	for i, idx := range sampleIdx {
+		vec, err := src.vector(int64(idx))
+		if err != nil {
+			return err
+		}
+		sample[i] = index.trainingVector(vec)
+		src.yield()
+	}
+
+	index.centroids = kmeans(sample, params.Lists, params.Iterations, index.metric, rng)
+	index.lists = make([][]uint32, params.Lists)
+	return nil
+}
+
+// trainingVector copies vec, normalized for cosine so k-means clusters by angle
+func (index *ivf) trainingVector(vec []float32) []float32 {
+	vec = slices.Clone(vec)
+	if index.metric == Cosine {
+		normalize(vec)
+	}
+	return vec
+}
+
+// search scans the nprobe lists closest to query
+func (index *ivf) search(src *vectorSource, query []float32, k int, nprobe int, allow func(int64) bool) ([]SearchResult, error) {
+	metric := index.metric
+	order := make([]int, len(index.centroids))
+	scores := make([]float32, len(index.centroids))
+	for i, c := range index.centroids {
+		order[i], scores[i] = i, metric.Score(query, c)
+	}
+	slices.SortFunc(order, func(a, b int) int {
+		switch {
+		case metric.Better(scores[a], scores[b]):
+			return -1
+		case metric.Better(scores[b], scores[a]):
+			return 1
+		default:
+			return a - b
+		}
+	})
+
+	// a selective filter can leave the closest lists short of k, so probing goes on until k are found
+	k = int(min(int64(k), src.len()))
+	results := newResultHeap(k, metric)
+	for probed, list := range order {
+		if probed >= max(nprobe, 1) && results.Len() >= k {
+			break
+		}
+		for _, id := range index.lists[list] {
+			idx := int64(id)
+			if allow != nil && !allow(idx) {
+				continue
+			}
+			entry, err := src.entry(idx)
+			if err != nil {
+				return nil, err
+			}
+			results.offer(SearchResult{
+				Index:     idx,
+				Timestamp: ByteOrder.Uint64(entry),
+				Score:     metric.Score(readVec(entry[8:], src.vecLen), query),
+			})
+		}
+	}
+	return results.sorted(), nil
+}
+
+func (index *ivf) encode() []byte {
+	var buf bytes.Buffer
+	put := func(v uint64, size int) {
+		var b [8]byte
+		ByteOrder.PutUint64(b[:], v)
+		buf.Write(b[:size])
+	}
+	buf.Write(ivfMagic)
+	buf.WriteByte(byte(index.metric))
+	put(uint64(index.params.Seed), 8)
+	put(uint64(index.dim), 4)
+	put(uint64(len(index.centroids)), 4)
+	put(uint64(index.n), 8)
+	for _, c := range index.centroids {
+		for _, v := range c {
+			put(uint64(math.Float32bits(v)), 4)
+		}
+	}
+	for _, list := range index.lists {
+		put(uint64(len(list)), 4)
+		for _, id := range list {
+			put(uint64(id), 4)
+		}
+	}
+	return buf.Bytes()
+}
+
+func decodeIVF(b []byte) (*ivf, error) {
+	if len(b) < 33 || !bytes.Equal(b[:8], ivfMagic) {
+		return nil, fmt.Errorf("not an ivf index")
+	}
+	index := &ivf{
+		params: IVFParams{Seed: int64(ByteOrder.Uint64(b[9:]))},
+		metric: Metric(b[8]),
+		dim:    int(ByteOrder.Uint32(b[17:])),
+		n:      int64(ByteOrder.Uint64(b[25:])),
+	}
+	if err := index.metric.validate(); err != nil {
+		return nil, err
+	}
+	numLists := int64(ByteOrder.Uint32(b[21:]))
+	index.params.Lists = int(numLists)
+	p := b[33:]
+	truncated := fmt.Errorf("ivf index truncated")
+	if int64(len(p)) < numLists*int64(index.dim)*4 {
+		return nil, truncated
+	}
+	index.centroids = make([][]float32, numLists)
+	for i := range index.centroids {
+		c := make([]float32, index.dim)
+		for j := range c {
+			c[j] = math.Float32frombits(ByteOrder.Uint32(p))
+			p = p[4:]
+		}
+		index.centroids[i] = c
+	}
+	index.lists = make([][]uint32, numLists)
+	total := int64(0)
+	for i := range index.lists {
+		if len(p) < 4 {
+			return nil, truncated
+		}
+		length := int64(ByteOrder.Uint32(p))
+		p = p[4:]
+		if int64(len(p)) < length*4 {
+			return nil, truncated
+		}
+		list := make([]uint32, length)
+		for j := range list {
+			list[j] = ByteOrder.Uint32(p[j*4:])
+			if int64(list[j]) >= index.n {
+				return nil, fmt.Errorf("ivf entry %d out of range", list[j])
+			}
+		}
+		p = p[length*4:]
+		index.lists[i] = list
+		total += length
+	}
+	if total != index.n {
+		return nil, fmt.Errorf("ivf lists hold %d entries, want %d", total, index.n)
+	}
+	return index, nil
+}
+
+// BuildIVF trains an IVF-Flat index on a sample of the column and persists it, replacing any existing one
+// Vectors appended later are assigned to their nearest centroid, rebuild to retrain
+func (column *Column) BuildIVF(params IVFParams) error {
+	metric, err := column.metricOr(params.Metric)
+	if err != nil {
+		return err
+	}
+	index := &ivf{params: params, metric: metric, dim: int(column.metadata().vectorLength)}
+	return column.buildIndex(index, index.train)
+}
+
+// SearchIVF returns the k entries closest to query using the column's IVF index, best first
+// nprobe is how many of the closest lists are scanned, larger values trade speed for recall
+func (column *Column) SearchIVF(query []float32, k int, nprobe int) ([]SearchResult, error) {
+	return column.SearchIVFFiltered(query, k, nprobe, nil)
+}
@@ -0,0 +1,31 @@
+package db
+
+import "testing"
+
+func TestBuildIVFRejectsSmallSample(t *testing.T) {
+	conn := openTestDB(t)
+	col := newTestColumn(t, conn, "c", 4)
+	appendTestVectors(t, col, 500)
+
+	if err := col.BuildIVF(IVFParams{Lists: 100, SampleSize: 10, Metric: L2}); err == nil {
+		t.Fatal("built 100 lists from a sample of 10")
+	}
+	// the default of 22 lists is trained on the sample size given
+	if err := col.BuildIVF(IVFParams{SampleSize: 10, Metric: L2}); err == nil {
+		t.Fatal("built the default lists from a sample of 10")
+	}
+	if err := col.BuildIVF(IVFParams{Lists: 600, Metric: L2}); err == nil {
+		t.Fatal("built 600 lists over 500 entries")
+	}
+
+	if err := col.BuildIVF(IVFParams{Lists: 10, SampleSize: 10, Metric: L2, Seed: 1}); err != nil {
+		t.Fatal(err)
+	}
+	results, err := col.SearchIVF([]float32{42, 42, 42, 42}, 1, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Index != 42 {
+		t.Fatalf("search returned %v, want entry 42", results)
+	}
+}
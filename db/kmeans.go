@@ -0,0 +1,75 @@
+package db
+
+import (
+	"math/rand"
+	"slices"
+
+	"github.com/viterin/vek/vek32"
+)
+
+// kmeans runs Lloyd's algorithm over sample and returns k centroids
+// Vectors join the centroid that ranks best under metric, and for cosine the
+// centroids are renormalized so clusters form by angle. Binary vectors under
+// Hamming get majority bit centroids. Needs len(sample) >= k
+func kmeans(sample [][]float32, k int, iterations int, metric Metric, rng *rand.Rand) [][]float32 {
+	dim := len(sample[0])
+	// centroids start on distinct sample vectors
+	centroids := make([][]float32, k)
+	for i, j := range rng.Perm(len(sample))[:k] {
+		centroids[i] = slices.Clone(sample[j])
+	}
+	assignment := make([]int, len(sample))
+	for i := range assignment {
+		assignment[i] = -1
+	}
+	for range iterations {
+		changed := false
+		for i, vec := range sample {
+			if c := nearestCentroid(centroids, vec, metric); c != assignment[i] {
+				assignment[i], changed = c, true
+			}
+		}
+		if !changed {
+			break
+		}
+		builders := make([]*centroidBuilder, k)
+		for i := range builders {
+			builders[i] = newCentroidBuilder(metric, dim)
+		}
+		for i, vec := range sample {
+			builders[assignment[i]].add(vec)
+		}
+		for i, builder := range builders {
+			centroid := builder.centroid()
+			if centroid == nil {
+				// an empty cluster is reseeded on a random sample vector
+				centroids[i] = slices.Clone(sample[rng.Intn(len(sample))])
+				continue
+			}
+			if metric == Cosine {
+				normalize(centroid)
+			}
+			centroids[i] = centroid
+		}
+	}
+	return centroids
+}
+
+// nearestCentroid returns the centroid that ranks best against vec under metric
+func nearestCentroid(centroids [][]float32, vec []float32, metric Metric) int {
+	best, bestScore := 0, float32(0)
+	for i, c := range centroids {
+		score := metric.Score(vec, c)
+		if i == 0 || metric.Better(score, bestScore) {
+			best, bestScore = i, score
+		}
+	}
+	return best
+}
+
+// normalize scales vec to unit length in place, zero vectors are left alone
+func normalize(vec []float32) {
+	if norm := vek32.Norm(vec); norm > 0 {
+		vek32.DivNumber_Inplace(vec, norm)
+	}
+}
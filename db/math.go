@@ -1,12 +1,14 @@
 package db
 
 import (
+	"math"
+
 	"github.com/viterin/vek/vek32"
 )
 
 // Returns the element-wise sum of all vectors in the set
-func (c *Column) Sum(varName string, pool VariablePool) []float32 {
-	return c.reduce(
+func (c *Column) Sum(varName string, pool VariablePool) ([]float32, error) {
+	vec, err := c.reduce(
 		varName,
 		pool,
 		func(v1, v2 Vector) Vector {
@@ -15,12 +17,13 @@ func (c *Column) Sum(varName string, pool VariablePool) []float32 {
 				features:  vek32.Add(v1.features, v2.features),
 			}
 		},
-	).features
+	)
+	return vec.features, err
 }
 
 // Returns the element-wise product of all vectors in the set
-func (c *Column) Prod(varName string, pool VariablePool) []float32 {
-	return c.reduce(
+func (c *Column) Prod(varName string, pool VariablePool) ([]float32, error) {
+	vec, err := c.reduce(
 		varName,
 		pool,
 		func(v1, v2 Vector) Vector {
@@ -29,40 +32,67 @@ func (c *Column) Prod(varName string, pool VariablePool) []float32 {
 				features:  vek32.Mul(v1.features, v2.features),
 			}
 		},
-	).features
+	)
+	return vec.features, err
 }
 
-// Returns the average distance from the set of vectors to the target
-func (c *Column) DistAvg(varName string, pool VariablePool, target []float32) float32 {
+// Returns the score of the set's centroid against the target under the column's metric
+// For cosine and dot product this is a similarity, higher meaning closer
+func (c *Column) DistAvg(varName string, pool VariablePool, target []float32) (float32, error) {
+	metric := c.Schema().Metric
+	if metric == Hamming {
+		// a majority bit centroid hides how far the set spreads, so binary
+		// vectors average their bit distances instead
+		sum, n := 0, 0
+		err := c.visit(varName, pool, func(vec Vector) {
+			sum += hamming(vec.features, target)
+			n++
+		})
+		if err != nil {
+			return 0, err
+		}
+		if n == 0 {
+			return float32(math.NaN()), nil
+		}
+		return float32(sum) / float32(n), nil
+	}
 	// first compute general average - then compute similarity
 	// centroid distance is preffered to average of distances in the case of vector similarity
-	avg := c.avg(varName, pool)
-	return vek32.CosineSimilarity(avg, target)
+	avg, err := c.avg(varName, pool, metric)
+	if err != nil {
+		return 0, err
+	}
+	if avg == nil {
+		return float32(math.NaN()), nil
+	}
+	return metric.Score(avg, target), nil
 }
 
-// Returns the vector from the set with the minimum euclidean distance from the target
+// Returns the vector from the set closest to the target under the column's metric
 // Return value has {features []float32, timestamp int64}
-func (c *Column) DistMin(varName string, pool VariablePool, target []float32) Vector {
+func (c *Column) DistMin(varName string, pool VariablePool, target []float32) (Vector, error) {
+	metric := c.Schema().Metric
 	return c.reduce(
 		varName,
 		pool,
 		func(v1, v2 Vector) Vector {
-			if vek32.CosineSimilarity(v1.features, target) > vek32.CosineSimilarity(v2.features, target) {
-				return v2
+			if metric.Better(metric.Score(v1.features, target), metric.Score(v2.features, target)) {
+				return v1
 			}
-			return v1
+			return v2
 		},
 	)
 }
 
-// Returns the vector from the set with the maximum euclidean distance from the target
+// Returns the vector from the set farthest from the target under the column's metric
 // Return value has {features []float32, timestamp int64}
-func (c *Column) DistMax(varName string, pool VariablePool, target []float32) Vector {
+func (c *Column) DistMax(varName string, pool VariablePool, target []float32) (Vector, error) {
+	metric := c.Schema().Metric
 	return c.reduce(
 		varName,
 		pool,
 		func(v1, v2 Vector) Vector {
-			if vek32.CosineSimilarity(v1.features, target) > vek32.CosineSimilarity(v2.features, target) {
+			if metric.Better(metric.Score(v2.features, target), metric.Score(v1.features, target)) {
 				return v1
 			}
 			return v2
@@ -70,18 +100,15 @@ func (c *Column) DistMax(varName string, pool VariablePool, target []float32) Ve
 	)
 }
 
-// Helper for computing the element-wise average of a set of variables
+// Helper for computing the centroid of a set of variables, nil for an empty set
 // TODO: implement a running average (nice to have)
-func (c *Column) avg(varName string, pool VariablePool) []float32 {
-	sum := c.reduce(
-		varName,
-		pool,
-		func(v1, v2 Vector) Vector {
-			return Vector{
-				timestamp: 0,
-				features:  vek32.Add(v1.features, v2.features),
-			}
-		},
-	)
-	return vek32.DivNumber(sum.features, float32(len(sum.features)))
+func (c *Column) avg(varName string, pool VariablePool, metric Metric) ([]float32, error) {
+	builder := newCentroidBuilder(metric, int(c.metadata().vectorLength))
+	err := c.visit(varName, pool, func(vec Vector) {
+		builder.add(vec.features)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return builder.centroid(), nil
 }
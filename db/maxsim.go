@@ -0,0 +1,108 @@
+package db
+
+import (
+	"fmt"
+	"slices"
+)
+
+// MaxSimOptions configures a multi-vector query
+// Zero fields fall back to k 10, single entry windows and a stride of one window
+type MaxSimOptions struct {
+	K      int   // windows returned
+	Window int64 // consecutive entries scored together
+	Stride int64 // entries between the starts of consecutive windows
+}
+
+// MaxSim ranks windows of the column against a set of query vectors by late interaction:
+// every query vector is matched with its best entry in the window and the matches are summed
+// Scores use the column schema's metric, so with L2 lower is better
+func (column *Column) MaxSim(queries [][]float32, opts MaxSimOptions) ([]RankedWindow, error) {
+	if len(queries) == 0 {
+		return nil, fmt.Errorf("maxsim needs at least one query vector")
+	}
+	for _, q := range queries {
+		if err := column.ValidateQuery(q); err != nil {
+			return nil, err
+		}
+	}
+	if opts.K <= 0 {
+		opts.K = 10
+	}
+	opts.Window = max(opts.Window, 1)
+	if opts.Stride <= 0 {
+		opts.Stride = opts.Window
+	}
+	metric := column.Schema().Metric
+
+	// every query vector keeps the best of its scores over the trailing window,
+	// and a window is summed up as its last entry goes by
+	bests := make([]slidingBest, len(queries))
+	starts := []uint64{} // timestamps of the window starts still open, oldest first
+	results := []RankedWindow{}
+	emit := func(start, end int64, startTs, endTs uint64) {
+		w := RankedWindow{StartIndex: start, EndIndex: end, StartTimestamp: startTs, EndTimestamp: endTs}
+		for i := range bests {
+			w.Score += bests[i].best()
+		}
+		// results stay sorted and no longer than k
+		at, _ := slices.BinarySearchFunc(results, w, func(a, b RankedWindow) int {
+			if metric.Better(a.Score, b.Score) || !metric.Better(b.Score, a.Score) {
+				return -1
+			}
+			return 1
+		})
+		if at < opts.K {
+			results = slices.Insert(results, at, w)
+			results = results[:min(len(results), opts.K)]
+		}
+	}
+	n, firstTs, lastTs := int64(0), uint64(0), uint64(0)
+	err := column.forEach(func(_ int64, ts uint64, vec []float32) bool {
+		if n == 0 {
+			firstTs = ts
+		}
+		if n%opts.Stride == 0 {
+			starts = append(starts, ts)
+		}
+		for i, q := range queries {
+			bests[i].push(n, metric.Score(vec, q), opts.Window, metric)
+		}
+		if start := n - opts.Window + 1; start >= 0 && start%opts.Stride == 0 {
+			emit(start, n, starts[0], ts)
+			starts = starts[1:]
+		}
+		n, lastTs = n+1, ts
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if n > 0 && n < opts.Window {
+		// a column shorter than the window is scored as one window
+		emit(0, n-1, firstTs, lastTs)
+	}
+	return results, nil
+}
+
+// slidingBest is a monotonic deque holding the best score of the last window entries pushed
+// Each entry is pushed and dropped once, so a pass over a column stays linear in its length
+type slidingBest struct {
+	idx    []int64
+	scores []float32
+}
+
+// push adds the score of entry i and drops what fell out of the window ending at it
+func (d *slidingBest) push(i int64, score float32, window int64, metric Metric) {
+	for len(d.idx) > 0 && !metric.Better(d.scores[len(d.scores)-1], score) {
+		d.idx, d.scores = d.idx[:len(d.idx)-1], d.scores[:len(d.scores)-1]
+	}
+	d.idx, d.scores = append(d.idx, i), append(d.scores, score)
+	if d.idx[0] <= i-window {
+		d.idx, d.scores = d.idx[1:], d.scores[1:]
+	}
+}
+
+// best is the best score in the window, at least one entry has to have been pushed
+func (d *slidingBest) best() float32 {
+	return d.scores[0]
+}
@@ -0,0 +1,145 @@
+package db
+
+import (
+	"math"
+	"slices"
+	"testing"
+)
+
+// slidingBest pushed a row gives the best score of every window over it
+func TestSlidingBest(t *testing.T) {
+	row := []float32{3, 1, 4, 1, 5, 9, 2, 6}
+	cases := []struct {
+		name   string
+		window int64
+		metric Metric
+		want   []float32
+	}{
+		{"single entries", 1, L2, row},
+		{"lowest under L2", 3, L2, []float32{1, 1, 1, 1, 2, 2}},
+		{"highest under DotProduct", 3, DotProduct, []float32{4, 4, 5, 9, 9, 9}},
+		{"whole row", 8, L2, []float32{1}},
+		{"wider than the row", 9, L2, []float32{}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := []float32{}
+			var d slidingBest
+			for i, score := range row {
+				d.push(int64(i), score, c.window, c.metric)
+				if int64(i) >= c.window-1 {
+					got = append(got, d.best())
+				}
+			}
+			if !slices.Equal(got, c.want) {
+				t.Fatalf("got %v, want %v", got, c.want)
+			}
+		})
+	}
+}
+
+// newMaxSimColumn holds entry i at (i, 0) with timestamp 100+i, so its L2 distance to (q, 0) is |i-q|
+func newMaxSimColumn(t *testing.T, n int) *Column {
+	t.Helper()
+	conn := openTestDB(t)
+	tbl, err := conn.AddTable("t", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	col, err := tbl.AddColumnWithSchema("c", 2, Schema{Metric: L2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range n {
+		opts := WriteColumnOptions{Kind: Floats}
+		if err := opts.AddFloats([]float32{float32(i), 0}); err != nil {
+			t.Fatal(err)
+		}
+		if err := col.AddVector(int64(100+i), opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return col
+}
+
+// nearScore allows for the rounding of the vectorised distances
+func nearScore(got, want float32) bool {
+	return math.Abs(float64(got-want)) < 1e-4
+}
+
+func TestMaxSimDefaults(t *testing.T) {
+	col := newMaxSimColumn(t, 30)
+	queries := [][]float32{{7, 0}, {9, 0}}
+
+	// k 10 single entry windows, each one scored |i-7| + |i-9|
+	windows, err := col.MaxSim(queries, MaxSimOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(windows) != 10 {
+		t.Fatalf("got %d windows, want the default 10", len(windows))
+	}
+	if w := windows[0]; w.StartIndex != 7 || w.EndIndex != 7 || !nearScore(w.Score, 2) || w.StartTimestamp != 107 {
+		t.Fatalf("best window is %+v", w)
+	}
+
+	// the stride falls back to the window, so windows start at multiples of 4 only
+	windows, err = col.MaxSim(queries, MaxSimOptions{K: 100, Window: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(windows) != 7 {
+		t.Fatalf("got %d windows of 4 over 30 entries, want 7", len(windows))
+	}
+	for _, w := range windows {
+		if w.StartIndex%4 != 0 || w.EndIndex != w.StartIndex+3 || w.EndTimestamp != uint64(100+w.EndIndex) {
+			t.Fatalf("window %+v is not on the default stride", w)
+		}
+	}
+	if w := windows[0]; w.StartIndex != 8 || !nearScore(w.Score, 1) {
+		t.Fatalf("best window is %+v, want [8, 11] matching 7 at 8 and 9 at 9", w)
+	}
+
+	// a window wider than the column scores the column as one window
+	windows, err = col.MaxSim(queries, MaxSimOptions{Window: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(windows) != 1 || windows[0].StartIndex != 0 || windows[0].EndIndex != 29 || !nearScore(windows[0].Score, 0) ||
+		windows[0].StartTimestamp != 100 || windows[0].EndTimestamp != 129 {
+		t.Fatalf("windows are %+v", windows)
+	}
+}
+
+// Overlapping windows stream to the same scores as summing each query's best by hand
+func TestMaxSimOverlappingWindows(t *testing.T) {
+	col := newMaxSimColumn(t, 40)
+	queries := [][]float32{{3, 0}, {20, 0}, {21.5, 0}}
+	window, stride := int64(6), int64(2)
+	windows, err := col.MaxSim(queries, MaxSimOptions{K: 100, Window: window, Stride: stride})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(windows) != 18 {
+		t.Fatalf("got %d windows, want 18", len(windows))
+	}
+	for i, w := range windows {
+		want := float32(0)
+		for _, q := range queries {
+			best := float32(-1)
+			for e := w.StartIndex; e <= w.EndIndex; e++ {
+				d := L2.Score([]float32{float32(e), 0}, q)
+				if best < 0 || d < best {
+					best = d
+				}
+			}
+			want += best
+		}
+		if w.Score != want {
+			t.Fatalf("window %+v scored %v, want %v", w, w.Score, want)
+		}
+		if i > 0 && L2.Better(w.Score, windows[i-1].Score) {
+			t.Fatalf("window %d is better than the one before it", i)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package db
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"math/bits"
+	"strings"
+
+	"github.com/viterin/vek/vek32"
+)
+
+// Score computes the metric between a and b using the vek32 SIMD kernels
+// Cosine and DotProduct are similarities, the rest are distances
+// Hamming reads the float32 words as packed bits
+func (m Metric) Score(a, b []float32) float32 {
+	switch m {
+	case DotProduct:
+		return vek32.Dot(a, b)
+	case L2:
+		return vek32.Distance(a, b)
+	case L1:
+		return vek32.ManhattanDistance(a, b)
+	case Hamming:
+		return float32(hamming(a, b))
+	default:
+		return vek32.CosineSimilarity(a, b)
+	}
+}
+
+// HigherIsBetter reports whether larger scores mean closer vectors
+func (m Metric) HigherIsBetter() bool {
+	return m == Cosine || m == DotProduct
+}
+
+// Better reports whether score a ranks ahead of score b
+// NaN scores, such as cosine against a zero vector, rank behind everything
+func (m Metric) Better(a, b float32) bool {
+	if a != a || b != b {
+		return b != b && a == a
+	}
+	if m.HigherIsBetter() {
+		return a > b
+	}
+	return a < b
+}
+
+func (m Metric) validate() error {
+	if m > Hamming {
+		return fmt.Errorf("unknown metric %d", m)
+	}
+	return nil
+}
+
+// ParseMetric maps a metric name as printed by Metric.String back to the metric
+func ParseMetric(name string) (Metric, error) {
+	for m := Cosine; m <= Hamming; m++ {
+		if strings.EqualFold(name, m.String()) {
+			return m, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown metric %q", name)
+}
+
+// metricOr returns m, or the schema's metric for MetricDefault, checking that the
+// column's vectors can be scored by it
+func (column *Column) metricOr(m Metric) (Metric, error) {
+	schema := column.Schema()
+	if m == MetricDefault {
+		return schema.Metric, nil
+	}
+	if err := schema.checkMetric(m); err != nil {
+		slog.Error("Metric does not fit the column", "column", column.metadata().name.String(), "metric", m, "error", err)
+		return 0, err
+	}
+	return m, nil
+}
+
+// hamming counts the bits that differ between two packed binary vectors
+func hamming(a, b []float32) int {
+	count := 0
+	for i := range a {
+		count += bits.OnesCount32(math.Float32bits(a[i]) ^ math.Float32bits(b[i]))
+	}
+	return count
+}
+
+// centroidBuilder averages vectors into the point that represents them under a metric
+// Binary vectors under Hamming take the majority of every bit, since their mean is not a bit pattern
+type centroidBuilder struct {
+	metric Metric
+	sum    []float32
+	bits   []int // set bit counts, Hamming only
+	n      int
+}
+
+func newCentroidBuilder(metric Metric, dim int) *centroidBuilder {
+	if metric == Hamming {
+		return &centroidBuilder{metric: metric, bits: make([]int, dim*32)}
+	}
+	return &centroidBuilder{metric: metric, sum: make([]float32, dim)}
+}
+
+func (c *centroidBuilder) add(vec []float32) {
+	c.n++
+	if c.metric != Hamming {
+		vek32.Add_Inplace(c.sum, vec)
+		return
+	}
+	for i, v := range vec {
+		word := math.Float32bits(v)
+		for b := range 32 {
+			c.bits[i*32+b] += int(word >> b & 1)
+		}
+	}
+}
+
+// centroid returns the average of the vectors added so far, nil if there were none
+func (c *centroidBuilder) centroid() []float32 {
+	if c.n == 0 {
+		return nil
+	}
+	if c.metric != Hamming {
+		return vek32.DivNumber(c.sum, float32(c.n))
+	}
+	vec := make([]float32, len(c.bits)/32)
+	for i := range vec {
+		word := uint32(0)
+		for b := range 32 {
+			if c.bits[i*32+b]*2 > c.n {
+				word |= 1 << b
+			}
+		}
+		vec[i] = math.Float32frombits(word)
+	}
+	return vec
+}
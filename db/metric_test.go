@@ -0,0 +1,109 @@
+package db
+
+import "testing"
+
+// An explicit cosine metric is kept on a column whose schema ranks by another one
+func TestBuildHonorsExplicitCosine(t *testing.T) {
+	conn := openTestDB(t)
+	tbl, err := conn.AddTable("t", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	col, err := tbl.AddColumnWithSchema("c", 4, Schema{Metric: L2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendTestVectors(t, col, 300)
+
+	builds := map[IndexKind]func() error{
+		HNSWIndex:    func() error { return col.BuildHNSW(HNSWParams{Metric: Cosine, Seed: 1}) },
+		IVFFlatIndex: func() error { return col.BuildIVF(IVFParams{Metric: Cosine, Seed: 1}) },
+		DiskANNIndex: func() error { return col.BuildDiskANN(DiskANNParams{Metric: Cosine, Seed: 1}) },
+	}
+	for kind, build := range builds {
+		if err := build(); err != nil {
+			t.Fatal(err)
+		}
+		err := col.withIndex(kind, func(src *vectorSource, index vectorIndex) error {
+			var metric Metric
+			switch index := index.(type) {
+			case *hnsw:
+				metric = index.metric
+			case *ivf:
+				metric = index.metric
+			case *vamana:
+				metric = index.metric
+			}
+			if metric != Cosine {
+				t.Errorf("index %d was built with metric %v, want cosine", kind, metric)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// left unset the metric still follows the schema
+	if err := col.BuildHNSW(HNSWParams{Seed: 1}); err != nil {
+		t.Fatal(err)
+	}
+	err = col.withIndex(HNSWIndex, func(src *vectorSource, index vectorIndex) error {
+		if metric := index.(*hnsw).metric; metric != L2 {
+			t.Errorf("index was built with metric %v, want the schema's L2", metric)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Metrics are checked against the schema, and MetricDefault follows it
+func TestQueryMetricMustFitSchema(t *testing.T) {
+	conn := openTestDB(t)
+	tbl, err := conn.AddTable("t", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	col, err := tbl.AddColumnWithSchema("c", 4, Schema{Metric: L2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendTestVectors(t, col, 20)
+	query := []float32{3, 3, 3, 3}
+
+	if _, err := col.TopK(query, 1, Hamming); err == nil {
+		t.Error("TopK scored float vectors by hamming distance")
+	}
+	if _, err := col.RangeSearch(query, 1, Hamming); err == nil {
+		t.Error("RangeSearch scored float vectors by hamming distance")
+	}
+	if err := col.WithinDistance(query, 1, Hamming, "near", VariablePool{}); err == nil {
+		t.Error("WithinDistance scored float vectors by hamming distance")
+	}
+	if err := col.BuildHNSW(HNSWParams{Metric: Hamming}); err == nil {
+		t.Error("BuildHNSW built a hamming graph over float vectors")
+	}
+	if _, err := col.TopK(query, 1, Metric(200)); err == nil {
+		t.Error("TopK accepted an unknown metric")
+	}
+
+	// the schema's L2 ranks entry 3 first, cosine would tie every entry
+	results, err := col.TopK(query, 1, MetricDefault)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Index != 3 || results[0].Score != 0 {
+		t.Fatalf("default metric results are %v, want entry 3 at distance 0", results)
+	}
+
+	// a schema left at the zero metric reads back as cosine
+	plain, err := tbl.AddColumnWithSchema("plain", 4, Schema{ModelID: "m"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metric := plain.Schema().Metric; metric != Cosine {
+		t.Fatalf("zero schema metric reads back as %s, want cosine", metric)
+	}
+}
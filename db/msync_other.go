@@ -0,0 +1,10 @@
+//go:build !unix
+
+package db
+
+import "fmt"
+
+// msync is unsupported here, callers fall back to flushing the whole mapping
+func msync(b []byte) error {
+	return fmt.Errorf("range msync unsupported on this platform")
+}
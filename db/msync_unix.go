@@ -0,0 +1,10 @@
+//go:build unix
+
+package db
+
+import "golang.org/x/sys/unix"
+
+// msync synchronously writes back the pages of b, which must start on a page boundary
+func msync(b []byte) error {
+	return unix.Msync(b, unix.MS_SYNC)
+}
@@ -0,0 +1,635 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strings"
+	"time"
+)
+
+/*
+Statements are planned per column against a frozen view. The WHERE clause becomes
+a tree of operators producing bitmaps, topped by one operator producing rows:
+	all            every entry, for a missing WHERE
+	full-scan      time predicate checked on every entry
+	zone-scan      time predicate checked only in chunks the zone maps cannot decide
+	distance-scan  distance predicate, every entry is scored
+	and, or, not   bitmap set operations
+	fetch          entries in column order, stopping at the limit
+	sort           entries by timestamp
+	score-sort     every admitted entry scored and sorted
+	topk           exact best first search over the admitted entries
+	*-probe        filtered search through an hnsw, ivf-flat or diskann index
+	aggregate      SUM, AVG, PROD and COUNT over the admitted entries
+Costs count entries touched, times the dimension wherever vectors are scored.
+Row estimates come from the zone maps for time predicates, fixed selectivities for
+distance predicates and independence for and, or and not
+*/
+
+// search settings for index probes chosen by the planner
+const (
+	plannerEf     = 64
+	plannerNProbe = 8
+	plannerL      = 64
+)
+
+// distanceSelectivity is the fraction of entries a distance inequality is assumed to admit
+const distanceSelectivity = 1.0 / 3
+
+// PlanNode is one operator of an executed plan, Inputs are the operators it read from
+type PlanNode struct {
+	Operator      string        `json:"operator"`
+	Detail        string        `json:"detail,omitempty"`
+	Cost          float64       `json:"cost"`
+	EstimatedRows int64         `json:"estimated_rows"`
+	ActualRows    int64         `json:"actual_rows"`
+	Time          time.Duration `json:"time_ns"` // includes the time spent in its inputs
+	Inputs        []*PlanNode   `json:"inputs,omitempty"`
+}
+
+// Plan is the operator tree a statement ran with
+type Plan struct {
+	Root *PlanNode     `json:"root"`
+	Time time.Duration `json:"time_ns"`
+}
+
+// WriteText prints the plan as an indented tree, inputs below the operator reading them
+func (plan *Plan) WriteText(w io.Writer) error {
+	var b strings.Builder
+	var write func(node *PlanNode, depth int)
+	write = func(node *PlanNode, depth int) {
+		fmt.Fprintf(&b, "%s%s", strings.Repeat("  ", depth), node.Operator)
+		if node.Detail != "" {
+			fmt.Fprintf(&b, " %s", node.Detail)
+		}
+		fmt.Fprintf(&b, "  (cost=%.0f rows=%d estimated=%d time=%s)\n", node.Cost, node.ActualRows, node.EstimatedRows, node.Time)
+		for _, input := range node.Inputs {
+			write(input, depth+1)
+		}
+	}
+	write(plan.Root, 0)
+	fmt.Fprintf(&b, "total time %s\n", plan.Time)
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// measure runs fn and records its time and the rows it produced on the node
+func (node *PlanNode) measure(fn func() (int64, error)) error {
+	start := time.Now()
+	rows, err := fn()
+	node.Time, node.ActualRows = time.Since(start), rows
+	return err
+}
+
+// planner plans one statement against one frozen column
+type planner struct {
+	ctx    context.Context
+	column *Column
+	name   string
+	params queryParams
+	n      int64
+	dim    int
+	zones  []zoneMap
+}
+
+func (column *Column) newPlanner(ctx context.Context, params queryParams) (*planner, error) {
+	view := column.view()
+	zones, err := column.zoneMaps()
+	if err != nil {
+		return nil, err
+	}
+	return &planner{
+		ctx:    ctx,
+		column: column,
+		name:   view.meta.name.String(),
+		params: params,
+		n:      view.meta.numVectors,
+		dim:    int(view.meta.vectorLength),
+		zones:  zones,
+	}, nil
+}
+
+// bitmapStep is a planned WHERE operator
+type bitmapStep struct {
+	node *PlanNode
+	run  func() (*Bitmap, error)
+}
+
+// rowsStep is a planned operator producing the column's rows in statement order
+type rowsStep struct {
+	node *PlanNode
+	run  func() ([]sqlRow, error)
+}
+
+// step wraps run so it checks for cancellation and records its time and output size
+func (p *planner) step(node *PlanNode, run func() (*Bitmap, error)) *bitmapStep {
+	return &bitmapStep{node: node, run: func() (*Bitmap, error) {
+		var bitmap *Bitmap
+		err := node.measure(func() (int64, error) {
+			if err := p.ctx.Err(); err != nil {
+				return 0, err
+			}
+			var err error
+			if bitmap, err = run(); err != nil {
+				return 0, err
+			}
+			return bitmap.Cardinality(), nil
+		})
+		return bitmap, err
+	}}
+}
+
+func (p *planner) rowsStep(node *PlanNode, run func() ([]sqlRow, error)) *rowsStep {
+	return &rowsStep{node: node, run: func() ([]sqlRow, error) {
+		var rows []sqlRow
+		err := node.measure(func() (int64, error) {
+			var err error
+			rows, err = run()
+			return int64(len(rows)), err
+		})
+		return rows, err
+	}}
+}
+
+// condition plans a WHERE clause, nil admits every entry
+func (p *planner) condition(cond Condition) (*bitmapStep, error) {
+	switch c := cond.(type) {
+	case nil:
+		node := &PlanNode{Operator: "all", EstimatedRows: p.n}
+		return p.step(node, func() (*Bitmap, error) { return NewBitmap().Not(p.n), nil }), nil
+	case AndCondition:
+		return p.combine("and", c.Left, c.Right)
+	case OrCondition:
+		return p.combine("or", c.Left, c.Right)
+	case NotCondition:
+		operand, err := p.condition(c.Operand)
+		if err != nil {
+			return nil, err
+		}
+		node := &PlanNode{
+			Operator:      "not",
+			Cost:          operand.node.Cost,
+			EstimatedRows: p.n - operand.node.EstimatedRows,
+			Inputs:        []*PlanNode{operand.node},
+		}
+		return p.step(node, func() (*Bitmap, error) {
+			bitmap, err := operand.run()
+			if err != nil {
+				return nil, err
+			}
+			return bitmap.Not(p.n), nil
+		}), nil
+	case TimeCondition:
+		return p.timeCondition(c)
+	case DistanceCondition:
+		return p.distanceCondition(c)
+	default:
+		return nil, fmt.Errorf("unsupported condition %s", cond)
+	}
+}
+
+// combine plans an and or an or of two conditions, their sides are assumed independent
+func (p *planner) combine(operator string, left, right Condition) (*bitmapStep, error) {
+	l, err := p.condition(left)
+	if err != nil {
+		return nil, err
+	}
+	r, err := p.condition(right)
+	if err != nil {
+		return nil, err
+	}
+	both := int64(float64(l.node.EstimatedRows) * float64(r.node.EstimatedRows) / float64(max(p.n, 1)))
+	node := &PlanNode{
+		Operator:      operator,
+		Cost:          l.node.Cost + r.node.Cost,
+		EstimatedRows: both,
+		Inputs:        []*PlanNode{l.node, r.node},
+	}
+	if operator == "or" {
+		node.EstimatedRows = l.node.EstimatedRows + r.node.EstimatedRows - both
+	}
+	return p.step(node, func() (*Bitmap, error) {
+		lb, err := l.run()
+		if err != nil {
+			return nil, err
+		}
+		rb, err := r.run()
+		if err != nil {
+			return nil, err
+		}
+		if operator == "or" {
+			return lb.Or(rb), nil
+		}
+		return lb.And(rb), nil
+	}), nil
+}
+
+// timeCondition picks between checking every timestamp and checking only the chunks
+// whose zone maps straddle the range
+func (p *planner) timeCondition(c TimeCondition) (*bitmapStep, error) {
+	start, end, err := timeBounds(c, p.params)
+	if err != nil {
+		return nil, err
+	}
+	estimate, zoneCost := 0.0, 0.0
+	for _, zone := range p.zones {
+		fraction, contained := zone.overlap(uint64(start), uint64(end))
+		estimate += fraction * float64(zone.count)
+		zoneCost++ // every zone is looked at
+		if fraction > 0 && !contained {
+			zoneCost += float64(zone.count)
+		}
+	}
+	node := &PlanNode{Operator: "full-scan", Detail: c.String(), Cost: float64(p.n), EstimatedRows: int64(math.Round(estimate))}
+	run := func() (*Bitmap, error) {
+		if start >= end {
+			return NewBitmap(), nil
+		}
+		pool := VariablePool{}
+		if err := p.column.Select(start, end, "ts", pool); err != nil {
+			return nil, err
+		}
+		return pool["ts"], nil
+	}
+	if zoneCost < node.Cost {
+		node.Operator, node.Cost = "zone-scan", zoneCost
+		run = func() (*Bitmap, error) {
+			return p.column.zoneScan(p.ctx, p.zones, start, end)
+		}
+	}
+	if c.Op == "!=" {
+		node.EstimatedRows = p.n - node.EstimatedRows
+		equal := run
+		run = func() (*Bitmap, error) {
+			bitmap, err := equal()
+			if err != nil {
+				return nil, err
+			}
+			return bitmap.Not(p.n), nil
+		}
+	}
+	return p.step(node, run), nil
+}
+
+// timeBounds maps a time predicate to the half-open range [start, end) it admits, or
+// for != the range it excludes. Timestamps compare as unsigned like they do in Select,
+// so bounds below zero are clamped
+func timeBounds(c TimeCondition, params queryParams) (int64, int64, error) {
+	low, err := params.integer(c.Low)
+	if err != nil {
+		return 0, 0, err
+	}
+	start, end := int64(0), int64(math.MaxInt64)
+	switch c.Op {
+	case "BETWEEN":
+		high, err := params.integer(c.High)
+		if err != nil {
+			return 0, 0, err
+		}
+		start, end = low, saturatingInc(high)
+	case "=", "!=":
+		start, end = low, saturatingInc(low)
+	case "<":
+		end = low
+	case "<=":
+		end = saturatingInc(low)
+	case ">":
+		start = saturatingInc(low)
+	case ">=":
+		start = low
+	}
+	return max(start, 0), max(end, 0), nil
+}
+
+func saturatingInc(v int64) int64 {
+	if v == math.MaxInt64 {
+		return v
+	}
+	return v + 1
+}
+
+// zoneScan returns the entries with start <= timestamp < end, reading only the chunks
+// whose zones neither exclude nor contain the whole range
+func (column *Column) zoneScan(ctx context.Context, zones []zoneMap, start, end int64) (*Bitmap, error) {
+	bitmap := NewBitmap()
+	if start >= end {
+		return bitmap, nil
+	}
+	r, unpin := column.file.Pin()
+	defer unpin()
+	entrySize := 8 + (column.view().meta.vectorLength * 4)
+	for _, zone := range zones {
+		fraction, contained := zone.overlap(uint64(start), uint64(end))
+		switch {
+		case contained:
+			bitmap.AddRange(zone.first, zone.first+zone.count)
+		case fraction > 0:
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			data, err := r.entries(zone.chunk, r.chunkHeader(zone.chunk), zone.count*entrySize)
+			if err != nil {
+				return nil, err
+			}
+			for i := int64(0); i < zone.count; i++ {
+				if ts := ByteOrder.Uint64(data[i*entrySize:]); ts >= uint64(start) && ts < uint64(end) {
+					bitmap.Add(zone.first + i)
+				}
+			}
+		}
+	}
+	return bitmap, nil
+}
+
+// distanceCondition scores every entry, its estimate is a fixed selectivity
+func (p *planner) distanceCondition(c DistanceCondition) (*bitmapStep, error) {
+	query, err := p.params.vector(c.Query)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.column.ValidateQuery(query); err != nil {
+		return nil, err
+	}
+	if _, err := p.column.metricOr(c.Metric); err != nil {
+		return nil, err
+	}
+	threshold, err := p.params.number(c.Threshold)
+	if err != nil {
+		return nil, err
+	}
+	t := float32(threshold)
+	compare := map[string]func(s float32) bool{
+		"=":  func(s float32) bool { return s == t },
+		"!=": func(s float32) bool { return s != t },
+		"<":  func(s float32) bool { return s < t },
+		"<=": func(s float32) bool { return s <= t },
+		">":  func(s float32) bool { return s > t },
+		">=": func(s float32) bool { return s >= t },
+	}[c.Op]
+	estimate := int64(math.Round(float64(p.n) * distanceSelectivity))
+	switch c.Op {
+	case "=":
+		estimate = min(p.n, 1)
+	case "!=":
+		estimate = p.n
+	}
+	node := &PlanNode{Operator: "distance-scan", Detail: c.String(), Cost: float64(p.n) * float64(p.dim), EstimatedRows: estimate}
+	return p.step(node, func() (*Bitmap, error) {
+		bitmap := NewBitmap()
+		var err error
+		scanErr := p.column.forEach(func(idx int64, ts uint64, vec []float32) bool {
+			if idx%cancelCheckInterval == 0 {
+				if err = p.ctx.Err(); err != nil {
+					return false
+				}
+			}
+			// NaN scores fail every comparison but !=
+			if compare(c.Metric.Score(vec, query)) {
+				bitmap.Add(idx)
+			}
+			return true
+		})
+		if scanErr != nil {
+			return nil, scanErr
+		}
+		return bitmap, err
+	}), nil
+}
+
+// rows plans the operator producing the statement's rows from the filter's entries
+func (p *planner) rows(stmt *Statement, filter *bitmapStep, withVec bool) (*rowsStep, error) {
+	admitted := max(filter.node.EstimatedRows, 0)
+	limited := admitted
+	if stmt.Limit > 0 {
+		limited = min(admitted, int64(stmt.Limit))
+	}
+	order := stmt.Order
+	if order == nil || order.Timestamp {
+		node := &PlanNode{Operator: "fetch", Detail: p.name, Cost: filter.node.Cost + float64(limited), EstimatedRows: limited, Inputs: []*PlanNode{filter.node}}
+		if order != nil {
+			node.Operator, node.Detail = "sort", fmt.Sprintf("%s %s", p.name, order)
+			node.Cost = filter.node.Cost + sortCost(admitted)
+		}
+		return p.rowsStep(node, func() ([]sqlRow, error) {
+			bitmap, err := filter.run()
+			if err != nil {
+				return nil, err
+			}
+			// without an order the first rows in column order are the answer
+			stop := 0
+			if order == nil {
+				stop = stmt.Limit
+			}
+			rows, err := p.collect(bitmap, withVec, nil, stop)
+			if err != nil {
+				return nil, err
+			}
+			return p.finish(stmt, rows), nil
+		}), nil
+	}
+
+	query, err := p.params.vector(order.Query)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.column.ValidateQuery(query); err != nil {
+		return nil, err
+	}
+	if _, err := p.column.metricOr(order.Metric); err != nil {
+		return nil, err
+	}
+	scoreCost := float64(admitted) * float64(p.dim)
+	if !order.BestFirst() || stmt.Limit == 0 {
+		node := &PlanNode{
+			Operator:      "score-sort",
+			Detail:        fmt.Sprintf("%s %s", p.name, order),
+			Cost:          filter.node.Cost + scoreCost + sortCost(admitted),
+			EstimatedRows: limited,
+			Inputs:        []*PlanNode{filter.node},
+		}
+		return p.rowsStep(node, func() ([]sqlRow, error) {
+			bitmap, err := filter.run()
+			if err != nil {
+				return nil, err
+			}
+			score := func(vec []float32) float32 { return order.Metric.Score(vec, query) }
+			rows, err := p.collect(bitmap, withVec, score, 0)
+			if err != nil {
+				return nil, err
+			}
+			return p.finish(stmt, rows), nil
+		}), nil
+	}
+
+	// best first with a limit, the exact scan competes with every index on the column
+	k := stmt.Limit
+	best := &PlanNode{
+		Operator:      "topk",
+		Detail:        fmt.Sprintf("%s %s k=%d", p.name, order.Metric, k),
+		Cost:          filter.node.Cost + scoreCost,
+		EstimatedRows: limited,
+		Inputs:        []*PlanNode{filter.node},
+	}
+	search := func(f *Filter) ([]SearchResult, error) {
+		return p.column.TopKFiltered(query, k, order.Metric, f)
+	}
+	for _, kind := range p.column.Indexes() {
+		probe, err := p.probe(kind, query, k, order.Metric, admitted)
+		if err != nil {
+			return nil, err
+		}
+		if probe == nil || filter.node.Cost+probe.cost >= best.Cost {
+			continue
+		}
+		best = &PlanNode{
+			Operator:      kind.String() + "-probe",
+			Detail:        fmt.Sprintf("%s %s k=%d %s", p.name, order.Metric, k, probe.detail),
+			Cost:          filter.node.Cost + probe.cost,
+			EstimatedRows: limited,
+			Inputs:        []*PlanNode{filter.node},
+		}
+		search = probe.search
+	}
+	unfiltered := stmt.Where == nil
+	return p.rowsStep(best, func() ([]sqlRow, error) {
+		bitmap, err := filter.run()
+		if err != nil {
+			return nil, err
+		}
+		var f *Filter
+		if !unfiltered {
+			f = BitmapFilter(VariablePool{"where": bitmap}, "where")
+		}
+		results, err := search(f)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.ctx.Err(); err != nil {
+			return nil, err
+		}
+		rows := make([]sqlRow, len(results))
+		for i, res := range results {
+			rows[i] = sqlRow{column: p.name, idx: res.Index, ts: res.Timestamp, score: res.Score}
+		}
+		if withVec {
+			if err := p.column.fillVectors(rows); err != nil {
+				return nil, err
+			}
+		}
+		return rows, nil
+	}), nil
+}
+
+// indexProbe is a costed search through one of the column's indexes
+type indexProbe struct {
+	cost   float64
+	detail string // search settings widened for the estimated selectivity, as the filtered search widens them
+	search func(filter *Filter) ([]SearchResult, error)
+}
+
+// probe costs a filtered search through the index, nil if the index cannot serve it
+// Indexes built with another metric rank differently, and below exactFilterLimit admitted
+// entries the filtered searches fall back to an exact scan anyway
+func (p *planner) probe(kind IndexKind, query []float32, k int, metric Metric, admitted int64) (*indexProbe, error) {
+	if admitted < exactFilterLimit {
+		return nil, nil
+	}
+	var probe *indexProbe
+	err := p.column.withIndex(kind, func(src *vectorSource, index vectorIndex) error {
+		dim := float64(p.dim)
+		switch index := index.(type) {
+		case *hnsw:
+			if index.metric != metric {
+				return nil
+			}
+			// every expanded candidate compares against its layer 0 neighbors
+			ef := widen(max(plannerEf, k), p.n, admitted)
+			probe = &indexProbe{
+				cost:   float64(ef) * float64(2*index.params.M) * dim,
+				detail: fmt.Sprintf("ef=%d", ef),
+				search: func(f *Filter) ([]SearchResult, error) {
+					return p.column.SearchHNSWFiltered(query, k, plannerEf, f)
+				},
+			}
+		case *ivf:
+			if index.metric != metric {
+				return nil
+			}
+			lists := max(len(index.centroids), 1)
+			nprobe := min(widen(plannerNProbe, p.n, admitted), lists)
+			probe = &indexProbe{
+				cost:   float64(lists)*dim + float64(nprobe)*float64(p.n)/float64(lists)*dim,
+				detail: fmt.Sprintf("nprobe=%d", nprobe),
+				search: func(f *Filter) ([]SearchResult, error) {
+					return p.column.SearchIVFFiltered(query, k, plannerNProbe, f)
+				},
+			}
+		case *vamana:
+			if index.metric != metric {
+				return nil
+			}
+			// the beam is walked on quantized codes and the survivors reranked exactly
+			l := widen(max(plannerL, k), p.n, admitted)
+			probe = &indexProbe{
+				cost:   float64(l)*float64(index.params.R*index.params.Subspaces) + float64(l)*dim,
+				detail: fmt.Sprintf("l=%d", l),
+				search: func(f *Filter) ([]SearchResult, error) {
+					return p.column.SearchDiskANNFiltered(query, k, plannerL, f)
+				},
+			}
+		}
+		return nil
+	})
+	return probe, err
+}
+
+func sortCost(n int64) float64 {
+	if n < 2 {
+		return float64(n)
+	}
+	return float64(n) * math.Log2(float64(n))
+}
+
+// collect copies the entries set in bitmap in column order, scoring them when score is set
+// A positive limit ends the visit once that many rows are collected
+func (p *planner) collect(bitmap *Bitmap, withVec bool, score func(vec []float32) float32, limit int) ([]sqlRow, error) {
+	rows := []sqlRow{}
+	var err error
+	scanErr := p.column.visitBitmap(bitmap, func(idx int64, vec Vector) bool {
+		if len(rows)%cancelCheckInterval == 0 {
+			if err = p.ctx.Err(); err != nil {
+				return false
+			}
+		}
+		row := sqlRow{column: p.name, idx: idx, ts: vec.timestamp}
+		if score != nil {
+			row.score = score(vec.features)
+		}
+		if withVec {
+			row.vec = slices.Clone(vec.features) // features alias storage
+		}
+		rows = append(rows, row)
+		return limit <= 0 || len(rows) < limit
+	})
+	if scanErr != nil {
+		return nil, scanErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// finish puts collected rows in statement order and cuts them to the limit
+func (p *planner) finish(stmt *Statement, rows []sqlRow) []sqlRow {
+	if stmt.Order != nil {
+		slices.SortStableFunc(rows, stmt.Order.compare)
+	}
+	if stmt.Limit > 0 {
+		rows = rows[:min(stmt.Limit, len(rows))]
+	}
+	return rows
+}
@@ -0,0 +1,345 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestTimeBounds(t *testing.T) {
+	maxTs := fmt.Sprint(int64(math.MaxInt64))
+	cases := []struct {
+		cond       string
+		start, end int64
+	}{
+		{"ts BETWEEN 3 AND 7", 3, 8},
+		{"ts BETWEEN 7 AND 3", 7, 4},
+		{"ts BETWEEN -10 AND 3", 0, 4},
+		{"ts BETWEEN -10 AND -3", 0, 0},
+		{"ts < -5", 0, 0},
+		{"ts <= -1", 0, 0},
+		{"ts > -1", 0, math.MaxInt64},
+		{"ts >= -7", 0, math.MaxInt64},
+		{"ts = 5", 5, 6},
+		{"ts = -5", 0, 0},
+		// != maps to the range it excludes
+		{"ts != 5", 5, 6},
+		{"ts != -5", 0, 0},
+		// the largest bound saturates rather than wrapping to an empty range
+		{"ts <= " + maxTs, 0, math.MaxInt64},
+		{"ts BETWEEN 5 AND " + maxTs, 5, math.MaxInt64},
+		{"ts > " + maxTs, math.MaxInt64, math.MaxInt64},
+		{"ts BETWEEN $lo AND $hi", 2, 10},
+	}
+	params := queryParams{"lo": 2, "hi": int64(9)}
+	for _, c := range cases {
+		t.Run(c.cond, func(t *testing.T) {
+			stmt, err := ParseQuery("SELECT * FROM t WHERE " + c.cond)
+			if err != nil {
+				t.Fatal(err)
+			}
+			start, end, err := timeBounds(stmt.Where.(TimeCondition), params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if start != c.start || end != c.end {
+				t.Fatalf("bounds are [%d, %d), want [%d, %d)", start, end, c.start, c.end)
+			}
+		})
+	}
+
+	for _, cond := range []string{"ts > 1.5", "ts < 1e30", "ts = $missing", "ts = [1]"} {
+		stmt, err := ParseQuery("SELECT * FROM t WHERE " + cond)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := timeBounds(stmt.Where.(TimeCondition), params); err == nil {
+			t.Errorf("%s has bounds", cond)
+		}
+	}
+}
+
+// zoneTestTimestamp is out of order within a few entries so zones overlap their neighbours
+func zoneTestTimestamp(i int64) int64 {
+	return 2*i + 7*(i%3)
+}
+
+// newZoneTestColumn holds entries wide enough that n of them span several chunks
+func newZoneTestColumn(t *testing.T, n int64) *Column {
+	t.Helper()
+	conn := openTestDB(t)
+	tbl, err := conn.AddTable("t", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	col, err := tbl.AddColumn("c", 16384)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vec := make([]float32, 16384)
+	for i := range n {
+		opts := WriteColumnOptions{Kind: Floats}
+		if err := opts.AddFloats(vec); err != nil {
+			t.Fatal(err)
+		}
+		if err := col.AddVector(zoneTestTimestamp(i), opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return col
+}
+
+// Reading only the straddling chunks finds the same entries as checking every timestamp
+func TestZoneScanMatchesFullScan(t *testing.T) {
+	n := int64(2200)
+	col := newZoneTestColumn(t, n)
+	zones, err := col.zoneMaps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(zones) != 3 {
+		t.Fatalf("%d entries fill %d chunks, want 3", n, len(zones))
+	}
+
+	ranges := [][2]int64{
+		{0, math.MaxInt64},
+		{0, 0},
+		{9, 9},
+		{int64(zones[1].minTs), int64(zones[1].maxTs) + 1}, // exactly one zone
+		{int64(zones[1].minTs) + 1, int64(zones[1].maxTs)},
+		{int64(zones[2].maxTs), math.MaxInt64},
+		{int64(zones[2].maxTs) + 1, math.MaxInt64},
+	}
+	rng := rand.New(rand.NewSource(1))
+	for range 100 {
+		a, b := rng.Int63n(4600), rng.Int63n(4600)
+		ranges = append(ranges, [2]int64{min(a, b), max(a, b)})
+	}
+	for _, r := range ranges {
+		zoned, err := col.zoneScan(context.Background(), zones, r[0], r[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		pool := VariablePool{}
+		if err := col.Select(r[0], r[1], "ts", pool); err != nil {
+			t.Fatal(err)
+		}
+		want := []int64{}
+		for i := range n {
+			if ts := zoneTestTimestamp(i); ts >= r[0] && ts < r[1] {
+				want = append(want, i)
+			}
+		}
+		if got := bitmapIndexes(zoned); !slices.Equal(got, want) {
+			t.Fatalf("zone scan of [%d, %d) found %d entries, want %d", r[0], r[1], len(got), len(want))
+		}
+		if got := bitmapIndexes(pool["ts"]); !slices.Equal(got, want) {
+			t.Fatalf("full scan of [%d, %d) found %d entries, want %d", r[0], r[1], len(got), len(want))
+		}
+	}
+
+	// the planner picks each scan where it is cheaper, the rows agree with both
+	conn := col.conn
+	for _, c := range []struct {
+		where    string
+		operator string
+	}{
+		{"ts BETWEEN 100 AND 200", "zone-scan"},
+		{"ts != 150", "zone-scan"},
+		{"ts >= 0", "zone-scan"},
+		{"ts BETWEEN 100 AND 4100", "full-scan"},
+		{"NOT ts BETWEEN 100 AND 4100", "full-scan"},
+	} {
+		query := "SELECT idx FROM t.c WHERE " + c.where
+		plan, err := conn.Execute("EXPLAIN "+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ops := planOperators(plan.Plan.Root); !slices.Contains(ops, c.operator) {
+			t.Fatalf("%s ran with %v, want a %s", c.where, ops, c.operator)
+		}
+		rs, err := conn.Execute(query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stmt, _ := ParseQuery(query)
+		want := 0
+		for i := range n {
+			if admitsTime(t, stmt.Where, zoneTestTimestamp(i)) {
+				want++
+			}
+		}
+		if len(rs.Rows) != want {
+			t.Fatalf("%s returned %d rows, want %d", c.where, len(rs.Rows), want)
+		}
+	}
+}
+
+// planOperators lists the operators of a plan depth first
+func planOperators(node *PlanNode) []string {
+	ops := []string{node.Operator}
+	for _, input := range node.Inputs {
+		ops = append(ops, planOperators(input)...)
+	}
+	return ops
+}
+
+// admitsTime evaluates a time condition on one timestamp the long way
+func admitsTime(t *testing.T, cond Condition, ts int64) bool {
+	switch c := cond.(type) {
+	case NotCondition:
+		return !admitsTime(t, c.Operand, ts)
+	case TimeCondition:
+		start, end, err := timeBounds(c, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return (ts >= start && ts < end) != (c.Op == "!=")
+	default:
+		t.Fatalf("unexpected condition %s", cond)
+		return false
+	}
+}
+
+func TestExplainOutput(t *testing.T) {
+	conn := newSQLTestDB(t)
+	rs, err := conn.Execute("EXPLAIN SELECT idx FROM t.c WHERE ts BETWEEN 10 AND 30 ORDER BY ts DESC", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rs.Columns != nil || rs.Rows != nil || rs.Plan == nil {
+		t.Fatalf("explain returned %+v", rs)
+	}
+
+	var text bytes.Buffer
+	if err := rs.WriteText(&text); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(text.String(), "\n"), "\n")
+	want := []string{
+		`^sort c ts DESC  \(cost=\d+ rows=3 estimated=\d+ time=\S+\)$`,
+		`^  full-scan ts BETWEEN 10 AND 30  \(cost=6 rows=3 estimated=\d+ time=\S+\)$`,
+		`^total time \S+$`,
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("plan text is\n%s", text.String())
+	}
+	for i, pattern := range want {
+		if !regexp.MustCompile(pattern).MatchString(lines[i]) {
+			t.Fatalf("line %d of the plan is %q", i, lines[i])
+		}
+	}
+
+	// a bare table merges one input per column
+	rs, err = conn.Execute("EXPLAIN SELECT count(*) FROM t", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(rs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded struct {
+		Columns []string `json:"columns"`
+		Plan    struct {
+			Root struct {
+				Operator   string `json:"operator"`
+				Detail     string `json:"detail"`
+				ActualRows int64  `json:"actual_rows"`
+				Inputs     []struct {
+					Operator      string     `json:"operator"`
+					Detail        string     `json:"detail"`
+					EstimatedRows int64      `json:"estimated_rows"`
+					ActualRows    int64      `json:"actual_rows"`
+					Inputs        []PlanNode `json:"inputs"`
+				} `json:"inputs"`
+			} `json:"root"`
+			Time int64 `json:"time_ns"`
+		} `json:"plan"`
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	root := decoded.Plan.Root
+	if decoded.Columns != nil || root.Operator != "merge" || root.Detail != "t" || root.ActualRows != 2 || len(root.Inputs) != 2 {
+		t.Fatalf("plan json is %s", data)
+	}
+	for i, name := range []string{"c", "d"} {
+		input := root.Inputs[i]
+		if input.Operator != "aggregate" || input.Detail != name+" count(*)" || input.EstimatedRows != 1 || input.ActualRows != 1 ||
+			len(input.Inputs) != 1 || input.Inputs[0].Operator != "all" {
+			t.Fatalf("input %d of the plan json is %+v", i, input)
+		}
+	}
+	if decoded.Plan.Time <= 0 {
+		t.Fatalf("plan json has no time: %s", data)
+	}
+}
+
+// The probe detail shows the search settings after widening for the filter
+func TestProbeDetailShowsWidenedSettings(t *testing.T) {
+	conn := openTestDB(t)
+	tbl, err := conn.AddTable("t", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	col, err := tbl.AddColumnWithSchema("c", 4, Schema{Metric: L2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := int64(2 * exactFilterLimit)
+	rng := rand.New(rand.NewSource(1))
+	for i := range n {
+		opts := WriteColumnOptions{Kind: Floats}
+		if err := opts.AddFloats([]float32{rng.Float32(), rng.Float32(), rng.Float32(), rng.Float32()}); err != nil {
+			t.Fatal(err)
+		}
+		if err := col.AddVector(i, opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := col.BuildHNSW(HNSWParams{M: 8, EfConstruction: 32, Metric: L2, Seed: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := col.BuildIVF(IVFParams{Lists: 64, Metric: L2, Seed: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := col.BuildDiskANN(DiskANNParams{R: 8, L: 16, Subspaces: 2, Metric: L2, Seed: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := col.newPlanner(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := []float32{0.5, 0.5, 0.5, 0.5}
+	for _, c := range []struct {
+		kind     IndexKind
+		admitted int64
+		detail   string
+	}{
+		{HNSWIndex, n, fmt.Sprintf("ef=%d", plannerEf)},
+		{HNSWIndex, n / 2, fmt.Sprintf("ef=%d", 2*plannerEf)},
+		{IVFFlatIndex, n / 2, fmt.Sprintf("nprobe=%d", 2*plannerNProbe)},
+		{DiskANNIndex, n / 2, fmt.Sprintf("l=%d", 2*plannerL)},
+		{DiskANNIndex, exactFilterLimit + exactFilterLimit/2, fmt.Sprintf("l=%d", int(math.Ceil(float64(plannerL)*4/3)))},
+	} {
+		probe, err := p.probe(c.kind, query, 10, L2, c.admitted)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if probe == nil || probe.detail != c.detail {
+			t.Fatalf("%s probe over %d admitted entries is %+v, want %s", c.kind, c.admitted, probe, c.detail)
+		}
+	}
+	if probe, err := p.probe(HNSWIndex, query, 10, L2, exactFilterLimit-1); err != nil || probe != nil {
+		t.Fatalf("probe below the exact filter limit is %+v, %v", probe, err)
+	}
+}
@@ -0,0 +1,100 @@
+package db
+
+import (
+	"math/rand"
+
+	"github.com/viterin/vek/vek32"
+)
+
+// productQuantizer compresses vectors to one byte per subspace
+// Each subspace has its own k-means codebook of up to 256 centroids, trained with L2
+type productQuantizer struct {
+	dim       int
+	ksub      int         // centroids per subspace
+	bounds    []int       // subspace i spans bounds[i]:bounds[i+1]
+	codebooks [][]float32 // per subspace, ksub centroids laid out back to back
+}
+
+// trainPQ fits codebooks for the given number of subspaces on sample
+func trainPQ(sample [][]float32, subspaces int, iterations int, rng *rand.Rand) *productQuantizer {
+	dim := len(sample[0])
+	subspaces = max(1, min(subspaces, dim))
+	pq := &productQuantizer{
+		dim:       dim,
+		ksub:      min(256, len(sample)),
+		bounds:    make([]int, subspaces+1),
+		codebooks: make([][]float32, subspaces),
+	}
+	for i := range pq.bounds {
+		pq.bounds[i] = i * dim / subspaces
+	}
+	for s := range subspaces {
+		lo, hi := pq.bounds[s], pq.bounds[s+1]
+		sub := make([][]float32, len(sample))
+		for i, vec := range sample {
+			sub[i] = vec[lo:hi]
+		}
+		codebook := make([]float32, 0, pq.ksub*(hi-lo))
+		for _, c := range kmeans(sub, pq.ksub, iterations, L2, rng) {
+			codebook = append(codebook, c...)
+		}
+		pq.codebooks[s] = codebook
+	}
+	return pq
+}
+
+func (pq *productQuantizer) subspaces() int { return len(pq.codebooks) }
+
+// centroid returns centroid c of subspace s
+func (pq *productQuantizer) centroid(s int, c int) []float32 {
+	width := pq.bounds[s+1] - pq.bounds[s]
+	return pq.codebooks[s][c*width : (c+1)*width]
+}
+
+// encode writes the code of vec to code, which must hold one byte per subspace
+func (pq *productQuantizer) encode(vec []float32, code []byte) {
+	for s := range pq.codebooks {
+		sub := vec[pq.bounds[s]:pq.bounds[s+1]]
+		best, bestDist := 0, float32(0)
+		for c := range pq.ksub {
+			dist := vek32.Distance(sub, pq.centroid(s, c))
+			if c == 0 || dist < bestDist {
+				best, bestDist = c, dist
+			}
+		}
+		code[s] = byte(best)
+	}
+}
+
+// table precomputes the distance from every query subvector to every centroid
+// For L2 the entries are squared distances, for L1 plain distances and for the
+// similarities negated inner products, so summing a code's entries orders
+// candidates closest first
+func (pq *productQuantizer) table(query []float32, metric Metric) []float32 {
+	table := make([]float32, pq.subspaces()*pq.ksub)
+	for s := range pq.codebooks {
+		sub := query[pq.bounds[s]:pq.bounds[s+1]]
+		for c := range pq.ksub {
+			centroid := pq.centroid(s, c)
+			switch metric {
+			case L2:
+				d := vek32.Distance(sub, centroid)
+				table[s*pq.ksub+c] = d * d
+			case L1:
+				table[s*pq.ksub+c] = vek32.ManhattanDistance(sub, centroid)
+			default:
+				table[s*pq.ksub+c] = -vek32.Dot(sub, centroid)
+			}
+		}
+	}
+	return table
+}
+
+// distance sums the table entries selected by code
+func (pq *productQuantizer) distance(table []float32, code []byte) float32 {
+	sum := float32(0)
+	for s, c := range code {
+		sum += table[s*pq.ksub+int(c)]
+	}
+	return sum
+}
@@ -0,0 +1,159 @@
+package db
+
+import (
+	"fmt"
+	"log/slog"
+	"maps"
+	"math"
+	"sync"
+)
+
+// propertyRecordVersion is the encoding version of property records in the catalog
+const propertyRecordVersion = 1
+
+const (
+	propertySet byte = iota
+	propertyDelete
+)
+
+// properties is a small string map persisted through the catalog extension log
+// Used for things like the source video URI, owner id or ingest job id
+type properties struct {
+	mu     sync.RWMutex
+	values map[string]string
+}
+
+func (p *properties) get(key string) (string, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	v, ok := p.values[key]
+	return v, ok
+}
+
+func (p *properties) list() map[string]string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return maps.Clone(p.values)
+}
+
+// apply updates the map and returns a function that undoes the change
+func (p *properties) apply(op byte, key string, value string) func() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.values == nil {
+		p.values = map[string]string{}
+	}
+	prev, existed := p.values[key]
+	if op == propertyDelete {
+		delete(p.values, key)
+	} else {
+		p.values[key] = value
+	}
+	return func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if existed {
+			p.values[key] = prev
+		} else {
+			delete(p.values, key)
+		}
+	}
+}
+
+// records returns one set record per property, used when compacting the log
+func (p *properties) records(owner int64) []catalogRecord {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	records := []catalogRecord{}
+	for k, v := range p.values {
+		records = append(records, propertyRecord(owner, propertySet, k, v))
+	}
+	return records
+}
+
+func propertyRecord(owner int64, op byte, key string, value string) catalogRecord {
+	payload := []byte{op}
+	payload = appendString(payload, key)
+	payload = appendString(payload, value)
+	return catalogRecord{
+		kind:    recordProperty,
+		version: propertyRecordVersion,
+		owner:   owner,
+		payload: payload,
+	}
+}
+
+func decodeProperty(rec catalogRecord) (op byte, key string, value string, err error) {
+	if rec.version != propertyRecordVersion {
+		return 0, "", "", fmt.Errorf("unsupported property version %d", rec.version)
+	}
+	if len(rec.payload) < 1 {
+		return 0, "", "", fmt.Errorf("property record too short")
+	}
+	var ok bool
+	p := rec.payload[1:]
+	if key, p, ok = readString(p); !ok {
+		return 0, "", "", fmt.Errorf("property record truncated")
+	}
+	if value, _, ok = readString(p); !ok {
+		return 0, "", "", fmt.Errorf("property record truncated")
+	}
+	return rec.payload[0], key, value, nil
+}
+
+// helper shared by the DB and Table setters
+func (conn *DB) writeProperty(owner int64, props *properties, op byte, key string, value string) error {
+	if len(key) == 0 || len(key) > math.MaxUint16 || len(value) > math.MaxUint16 {
+		slog.Error("Invalid property", "key", key, "value length", len(value))
+		return fmt.Errorf("property keys must be non-empty and keys and values under 64KB")
+	}
+	unlock := conn.file.lockWriter()
+	defer unlock()
+	r, unpin := conn.file.Pin()
+	defer unpin()
+
+	// like schemas, memory is updated first so a compaction keeps the new value
+	undo := props.apply(op, key, value)
+	if err := conn.appendRecord(r, propertyRecord(owner, op, key, value)); err != nil {
+		undo()
+		return err
+	}
+	return conn.file.commit()
+}
+
+// the DB's own properties are owned by offset 0, no table or column lives there
+const dbPropertyOwner = 0
+
+func (conn *DB) GetProperty(key string) (string, bool) {
+	return conn.props.get(key)
+}
+
+func (conn *DB) SetProperty(key string, value string) error {
+	return conn.writeProperty(dbPropertyOwner, &conn.props, propertySet, key, value)
+}
+
+func (conn *DB) DeleteProperty(key string) error {
+	return conn.writeProperty(dbPropertyOwner, &conn.props, propertyDelete, key, "")
+}
+
+// ListProperties returns a copy of every DB level property
+func (conn *DB) ListProperties() map[string]string {
+	return conn.props.list()
+}
+
+func (tbl *Table) GetProperty(key string) (string, bool) {
+	return tbl.props.get(key)
+}
+
+func (tbl *Table) SetProperty(key string, value string) error {
+	return tbl.conn.writeProperty(tbl.meta.offset, &tbl.props, propertySet, key, value)
+}
+
+func (tbl *Table) DeleteProperty(key string) error {
+	return tbl.conn.writeProperty(tbl.meta.offset, &tbl.props, propertyDelete, key, "")
+}
+
+// ListProperties returns a copy of every property set on the table
+func (tbl *Table) ListProperties() map[string]string {
+	return tbl.props.list()
+}
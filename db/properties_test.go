@@ -0,0 +1,108 @@
+package db
+
+import (
+	"maps"
+	"path/filepath"
+	"testing"
+)
+
+// checkProperties compares what the DB and its tables list against want, keyed by table name
+// with "" for the DB itself
+func checkProperties(t *testing.T, conn *DB, want map[string]map[string]string) {
+	t.Helper()
+	for name, props := range want {
+		got := conn.ListProperties()
+		if name != "" {
+			tbl, ok := conn.GetTableByName(name)
+			if !ok {
+				t.Fatalf("table %s is missing", name)
+			}
+			got = tbl.ListProperties()
+		}
+		if !maps.Equal(got, props) {
+			t.Fatalf("properties of %q are %v, want %v", name, got, props)
+		}
+	}
+}
+
+// Sets, overwrites and deletes on the DB and its tables replay after a reopen,
+// and again once a compaction has rewritten the log they were recorded in
+func TestPropertiesSurviveReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.ken")
+	conn := openTestFile(t, path)
+	first, err := conn.AddTable("first", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := conn.AddTable("second", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := first.AddColumn("filler", 4); err != nil {
+		t.Fatal(err)
+	}
+	for _, step := range []struct {
+		set    func(key, value string) error
+		delete func(key string) error
+		key    string
+		value  string // empty deletes the key
+	}{
+		{conn.SetProperty, conn.DeleteProperty, "source", "s3://clips/a.mp4"},
+		{conn.SetProperty, conn.DeleteProperty, "job", "1"},
+		{conn.SetProperty, conn.DeleteProperty, "job", "2"},
+		{conn.SetProperty, conn.DeleteProperty, "scratch", "x"},
+		{conn.SetProperty, conn.DeleteProperty, "scratch", ""},
+		{first.SetProperty, first.DeleteProperty, "owner", "ingest"},
+		{first.SetProperty, first.DeleteProperty, "stale", "y"},
+		{first.SetProperty, first.DeleteProperty, "stale", ""},
+		{second.SetProperty, second.DeleteProperty, "owner", "review"},
+	} {
+		if step.value == "" {
+			err = step.delete(step.key)
+		} else {
+			err = step.set(step.key, step.value)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := map[string]map[string]string{
+		"":       {"source": "s3://clips/a.mp4", "job": "2"},
+		"first":  {"owner": "ingest"},
+		"second": {"owner": "review"},
+	}
+	if err := conn.Close(); err != nil {
+		t.Fatal(err)
+	}
+	conn = openTestFile(t, path)
+	checkProperties(t, conn, want)
+
+	r, unpin := conn.file.Pin()
+	before, _ := extensionSlot(r.extensionCursor())
+	unpin()
+	first, _ = conn.GetTableByName("first")
+	filler, _ := first.GetColumnByName("filler")
+	fillExtensionSlot(t, filler)
+	r, unpin = conn.file.Pin()
+	after, _ := extensionSlot(r.extensionCursor())
+	unpin()
+	if after == before {
+		t.Fatal("the catalog log was not compacted")
+	}
+	second, _ = conn.GetTableByName("second")
+	if err := second.SetProperty("note", "after compaction"); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.DeleteProperty("source"); err != nil {
+		t.Fatal(err)
+	}
+	want[""] = map[string]string{"job": "2"}
+	want["second"]["note"] = "after compaction"
+	if err := conn.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	conn = openTestFile(t, path)
+	defer conn.Close()
+	checkProperties(t, conn, want)
+}
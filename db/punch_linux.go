@@ -0,0 +1,16 @@
+package db
+
+import (
+	"os"
+	"syscall"
+)
+
+const (
+	fallocKeepSize  = 0x01 // FALLOC_FL_KEEP_SIZE
+	fallocPunchHole = 0x02 // FALLOC_FL_PUNCH_HOLE
+)
+
+// punchHole deallocates the range so it reads back as zeros without using disk
+func punchHole(f *os.File, off int64, n int64) error {
+	return syscall.Fallocate(int(f.Fd()), fallocPunchHole|fallocKeepSize, off, n)
+}
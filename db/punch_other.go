@@ -0,0 +1,10 @@
+//go:build !linux
+
+package db
+
+import "os"
+
+// punchHole is a no-op where fallocate is unavailable, the bytes stay on disk
+func punchHole(f *os.File, off int64, n int64) error {
+	return nil
+}
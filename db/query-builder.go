@@ -0,0 +1,205 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// cancelCheckInterval is how many entries a scan visits between context checks
+const cancelCheckInterval = 1024
+
+// Query starts a SCAN query against col, chain the fluent methods and finish with Run
+//
+//	db.Query(col).Between(a, b).Near(q).Metric(db.Cosine).Limit(k).Run(ctx)
+//
+// Without Near entries come back in column order, with it best match first
+func Query(col *Column) QueryBuilder {
+	return QueryBuilder{col: col, kind: SCAN}
+}
+
+// Between keeps entries with startTs <= timestamp < endTs, the same bounds as Select
+func (q QueryBuilder) Between(startTs, endTs int64) QueryBuilder {
+	q.ranged, q.startTs, q.endTs = true, startTs, endTs
+	return q
+}
+
+// Near sets the target vectors, SCAN and IKEJI take one and MAXSIM one or more
+func (q QueryBuilder) Near(targets ...[]float32) QueryBuilder {
+	q.targets = targets
+	return q
+}
+
+// Metric sets how a SCAN target is scored, the column schema's metric otherwise
+// IKEJI and MAXSIM always score by the schema's metric
+func (q QueryBuilder) Metric(metric Metric) QueryBuilder {
+	q.metric = metric
+	return q
+}
+
+// Limit caps the entries of a SCAN query, or the windows of IKEJI and MAXSIM
+// queries in place of their options' K
+func (q QueryBuilder) Limit(k int) QueryBuilder {
+	q.limit = k
+	return q
+}
+
+// Ikeji turns the query into an IKEJI query with the given options
+func (q QueryBuilder) Ikeji(opts IkejiOptions) QueryBuilder {
+	q.kind = IKEJI
+	return q.WithIkeji(opts)
+}
+
+// MaxSim turns the query into a MAXSIM query with the given options
+func (q QueryBuilder) MaxSim(opts MaxSimOptions) QueryBuilder {
+	q.kind = MAXSIM
+	return q.WithMaxSim(opts)
+}
+
+// Run executes the query against a frozen view of the column
+// ctx is checked between stages and while scanning, a cancelled query returns ctx.Err()
+func (q QueryBuilder) Run(ctx context.Context) (*QueryResult, error) {
+	if err := q.validate(); err != nil {
+		slog.Error("Invalid query", "query type", q.kind, "error", err)
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	col := q.col.Snapshot()
+	switch q.kind {
+	case SCAN:
+		return col.runScan(ctx, q)
+	case IKEJI:
+		opts := q.ikeji
+		if q.limit > 0 {
+			opts.K = q.limit
+		}
+		pool := VariablePool{}
+		windows, err := col.IkejiWindows(q.targets[0], opts, "ikeji", pool)
+		if err != nil {
+			return nil, err
+		}
+		entries, err := col.collectEntries(ctx, pool["ikeji"], 0)
+		if err != nil {
+			return nil, err
+		}
+		return &QueryResult{Entries: entries, Windows: windows}, nil
+	case MAXSIM:
+		opts := q.maxsim
+		if q.limit > 0 {
+			opts.K = q.limit
+		}
+		windows, err := col.MaxSim(q.targets, opts)
+		if err != nil {
+			return nil, err
+		}
+		return &QueryResult{Windows: windows}, ctx.Err()
+	default:
+		return nil, fmt.Errorf("query type %s is not implemented", q.kind)
+	}
+}
+
+// validate rejects builder settings the query kind cannot honour
+func (q QueryBuilder) validate() error {
+	if q.col == nil {
+		return fmt.Errorf("query has no column")
+	}
+	if q.limit < 0 {
+		return fmt.Errorf("limit must not be negative, got %d", q.limit)
+	}
+	if q.kind != SCAN {
+		if q.ranged {
+			return fmt.Errorf("Between only applies to SCAN queries")
+		}
+		if q.metric != MetricDefault && q.metric != q.col.Schema().Metric {
+			return fmt.Errorf("%s queries score by the schema metric %s, not %s", q.kind, q.col.Schema().Metric, q.metric)
+		}
+	}
+	switch {
+	case q.kind == MAXSIM && len(q.targets) == 0:
+		return fmt.Errorf("MAXSIM needs at least one target")
+	case q.kind == IKEJI && len(q.targets) != 1:
+		return fmt.Errorf("IKEJI takes one target, got %d", len(q.targets))
+	case q.kind == SCAN && len(q.targets) > 1:
+		return fmt.Errorf("SCAN takes at most one target, got %d", len(q.targets))
+	}
+	if _, err := q.col.metricOr(q.metric); err != nil {
+		return err
+	}
+	for _, target := range q.targets {
+		if err := q.col.ValidateQuery(target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runScan answers a SCAN query through the planner, col is the frozen view
+func (col *Column) runScan(ctx context.Context, q QueryBuilder) (*QueryResult, error) {
+	stmt := &Statement{Column: col.meta.name.String(), Limit: q.limit}
+	if q.ranged {
+		stmt.Where = AndCondition{
+			Left:  TimeCondition{Op: ">=", Low: Value{Number: strconv.FormatInt(q.startTs, 10)}},
+			Right: TimeCondition{Op: "<", Low: Value{Number: strconv.FormatInt(q.endTs, 10)}},
+		}
+	}
+	if len(q.targets) == 1 {
+		metric, err := col.metricOr(q.metric)
+		if err != nil {
+			return nil, err
+		}
+		stmt.Order = &Ordering{Metric: metric, Query: Value{Vector: q.targets[0]}, Desc: metric.HigherIsBetter()}
+	}
+
+	p, err := col.newPlanner(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	filter, err := p.condition(stmt.Where)
+	if err != nil {
+		return nil, err
+	}
+	step, err := p.rows(stmt, filter, true)
+	if err != nil {
+		return nil, err
+	}
+	plan := &Plan{Root: step.node}
+	start := time.Now()
+	rows, err := step.run()
+	plan.Time = time.Since(start)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]ResultEntry, len(rows))
+	for i, row := range rows {
+		entries[i] = ResultEntry{Index: row.idx, Timestamp: row.ts, Score: row.score, Embedding: row.vec}
+	}
+	return &QueryResult{Entries: entries, Plan: plan}, nil
+}
+
+// collectEntries copies the entries set in bitmap in column order, stopping after limit
+// entries when limit is positive
+func (col *Column) collectEntries(ctx context.Context, bitmap *Bitmap, limit int) ([]ResultEntry, error) {
+	entries := []ResultEntry{}
+	var err error
+	scanErr := col.visitBitmap(bitmap, func(idx int64, vec Vector) bool {
+		if len(entries)%cancelCheckInterval == 0 {
+			if err = ctx.Err(); err != nil {
+				return false
+			}
+		}
+		entries = append(entries, ResultEntry{Index: idx, Timestamp: vec.timestamp, Embedding: slices.Clone(vec.features)})
+		return limit <= 0 || len(entries) < limit
+	})
+	if scanErr != nil {
+		return nil, scanErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
@@ -0,0 +1,158 @@
+package db
+
+import (
+	"context"
+	"slices"
+	"testing"
+)
+
+// newBuilderTestColumn holds entry i at (i, 0) with timestamp 100+i under L2
+func newBuilderTestColumn(t *testing.T) *Column {
+	t.Helper()
+	conn := openTestDB(t)
+	tbl, err := conn.AddTable("t", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	col, err := tbl.AddColumnWithSchema("c", 2, Schema{Metric: L2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range int64(20) {
+		opts := WriteColumnOptions{Kind: Floats}
+		if err := opts.AddFloats([]float32{float32(i), 0}); err != nil {
+			t.Fatal(err)
+		}
+		if err := col.AddVector(100+i, opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return col
+}
+
+// entryIndexes lists the indexes of a query's entries in the order they came back
+func entryIndexes(res *QueryResult) []int64 {
+	out := []int64{}
+	for _, entry := range res.Entries {
+		out = append(out, entry.Index)
+	}
+	return out
+}
+
+func TestQueryBuilderScan(t *testing.T) {
+	col := newBuilderTestColumn(t)
+	near := []float32{7.2, 0}
+	cases := []struct {
+		name  string
+		query QueryBuilder
+		want  []int64
+	}{
+		{"range", Query(col).Between(105, 110), []int64{5, 6, 7, 8, 9}},
+		{"range with limit", Query(col).Between(105, 110).Limit(2), []int64{5, 6}},
+		{"empty range", Query(col).Between(110, 105), []int64{}},
+		{"nearest", Query(col).Near(near).Limit(3), []int64{7, 8, 6}},
+		{"nearest in range", Query(col).Near(near).Between(100, 105).Limit(2), []int64{4, 3}},
+		{"ranked range", Query(col).Near(near).Between(105, 110), []int64{7, 8, 6, 9, 5}},
+		{"other metric", Query(col).Near(near).Metric(DotProduct).Limit(2), []int64{19, 18}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			res, err := c.query.Run(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := entryIndexes(res); !slices.Equal(got, c.want) {
+				t.Fatalf("entries are %v, want %v", got, c.want)
+			}
+			for _, entry := range res.Entries {
+				if entry.Timestamp != uint64(100+entry.Index) || entry.Embedding[0] != float32(entry.Index) {
+					t.Fatalf("entry %d came back as %+v", entry.Index, entry)
+				}
+			}
+		})
+	}
+
+	res, err := Query(col).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Entries) != 20 || res.Entries[0].Score != 0 || res.Windows != nil {
+		t.Fatalf("an unranked scan returned %d entries, first %+v", len(res.Entries), res.Entries[0])
+	}
+}
+
+func TestQueryBuilderWindows(t *testing.T) {
+	col := newBuilderTestColumn(t)
+	res, err := Query(col).Ikeji(IkejiOptions{K: 3, MaxWindow: 4}).Near([]float32{5, 0}).Limit(1).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Windows) != 1 {
+		t.Fatalf("limit 1 returned windows %+v", res.Windows)
+	}
+	w := res.Windows[0]
+	want := []int64{}
+	for i := w.StartIndex; i <= w.EndIndex; i++ {
+		want = append(want, i)
+	}
+	if got := entryIndexes(res); w.StartIndex > 5 || w.EndIndex < 5 || !slices.Equal(got, want) {
+		t.Fatalf("window %+v came with entries %v", w, got)
+	}
+
+	res, err = Query(col).MaxSim(MaxSimOptions{Window: 4}).Near([]float32{2, 0}, []float32{13, 0}).Limit(2).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Windows) != 2 || res.Entries != nil {
+		t.Fatalf("maxsim returned %+v", res)
+	}
+}
+
+func TestQueryBuilderRejects(t *testing.T) {
+	col := newBuilderTestColumn(t)
+	target := []float32{1, 0}
+	for name, query := range map[string]QueryBuilder{
+		"no column":           Query(nil),
+		"negative limit":      Query(col).Limit(-1),
+		"two scan targets":    Query(col).Near(target, target),
+		"short target":        Query(col).Near([]float32{1}),
+		"unknown metric":      Query(col).Near(target).Metric(Metric(200)),
+		"ranged ikeji":        Query(col).Ikeji(IkejiOptions{}).Near(target).Between(0, 10),
+		"ikeji by cosine":     Query(col).Ikeji(IkejiOptions{}).Near(target).Metric(Cosine),
+		"ikeji without":       Query(col).Ikeji(IkejiOptions{}),
+		"maxsim without":      Query(col).MaxSim(MaxSimOptions{}),
+		"maxsim short target": Query(col).MaxSim(MaxSimOptions{}).Near(target, []float32{1}),
+	} {
+		if res, err := query.Run(context.Background()); err == nil {
+			t.Errorf("%s ran and returned %+v", name, res)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := Query(col).Run(ctx); err != context.Canceled {
+		t.Fatalf("a cancelled query returned %v", err)
+	}
+}
+
+// SCAN queries run through the planner and carry the plan they ran with
+func TestQueryBuilderRunsThroughPlanner(t *testing.T) {
+	col := newBuilderTestColumn(t)
+	res, err := Query(col).Between(105, 110).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Plan == nil || res.Plan.Root.Operator != "fetch" || len(res.Plan.Root.Inputs) != 1 || res.Plan.Root.ActualRows != 5 {
+		t.Fatalf("range plan is %+v", res.Plan)
+	}
+	res, err = Query(col).Near([]float32{7.2, 0}).Limit(3).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if op := res.Plan.Root.Operator; op != "topk" {
+		t.Fatalf("nearest plan runs %s, want topk", op)
+	}
+	if res.Entries[0].Score <= 0 || res.Entries[0].Score > res.Entries[1].Score {
+		t.Fatalf("planned entries are %+v", res.Entries)
+	}
+}
@@ -1,15 +1,67 @@
 package db
 
+import "fmt"
+
 type QueryType int
 
 const (
 	IKEJI QueryType = iota
+	MAXSIM
+	SCAN // entries in a time range, ranked against a target when one is given
 )
 
+func (k QueryType) String() string {
+	switch k {
+	case IKEJI:
+		return "IKEJI"
+	case MAXSIM:
+		return "MAXSIM"
+	case SCAN:
+		return "SCAN"
+	default:
+		return fmt.Sprintf("QueryType(%d)", int(k))
+	}
+}
+
 type QueryBuilder struct {
-	col *Column
-	// so far we only support the ikeji algorithm query
-	kind QueryType
+	col    *Column
+	kind   QueryType
+	maxsim MaxSimOptions
+	ikeji  IkejiOptions
+
+	// set by the fluent methods, see Query
+	targets [][]float32
+	ranged  bool
+	startTs int64
+	endTs   int64
+	metric  Metric
+	limit   int
+}
+
+// NewQueryBuilder starts a query of the given kind against col
+func NewQueryBuilder(col *Column, kind QueryType) QueryBuilder {
+	return QueryBuilder{col: col, kind: kind}
+}
+
+// WithMaxSim sets the window and result options of a MAXSIM query
+func (q QueryBuilder) WithMaxSim(opts MaxSimOptions) QueryBuilder {
+	q.maxsim = opts
+	return q
+}
+
+// WithIkeji sets the window count, lengths and parallelism of an IKEJI query
+func (q QueryBuilder) WithIkeji(opts IkejiOptions) QueryBuilder {
+	q.ikeji = opts
+	return q
+}
+
+// RankedWindow is one window of entries returned by a MAXSIM or IKEJI query
+type RankedWindow struct {
+	StartIndex     int64
+	EndIndex       int64 // inclusive
+	StartTimestamp uint64
+	EndTimestamp   uint64 // timestamp of the last entry in the window
+	Score          float32
 }
 
 type QueryOptions struct {
@@ -17,6 +69,7 @@ type QueryOptions struct {
 	floatarr []float32
 	single   *Vector
 	raw      *float32
+	windows  []RankedWindow
 }
 
 func (o QueryOptions) GetArrayFloat() ([]float32, bool) {
@@ -26,6 +79,14 @@ func (o QueryOptions) GetArrayFloat() ([]float32, bool) {
 	return nil, false
 }
 
+// GetRanked returns the ranked windows of a MAXSIM or IKEJI query
+func (o QueryOptions) GetRanked() ([]RankedWindow, bool) {
+	if len(o.windows) > 0 {
+		return o.windows, true
+	}
+	return nil, false
+}
+
 func (o QueryOptions) GetArrayVec() ([]Vector, bool) {
 	if len(o.vectarr) > 0 {
 		return o.vectarr, true
@@ -34,9 +95,32 @@ func (o QueryOptions) GetArrayVec() ([]Vector, bool) {
 }
 
 func (o QueryOptions) GetVector() (Vector, bool) {
-	return *o.single, o.single != nil
+	if o.single == nil {
+		return Vector{}, false
+	}
+	return *o.single, true
 }
 
 func (o QueryOptions) GetRaw() (float32, bool) {
-	return *o.raw, o.raw != nil
+	if o.raw == nil {
+		return 0, false
+	}
+	return *o.raw, true
+}
+
+// QueryResult is what QueryBuilder.Run returns
+// SCAN and IKEJI queries fill Entries, MAXSIM and IKEJI queries fill Windows and
+// SCAN queries also report the plan they ran with
+type QueryResult struct {
+	Entries []ResultEntry
+	Windows []RankedWindow
+	Plan    *Plan
+}
+
+// ResultEntry is one column entry returned by a query
+type ResultEntry struct {
+	Index     int64
+	Timestamp uint64
+	Score     float32 // metric value against the target, only set when a SCAN query has one
+	Embedding []float32
 }
@@ -1,85 +1,356 @@
 package db
 
 import (
+	"context"
+	"fmt"
 	"log/slog"
 	"math"
+	"runtime"
+	"slices"
 	"sync"
-
-	"github.com/google/uuid"
 )
 
 // This method is a general parser for all queries that have somethng to do with
 // some target vector
 func ParseTargetQuery(q QueryBuilder, target []float32) *QueryOptions {
+	if q.kind == MAXSIM {
+		return ParseMultiTargetQuery(q, [][]float32{target})
+	}
+	if err := q.col.ValidateQuery(target); err != nil {
+		return nil
+	}
 	// method gets its own local variable pool for operation
 	pool := VariablePool{}
+	// every scan in the query runs against the same frozen view, so bitmaps built
+	// by Select line up with what Fetch iterates over even while ingest continues
+	col := q.col.Snapshot()
 	switch q.kind {
 	case IKEJI:
-		varname := q.col.Ikeji(target, pool)
-		return &QueryOptions{vectarr: q.col.Fetch(varname, pool)}
+		windows, err := col.IkejiWindows(target, q.ikeji, "ikeji", pool)
+		if err != nil {
+			return nil
+		}
+		vectarr, err := col.Fetch("ikeji", pool)
+		if err != nil {
+			return nil
+		}
+		return &QueryOptions{vectarr: vectarr, windows: windows}
+	case SCAN:
+		res, err := q.Near(target).Run(context.Background())
+		if err != nil {
+			return nil
+		}
+		vectarr := make([]Vector, len(res.Entries))
+		for i, entry := range res.Entries {
+			vectarr[i] = Vector{timestamp: entry.Timestamp, features: entry.Embedding}
+		}
+		return &QueryOptions{vectarr: vectarr}
 	default:
 		slog.Error("Type not implemented", "query type", q.kind)
 		return nil
 	}
 }
 
+// ParseMultiTargetQuery runs a query that takes several target vectors, such as one
+// per phrase of a natural language question
+func ParseMultiTargetQuery(q QueryBuilder, targets [][]float32) *QueryOptions {
+	col := q.col.Snapshot()
+	switch q.kind {
+	case MAXSIM:
+		windows, err := col.MaxSim(targets, q.maxsim)
+		if err != nil {
+			slog.Error("MaxSim query failed", "error", err)
+			return nil
+		}
+		return &QueryOptions{windows: windows}
+	default:
+		slog.Error("Query type does not take multiple targets", "query type", q.kind)
+		return nil
+	}
+}
+
+// timestampRange is a window of entries [start, end) and its similarity to the target
 type timestampRange struct {
 	start int64
 	end   int64
 	score float32
 }
 
+// IkejiOptions configures the windows Ikeji returns
+// Zero fields fall back to the single best window, single entry minimum windows,
+// windows of at most ikejiMaxWindow entries and one worker per CPU
+type IkejiOptions struct {
+	K         int   // non-overlapping windows returned
+	MinWindow int64 // fewest entries in a window
+	MaxWindow int64 // most entries in a window, search time and memory grow with it
+	Workers   int   // goroutines scoring window starts
+}
+
+// ikejiMaxWindow is the longest window searched when IkejiOptions leaves it unset
+const ikejiMaxWindow = 1024
+
+// ikejiBlock is how many window starts are scored per pass, see ikejiSearch
+const ikejiBlock = 1024
+
 // Implementation of the ikeji algorithm on a column
-// Returns the variable name that the associated bitmap has been saved to
-func (col *Column) Ikeji(target []float32, pool VariablePool) string {
-	// global trackers
-	var wg sync.WaitGroup
-	bests := make([]timestampRange, col.Length())
-	col.forEach(func(idx int64, ts uint64, vec []float32) bool {
-		wg.Add(1)
-		go func(idx int64, target []float32, col *Column) {
-			defer wg.Done()
-			bests[idx] = ikejiRange(col, idx, target)
-		}(idx, target, col)
-		return true
-	})
-	wg.Wait()
-	minscore := float32(math.MaxFloat32)
-	var minval timestampRange
-	for _, r := range bests {
-		if r.score < minscore {
-			minval = r
+// Stores the entries of the best window under varName, see IkejiWindows
+func (col *Column) Ikeji(target []float32, varName string, pool VariablePool) error {
+	_, err := col.IkejiWindows(target, IkejiOptions{}, varName, pool)
+	return err
+}
+
+// IkejiWindows finds the k non-overlapping contiguous windows that score best
+// against target under the column's metric, best first, and stores the union of their
+// entries under varName. Windows are scored the way DistAvg scores a set.
+// From every start the window grows for as long as its score does not get worse,
+// then windows are taken greedily by score, skipping any that overlap one already taken
+func (col *Column) IkejiWindows(target []float32, opts IkejiOptions, varName string, pool VariablePool) ([]RankedWindow, error) {
+	if err := col.ValidateQuery(target); err != nil {
+		return nil, err
+	}
+	view := col.view()
+	picked, err := ikejiSearch(col.file, view, target, col.Schema().Metric, opts)
+	if err != nil {
+		return nil, err
+	}
+	src := pinnedSource(col.file, view)
+	defer src.release()
+	bitmap := NewBitmap()
+	windows := []RankedWindow{}
+	for _, w := range picked {
+		start, err := src.timestamp(w.start)
+		if err != nil {
+			return nil, err
+		}
+		end, err := src.timestamp(w.end - 1)
+		if err != nil {
+			return nil, err
 		}
+		bitmap.AddRange(w.start, w.end)
+		windows = append(windows, RankedWindow{
+			StartIndex:     w.start,
+			EndIndex:       w.end - 1,
+			StartTimestamp: start,
+			EndTimestamp:   end,
+			Score:          w.score,
+		})
 	}
-	col.Select(minval.start, minval.end, "final", pool)
-	return "final"
+	pool[varName] = bitmap
+	return windows, nil
 }
 
-func ikejiRange(col *Column, startidx int64, target []float32) timestampRange {
-	// define a local variable pool to use, and keep track of best
-	pool := VariablePool{}
-	currbestRange := timestampRange{}
-	currbestScore := float32(math.MaxFloat32)
-
-	col.forEach(func(idx int64, ts uint64, vec []float32) bool {
-		if idx <= startidx {
-			return true
-		}
-		// define check id, and add the selection of elements to the variable pool
-		checkId := uuid.New().String()
-		col.Select(startidx, idx, checkId, pool)
-		// once the checkId bitmap is in the variable pool, we comput average distance from the target
-		res := col.DistAvg(checkId, pool, target)
-		if res < currbestScore {
-			currbestRange = timestampRange{
-				start: startidx,
-				end:   idx,
-				score: res,
+// ikejiSearch scores window starts in parallel and picks the best disjoint windows
+// Running sums of the entries make every window's centroid an O(dim) difference.
+// Starts are scored ikejiBlock at a time, so only the sums those windows reach are
+// held, (ikejiBlock + maxWindow) rows of dim rather than the whole column
+func ikejiSearch(file *store, view columnView, target []float32, metric Metric, opts IkejiOptions) ([]timestampRange, error) {
+	n := view.meta.numVectors
+	k := max(opts.K, 1)
+	minWindow := max(opts.MinWindow, 1)
+	maxWindow := int64(ikejiMaxWindow)
+	if opts.MaxWindow > 0 {
+		maxWindow = opts.MaxWindow
+	}
+	maxWindow = min(maxWindow, n)
+	if n == 0 || minWindow > maxWindow {
+		return nil, nil
+	}
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	bits := metric == Hamming
+	point := expandVector(target, bits)
+	dim := int64(len(point))
+	// prefix holds the running sums from row base on, row i summing entries [0, i)
+	prefix := make([]float64, dim, min(ikejiBlock+maxWindow, n+1)*dim)
+	base, summed := int64(0), int64(0)
+	targetNorm := 0.0
+	for _, t := range point {
+		targetNorm += t * t
+	}
+	targetNorm = math.Sqrt(targetNorm)
+	if metric == Cosine && targetNorm == 0 {
+		slog.Error("Ikeji target has no direction to score cosine windows by")
+		return nil, fmt.Errorf("cosine needs a non-zero target")
+	}
+	// score is false for windows summing to zero, which have no cosine
+	score := func(start, end int64) (float32, bool) {
+		lo, hi := prefix[(start-base)*dim:], prefix[(end-base)*dim:]
+		length := float64(end - start)
+		acc, norm := 0.0, 0.0
+		for k, t := range point {
+			s := hi[k] - lo[k]
+			switch metric {
+			case Cosine, DotProduct:
+				acc += s * t
+				norm += s * s
+			case L2:
+				acc += (s/length - t) * (s/length - t)
+			case L1:
+				acc += math.Abs(s/length - t)
+			case Hamming:
+				// entries whose bit differs from the target's, as DistAvg averages them
+				acc += math.Abs(t*length - s)
 			}
-			// anytime if window n+1 is closer than window n, keep going, if not just stop
-			return true
 		}
-		return false
+		switch metric {
+		case Cosine:
+			if norm == 0 {
+				return 0, false
+			}
+			return float32(acc / (math.Sqrt(norm) * targetNorm)), true
+		case DotProduct:
+			return float32(acc / length), true
+		case L2:
+			return float32(math.Sqrt(acc)), true
+		case Hamming:
+			return float32(acc / length), true
+		default:
+			return float32(acc), true
+		}
+	}
+
+	// each start owns its slot, so workers write without locking
+	candidates := make([]timestampRange, n-minWindow+1)
+	for base < int64(len(candidates)) {
+		last := min(base+ikejiBlock, int64(len(candidates)))
+		// the last start of the block reads up to row last-1+maxWindow
+		var err error
+		if prefix, err = sumEntries(file, view, bits, summed, min(last-1+maxWindow, n), prefix); err != nil {
+			return nil, err
+		}
+		summed = min(last-1+maxWindow, n)
+
+		starts := make(chan int64, workers)
+		var wg sync.WaitGroup
+		for range workers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for start := range starts {
+					candidates[start] = ikejiGrow(start, n, minWindow, maxWindow, metric, score)
+				}
+			}()
+		}
+		for start := base; start < last; start++ {
+			starts <- start
+		}
+		close(starts)
+		wg.Wait()
+
+		// later starts never read the rows before theirs
+		kept := copy(prefix, prefix[(last-base)*dim:])
+		prefix, base = prefix[:kept], last
+	}
+
+	// starts whose every window sums to zero have no score
+	candidates = slices.DeleteFunc(candidates, func(c timestampRange) bool { return c.end == c.start })
+	slices.SortFunc(candidates, func(a, b timestampRange) int {
+		switch {
+		case betterWindow(a, b, metric):
+			return -1
+		case betterWindow(b, a, metric):
+			return 1
+		default:
+			return 0
+		}
 	})
-	return currbestRange
+	picked := []timestampRange{}
+	for _, c := range candidates {
+		if len(picked) == k {
+			break
+		}
+		if !slices.ContainsFunc(picked, func(p timestampRange) bool { return c.start < p.end && p.start < c.end }) {
+			picked = append(picked, c)
+		}
+	}
+	return picked, nil
+}
+
+// ikejiTolerance lets a window keep growing through entries that leave its score flat,
+// so a clip of near identical frames comes back whole rather than as its first frame
+const ikejiTolerance = 1e-6
+
+// ikejiGrow extends the window at start from minWindow entries while its score does not get worse
+// A window without a score ends the growth, and an empty window comes back if the first has none
+func ikejiGrow(start, n, minWindow, maxWindow int64, metric Metric, score func(start, end int64) (float32, bool)) timestampRange {
+	s, ok := score(start, start+minWindow)
+	if !ok {
+		return timestampRange{start: start, end: start}
+	}
+	best := timestampRange{start: start, end: start + minWindow, score: s}
+	for end := best.end + 1; end <= min(n, start+maxWindow); end++ {
+		s, ok := score(start, end)
+		if !ok || !metric.Better(s, best.score) && !(math.Abs(float64(s-best.score)) <= ikejiTolerance) {
+			break
+		}
+		best.end, best.score = end, s
+	}
+	return best
+}
+
+// betterWindow ranks by score, then earlier and shorter windows first
+func betterWindow(a, b timestampRange, metric Metric) bool {
+	if metric.Better(a.score, b.score) || metric.Better(b.score, a.score) {
+		return metric.Better(a.score, b.score)
+	}
+	if a.start != b.start {
+		return a.start < b.start
+	}
+	return a.end < b.end
+}
+
+// expandVector converts vec to float64, or to one 0/1 value per bit for packed binary vectors
+func expandVector(vec []float32, bits bool) []float64 {
+	if !bits {
+		point := make([]float64, len(vec))
+		for k, x := range vec {
+			point[k] = float64(x)
+		}
+		return point
+	}
+	point := make([]float64, len(vec)*32)
+	for k, t := range vec {
+		word := math.Float32bits(t)
+		for b := range 32 {
+			point[k*32+b] = float64(word >> b & 1)
+		}
+	}
+	return point
+}
+
+// sumEntries appends to prefix the running sums of entries [from, to), each row the
+// one before plus the entry, so prefix must end in the sum of entries [0, from)
+// With bits set the vectors are summed as one 0/1 value per bit
+// Accumulated in float64 so long columns do not lose the small differences
+func sumEntries(file *store, view columnView, bits bool, from, to int64, prefix []float64) ([]float64, error) {
+	dim := int(view.meta.vectorLength)
+	if bits {
+		dim *= 32
+	}
+	src := pinnedSource(file, view)
+	defer src.release()
+	for idx := from; idx < to; idx++ {
+		vec, err := src.vector(idx)
+		if err != nil {
+			return nil, err
+		}
+		prev := len(prefix) - dim
+		if bits {
+			for k, x := range vec {
+				word := math.Float32bits(x)
+				for b := range 32 {
+					prefix = append(prefix, prefix[prev+k*32+b]+float64(word>>b&1))
+				}
+			}
+		} else {
+			for k, x := range vec {
+				prefix = append(prefix, prefix[prev+k]+float64(x))
+			}
+		}
+		src.yield()
+	}
+	return prefix, nil
 }
@@ -0,0 +1,191 @@
+package db
+
+import (
+	"math"
+	"testing"
+)
+
+// A target of the wrong length is an error rather than a panic
+func TestIkejiWindowsRejectsBadTarget(t *testing.T) {
+	conn := openTestDB(t)
+	col := newTestColumn(t, conn, "c", 4)
+	appendTestVectors(t, col, 10)
+	for _, target := range [][]float32{make([]float32, 8), make([]float32, 2)} {
+		if _, err := col.IkejiWindows(target, IkejiOptions{}, "ikeji", VariablePool{}); err == nil {
+			t.Fatalf("target of length %d was accepted", len(target))
+		}
+	}
+}
+
+// Windows are found the same past the first block of starts the sums are streamed in
+func TestIkejiWindowsAcrossBlocks(t *testing.T) {
+	conn := openTestDB(t)
+	tbl, err := conn.AddTable("t", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	col, err := tbl.AddColumnWithSchema("c", 4, Schema{Metric: L2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, clipStart, clipEnd := 3*ikejiBlock, 2*ikejiBlock+500, 2*ikejiBlock+600
+	for i := range n {
+		value := float32(0)
+		if i >= clipStart && i < clipEnd {
+			value = 100
+		}
+		opts := WriteColumnOptions{Kind: Floats}
+		if err := opts.AddFloats([]float32{value, value, value, value}); err != nil {
+			t.Fatal(err)
+		}
+		if err := col.AddVector(int64(i), opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pool := VariablePool{}
+	windows, err := col.IkejiWindows([]float32{100, 100, 100, 100}, IkejiOptions{}, "ikeji", pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(windows) != 1 || windows[0].StartIndex != int64(clipStart) || windows[0].EndIndex != int64(clipEnd-1) {
+		t.Fatalf("windows are %+v, want [%d, %d]", windows, clipStart, clipEnd-1)
+	}
+	if windows[0].StartTimestamp != uint64(clipStart) || windows[0].EndTimestamp != uint64(clipEnd-1) {
+		t.Fatalf("window timestamps are %d to %d", windows[0].StartTimestamp, windows[0].EndTimestamp)
+	}
+	if got, _ := pool.Cardinality("ikeji"); got != int64(clipEnd-clipStart) {
+		t.Fatalf("bitmap holds %d entries, want %d", got, clipEnd-clipStart)
+	}
+}
+
+// Entries summing to zero have no cosine, so no window is made of them alone
+// and a zero target is refused rather than scoring every window NaN
+func TestIkejiCosineSkipsZeroWindows(t *testing.T) {
+	conn := openTestDB(t)
+	tbl, err := conn.AddTable("t", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	col, err := tbl.AddColumnWithSchema("c", 4, Schema{Metric: Cosine})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 20 {
+		vec := []float32{0, 0, 0, 0}
+		switch {
+		case i >= 15:
+			vec[1] = 1
+		case i >= 10:
+			vec[0] = 1
+		}
+		opts := WriteColumnOptions{Kind: Floats}
+		if err := opts.AddFloats(vec); err != nil {
+			t.Fatal(err)
+		}
+		if err := col.AddVector(int64(i), opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := col.IkejiWindows([]float32{0, 0, 0, 0}, IkejiOptions{}, "ikeji", VariablePool{}); err == nil {
+		t.Fatal("zero target was accepted under cosine")
+	}
+	windows, err := col.IkejiWindows([]float32{1, 0, 0, 0}, IkejiOptions{K: 3}, "ikeji", VariablePool{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(windows) != 2 || windows[0].StartIndex != 10 || windows[0].EndIndex != 14 ||
+		windows[1].StartIndex != 15 || windows[1].EndIndex != 19 {
+		t.Fatalf("windows are %+v, want [10, 14] then [15, 19]", windows)
+	}
+	if windows[0].Score != 1 || windows[1].Score != 0 {
+		t.Fatalf("window scores are %v and %v", windows[0].Score, windows[1].Score)
+	}
+
+	pool := VariablePool{}
+	if err := col.Ikeji([]float32{1, 0, 0, 0}, "best", pool); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := pool.Cardinality("best"); got != 5 {
+		t.Fatalf("best holds %d entries, want 5", got)
+	}
+}
+
+// Windows are taken best first, and one overlapping a window already taken is passed over
+// even when it scores better than the next disjoint one
+func TestIkejiWindowsTopKDisjoint(t *testing.T) {
+	conn := openTestDB(t)
+	tbl, err := conn.AddTable("t", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	col, err := tbl.AddColumnWithSchema("c", 2, Schema{Metric: L2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// runs of 10s, 9s and 8s in zeros, worse matches for a target of 10s the shorter they get
+	for i := range int64(100) {
+		value := float32(0)
+		switch {
+		case i >= 20 && i < 30:
+			value = 10
+		case i >= 50 && i < 55:
+			value = 9
+		case i >= 70 && i < 72:
+			value = 8
+		}
+		opts := WriteColumnOptions{Kind: Floats}
+		if err := opts.AddFloats([]float32{value, value}); err != nil {
+			t.Fatal(err)
+		}
+		if err := col.AddVector(1000+2*i, opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pool := VariablePool{}
+	windows, err := col.IkejiWindows([]float32{10, 10}, IkejiOptions{K: 3}, "ikeji", pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		start, end int64
+		score      float32
+	}{{20, 29, 0}, {50, 54, float32(math.Sqrt2)}, {70, 71, float32(2 * math.Sqrt2)}}
+	if len(windows) != len(want) {
+		t.Fatalf("windows are %+v, want %d", windows, len(want))
+	}
+	for i, w := range want {
+		got := windows[i]
+		if got.StartIndex != w.start || got.EndIndex != w.end || !nearScore(got.Score, w.score) {
+			t.Fatalf("window %d is %+v, want [%d, %d] at %v", i, got, w.start, w.end, w.score)
+		}
+		if got.StartTimestamp != uint64(1000+2*w.start) || got.EndTimestamp != uint64(1000+2*w.end) {
+			t.Fatalf("window %d spans timestamps %d to %d", i, got.StartTimestamp, got.EndTimestamp)
+		}
+	}
+	if got, _ := pool.Cardinality("ikeji"); got != 10+5+2 {
+		t.Fatalf("bitmap holds %d entries, want the 17 of the three windows", got)
+	}
+
+	// past the three runs only windows of zeros are left, which grow long and run into
+	// the ones taken, so fewer than k come back, still disjoint and in score order
+	windows, err = col.IkejiWindows([]float32{10, 10}, IkejiOptions{K: 10}, "ikeji", VariablePool{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(windows) <= 3 || len(windows) >= 10 || windows[2].StartIndex != 70 {
+		t.Fatalf("windows are %+v", windows)
+	}
+	for i, w := range windows {
+		for _, other := range windows[:i] {
+			if w.StartIndex <= other.EndIndex && other.StartIndex <= w.EndIndex {
+				t.Fatalf("window %+v overlaps %+v", w, other)
+			}
+		}
+		if i > 0 && L2.Better(w.Score, windows[i-1].Score) {
+			t.Fatalf("window %d scores better than the one before it", i)
+		}
+	}
+}
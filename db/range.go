@@ -0,0 +1,92 @@
+package db
+
+import (
+	"fmt"
+	"slices"
+)
+
+// RangeMatch is an entry within the threshold of a range search, with a copy of its vector
+type RangeMatch struct {
+	SearchResult
+	Features []float32
+}
+
+// within reports whether score is at least as close as threshold
+// Similarities must be >= threshold and distances <= threshold
+func (m Metric) within(score float32, threshold float32) bool {
+	return score == threshold || m.Better(score, threshold)
+}
+
+// WithinDistance stores a bitmap of the entries whose score against query is within threshold
+// under varName, so it composes with Select, Fetch and the reducers like any other variable
+func (column *Column) WithinDistance(query []float32, threshold float32, metric Metric, varName string, pool VariablePool) error {
+	if err := checkThreshold(threshold); err != nil {
+		return err
+	}
+	if err := column.ValidateQuery(query); err != nil {
+		return err
+	}
+	metric, err := column.metricOr(metric)
+	if err != nil {
+		return err
+	}
+	bitmap := NewBitmap()
+	err = column.forEach(func(idx int64, ts uint64, vec []float32) bool {
+		if metric.within(metric.Score(vec, query), threshold) {
+			bitmap.Add(idx)
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	pool[varName] = bitmap
+	return nil
+}
+
+// RangeSearch returns every entry within threshold of query, best first
+func (column *Column) RangeSearch(query []float32, threshold float32, metric Metric) ([]RangeMatch, error) {
+	if err := checkThreshold(threshold); err != nil {
+		return nil, err
+	}
+	if err := column.ValidateQuery(query); err != nil {
+		return nil, err
+	}
+	metric, err := column.metricOr(metric)
+	if err != nil {
+		return nil, err
+	}
+	matches := []RangeMatch{}
+	err = column.forEach(func(idx int64, ts uint64, vec []float32) bool {
+		score := metric.Score(vec, query)
+		if metric.within(score, threshold) {
+			matches = append(matches, RangeMatch{
+				SearchResult: SearchResult{Index: idx, Timestamp: ts, Score: score},
+				Features:     slices.Clone(vec), // vec aliases storage
+			})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(matches, func(a, b RangeMatch) int {
+		switch {
+		case metric.Better(a.Score, b.Score):
+			return -1
+		case metric.Better(b.Score, a.Score):
+			return 1
+		default:
+			return int(a.Index - b.Index)
+		}
+	})
+	return matches, nil
+}
+
+// helper for rejecting NaN thresholds, which would otherwise admit every entry
+func checkThreshold(threshold float32) error {
+	if threshold != threshold {
+		return fmt.Errorf("threshold is NaN")
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package db
+
+import (
+	"math"
+	"slices"
+	"testing"
+)
+
+// newRangeTestColumn holds unit and longer vectors around the x axis
+func newRangeTestColumn(t *testing.T) *Column {
+	t.Helper()
+	conn := openTestDB(t)
+	tbl, err := conn.AddTable("t", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	col, err := tbl.AddColumnWithSchema("c", 2, Schema{Metric: L2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, vec := range [][]float32{{1, 0}, {0.6, 0.8}, {0, 1}, {-1, 0}, {2, 0}} {
+		opts := WriteColumnOptions{Kind: Floats}
+		if err := opts.AddFloats(vec); err != nil {
+			t.Fatal(err)
+		}
+		if err := col.AddVector(int64(i), opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return col
+}
+
+// Similarities admit scores at or above the threshold, distances at or below it
+func TestWithinDistanceDirection(t *testing.T) {
+	col := newRangeTestColumn(t)
+	query := []float32{1, 0}
+	cases := []struct {
+		metric    Metric
+		threshold float32
+		want      []int64
+	}{
+		{Cosine, 0.5, []int64{0, 1, 4}}, // scores 1, 0.6, 0, -1, 1
+		{Cosine, -0.5, []int64{0, 1, 2, 4}},
+		{DotProduct, 1, []int64{0, 4}}, // scores 1, 0.6, 0, -1, 2, the threshold itself is in
+		{DotProduct, 2.5, []int64{}},
+		{L2, 1.2, []int64{0, 1, 4}}, // distances 0, 0.89, 1.41, 2, 1
+		{L2, 0.5, []int64{0}},
+	}
+	for _, c := range cases {
+		pool := VariablePool{}
+		if err := col.WithinDistance(query, c.threshold, c.metric, "near", pool); err != nil {
+			t.Fatal(err)
+		}
+		vecs, err := col.Fetch("near", pool)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := []int64{}
+		for _, vec := range vecs {
+			got = append(got, int64(vec.timestamp)) // timestamps are the entry indexes
+		}
+		if !slices.Equal(got, c.want) {
+			t.Errorf("%s within %v admits %v, want %v", c.metric, c.threshold, got, c.want)
+		}
+	}
+
+	// matches come back best first in the metric's own direction
+	for _, c := range []struct {
+		metric    Metric
+		threshold float32
+		want      []int64
+	}{
+		{Cosine, 0.5, []int64{0, 4, 1}},
+		{DotProduct, 0.5, []int64{4, 0, 1}},
+		{L2, 1.5, []int64{0, 1, 4, 2}},
+	} {
+		matches, err := col.RangeSearch(query, c.threshold, c.metric)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := []int64{}
+		for _, m := range matches {
+			got = append(got, m.Index)
+		}
+		if !slices.Equal(got, c.want) {
+			t.Errorf("%s range search within %v is %v, want %v", c.metric, c.threshold, got, c.want)
+		}
+	}
+
+	if err := col.WithinDistance(query, float32(math.NaN()), L2, "near", VariablePool{}); err == nil {
+		t.Error("a NaN threshold was accepted")
+	}
+}
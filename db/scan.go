@@ -1,98 +1,184 @@
 package db
 
 import (
+	"fmt"
 	"log/slog"
+	"slices"
 )
 
 // variable pool stores intermediate results during predicate evaluation
-// stored as a bit vector, where res[i] represents whether or not the vector
-// at the i'th index is included in the result
-type VariablePool = map[string][]bool
+// stored as a compressed bitmap, where idx is set if the vector at the
+// idx'th index is included in the result
+type VariablePool map[string]*Bitmap
 
 type Vector struct {
 	timestamp uint64
 	features  []float32
 }
 
-func (column *Column) Select(startTs int64, endTs int64, varName string, pool VariablePool) {
-	column.forEach(func(idx int64, ts uint64, vec []float32) bool {
+func (v Vector) Timestamp() uint64 {
+	return v.timestamp
+}
+
+func (v Vector) Features() []float32 {
+	return v.features
+}
+
+// Select stores the entries with startTs <= timestamp < endTs under varName,
+// replacing anything already stored there. Nothing is stored if the scan fails
+func (column *Column) Select(startTs int64, endTs int64, varName string, pool VariablePool) error {
+	bitmap := NewBitmap()
+	err := column.forEach(func(idx int64, ts uint64, vec []float32) bool {
 		if ts >= uint64(startTs) && ts < uint64(endTs) {
-			pool[varName] = append(pool[varName], true)
-		} else {
-			pool[varName] = append(pool[varName], false)
+			bitmap.Add(idx)
 		}
 		return true
 	})
+	if err != nil {
+		return err
+	}
+	pool[varName] = bitmap
+	return nil
 }
 
-func (column *Column) Fetch(varName string, pool VariablePool) []Vector {
+// lookup returns the bitmap stored under varName
+func (pool VariablePool) lookup(varName string) (*Bitmap, error) {
 	bitmap, ok := pool[varName]
-	retVec := []Vector{}
 	if !ok {
 		slog.Error("Could not find variable in variable pool", "variable name", varName)
-		return []Vector{}
+		return nil, fmt.Errorf("unknown variable %q", varName)
 	}
+	return bitmap, nil
+}
 
-	// note that we use a manual iteration pattern here instead of using the
-	// ForEach helper for performance optimization. We only read the relevant
-	// vector bytes into memory and ignore the rest
-	b := column.file.Bytes()
-	vectorSize := column.meta.vectorLength * 4
-	entrySize := 8 + vectorSize
+// combine stores op applied to the variables a and b under dst
+func (pool VariablePool) combine(dst, a, b string, op func(x, y *Bitmap) *Bitmap) error {
+	x, err := pool.lookup(a)
+	if err != nil {
+		return err
+	}
+	y, err := pool.lookup(b)
+	if err != nil {
+		return err
+	}
+	pool[dst] = op(x, y)
+	return nil
+}
 
-	currChunk, idx := column.meta.firstChunkOffset, 0
-	for currChunk != 0 {
-		header := ReadChunkHeader(b, currChunk)
-		for i := int64(0); i < header.numVectors; i++ {
-			if bitmap[idx] {
-				entryOffset := currChunk + ChunkHeaderSize + (i * entrySize)
-				retVec = append(retVec, Vector{
-					timestamp: ByteOrder.Uint64(b[entryOffset:]),
-					features:  readVec(b[entryOffset+8:], int(column.meta.vectorLength)),
-				})
-			}
-			idx++
-		}
-		currChunk = header.nextChunk
+// And stores the entries set in both a and b under dst
+func (pool VariablePool) And(dst, a, b string) error {
+	return pool.combine(dst, a, b, (*Bitmap).And)
+}
+
+// Or stores the entries set in either a or b under dst
+func (pool VariablePool) Or(dst, a, b string) error {
+	return pool.combine(dst, a, b, (*Bitmap).Or)
+}
+
+// AndNot stores the entries set in a but not in b under dst
+func (pool VariablePool) AndNot(dst, a, b string) error {
+	return pool.combine(dst, a, b, (*Bitmap).AndNot)
+}
+
+// Xor stores the entries set in exactly one of a and b under dst
+func (pool VariablePool) Xor(dst, a, b string) error {
+	return pool.combine(dst, a, b, (*Bitmap).Xor)
+}
+
+// Not stores the entries of column that are not set in src under dst
+func (pool VariablePool) Not(dst, src string, column *Column) error {
+	x, err := pool.lookup(src)
+	if err != nil {
+		return err
 	}
-	return retVec
+	pool[dst] = x.Not(column.view().meta.numVectors)
+	return nil
+}
+
+// Cardinality returns how many entries are set in varName
+func (pool VariablePool) Cardinality(varName string) (int64, error) {
+	bitmap, err := pool.lookup(varName)
+	if err != nil {
+		return 0, err
+	}
+	return bitmap.Cardinality(), nil
+}
+
+// Fetch returns copies of the vectors set under varName, in column order
+func (column *Column) Fetch(varName string, pool VariablePool) ([]Vector, error) {
+	retVec := []Vector{}
+	err := column.visit(varName, pool, func(vec Vector) {
+		// visited features alias the mapped file, which can move once the pin is released
+		retVec = append(retVec, Vector{timestamp: vec.timestamp, features: slices.Clone(vec.features)})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return retVec, nil
 }
 
 // Reduce a the vector by the function provided
 // Performs the fucntion iteratively across vectors represented by the corresponding bitmap
-func (column *Column) reduce(varName string, pool VariablePool, fn func(v1, v2 Vector) Vector) Vector {
-	bitmap, ok := pool[varName]
+func (column *Column) reduce(varName string, pool VariablePool, fn func(v1, v2 Vector) Vector) (Vector, error) {
 	var retVec Vector
 	first := true
-	if !ok {
-		slog.Error("Could not find variable in variable pool", "variable name", varName)
-		return retVec
+	err := column.visit(varName, pool, func(vec Vector) {
+		// visited features alias the mapped file, the result has to outlive the visit
+		vec.features = slices.Clone(vec.features)
+		if first {
+			retVec = vec
+			first = false
+		} else {
+			retVec = fn(vec, retVec)
+		}
+	})
+	if err != nil {
+		return Vector{}, err
+	}
+	return retVec, nil
+}
+
+// visit calls fn with every vector set in the bitmap, in column order
+// The features alias the mapped file and are only valid during the call
+func (column *Column) visit(varName string, pool VariablePool, fn func(vec Vector)) error {
+	bitmap, err := pool.lookup(varName)
+	if err != nil {
+		return err
 	}
+	return column.visitBitmap(bitmap, func(idx int64, vec Vector) bool {
+		fn(vec)
+		return true
+	})
+}
 
-	b := column.file.Bytes()
-	vectorSize := column.meta.vectorLength * 4
+// visitBitmap is visit with the index of every vector, stopping early if fn returns false
+func (column *Column) visitBitmap(bitmap *Bitmap, fn func(idx int64, vec Vector) bool) error {
+	// note that we use a manual iteration pattern here instead of using the
+	// ForEach helper for performance optimization. We only read the relevant
+	// vector bytes into memory and ignore the rest
+	view := column.view()
+	r, unpin := column.file.Pin()
+	defer unpin()
+	vectorSize := view.meta.vectorLength * 4
 	entrySize := 8 + vectorSize
 
-	currChunk, idx := column.meta.firstChunkOffset, 0
-	for currChunk != 0 {
-		header := ReadChunkHeader(b, currChunk)
-		for i := int64(0); i < header.numVectors; i++ {
-			if bitmap[idx] {
-				entryOffset := currChunk + ChunkHeaderSize + (i * entrySize)
+	// vectors appended after the bitmap was built have no bit, so stop past its last one
+	last := bitmap.last()
+	idx := int64(0)
+	return view.walk(r, func(currChunk int64, data []byte, count int64) bool {
+		for i := int64(0); i < count && idx <= last; i++ {
+			if bitmap.Contains(idx) {
+				entryOffset := i * entrySize
 				vec := Vector{
-					timestamp: ByteOrder.Uint64(b[entryOffset:]),
-					features:  readVec(b[entryOffset+8:], int(column.meta.vectorLength)),
+					timestamp: ByteOrder.Uint64(data[entryOffset:]),
+					features:  readVec(data[entryOffset+8:], int(view.meta.vectorLength)),
 				}
-				if first {
-					retVec = vec
-					first = false
-				} else {
-					retVec = fn(vec, retVec)
+				if !fn(idx, vec) {
+					return false
 				}
 			}
 			idx++
 		}
-		currChunk = header.nextChunk
-	}
-	return retVec
+		return idx <= last
+	})
 }
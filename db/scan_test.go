@@ -0,0 +1,109 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// Reduced vectors are copies, so they stay readable after the file is remapped by a grow
+func TestReduceOutlivesGrow(t *testing.T) {
+	conn := openTestDB(t)
+	tbl, err := conn.AddTable("t", 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	col, err := tbl.AddColumn("c", 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendTestVectors(t, col, 10)
+
+	pool := VariablePool{}
+	if err := col.Select(5, 6, "one", pool); err != nil {
+		t.Fatal(err)
+	}
+	if err := col.Select(2, 4, "two", pool); err != nil {
+		t.Fatal(err)
+	}
+	sum, err := col.Sum("one", pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prod, err := col.Prod("two", pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fetched, err := col.Fetch("one", pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// every column takes a chunk, enough of them grows and remaps the file
+	for i := 0; i < 4; i++ {
+		if _, err := tbl.AddColumn(fmt.Sprintf("grow%d", i), 4); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, c := range []struct {
+		name string
+		got  []float32
+		want float32
+	}{{"sum", sum, 5}, {"prod", prod, 6}, {"fetch", fetched[0].features, 5}} {
+		for _, x := range c.got {
+			if x != c.want {
+				t.Fatalf("%s reads %v after the grow, want every feature %v", c.name, c.got, c.want)
+			}
+		}
+	}
+}
+
+// failingStorage is an unmapped backend whose entry reads start failing once fail
+// is set, chunk headers still read
+type failingStorage struct {
+	Storage
+	fail bool
+}
+
+func (s *failingStorage) ReadAt(p []byte, off int64) (int, error) {
+	if s.fail && off >= DataRegionStart && len(p) > ChunkHeaderSize {
+		return 0, errors.New("read failed")
+	}
+	return s.Storage.ReadAt(p, off)
+}
+
+// A chunk that cannot be read fails the scan instead of cutting it short
+func TestScanReportsReadErrors(t *testing.T) {
+	backend := &failingStorage{Storage: NewMemoryStorage(InitialFileSize)}
+	conn, err := Open(backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	col := newTestColumn(t, conn, "c", 4)
+	appendTestVectors(t, col, 10)
+
+	pool := VariablePool{}
+	if err := col.Select(0, 10, "all", pool); err != nil {
+		t.Fatal(err)
+	}
+	backend.fail = true
+	if err := col.Select(0, 10, "again", pool); err == nil {
+		t.Error("Select succeeded without its chunk")
+	}
+	if _, ok := pool["again"]; ok {
+		t.Error("a failed Select stored its variable")
+	}
+	if _, err := col.Fetch("all", pool); err == nil {
+		t.Error("Fetch succeeded without its chunk")
+	}
+	if _, err := col.Sum("all", pool); err == nil {
+		t.Error("Sum succeeded without its chunk")
+	}
+	if _, err := col.DistAvg("all", pool, []float32{1, 1, 1, 1}); err == nil {
+		t.Error("DistAvg succeeded without its chunk")
+	}
+	if _, err := col.Fetch("missing", pool); err == nil {
+		t.Error("Fetch of an unknown variable succeeded")
+	}
+}
@@ -0,0 +1,299 @@
+package db
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+
+	"github.com/viterin/vek/vek32"
+)
+
+// ElementType describes how the 4-byte words of a vector are interpreted
+type ElementType uint8
+
+const (
+	Float32Elements ElementType = iota // IEEE 754 single precision values
+	BinaryElements                     // packed bits, 32 per word
+)
+
+// Metric identifies a distance or similarity function between vectors
+// MetricDefault, the zero value, stands for the column schema's metric, and for
+// Cosine in a schema
+type Metric uint8
+
+const (
+	MetricDefault Metric = iota
+	Cosine
+	DotProduct
+	L2
+	L1
+	Hamming // bits differing between binary vectors
+)
+
+// TimestampUnit is the unit of the int64 timestamps stored with each vector
+type TimestampUnit uint8
+
+const (
+	UnitUnspecified TimestampUnit = iota
+	Nanoseconds
+	Microseconds
+	Milliseconds
+	Seconds
+)
+
+// schemaRecordVersion is the encoding version of schema records in the catalog
+const schemaRecordVersion = 1
+
+// normalizedTolerance is how far a vector's norm may drift from 1 and still count as normalized
+const normalizedTolerance = 1e-3
+
+// Schema describes the vectors stored in a column
+// Revision is bumped by every SetSchema so readers can tell schemas apart
+type Schema struct {
+	Revision      uint32
+	Element       ElementType
+	Metric        Metric
+	Normalized    bool
+	ModelID       string
+	TimestampUnit TimestampUnit
+	Description   string
+}
+
+// Schema returns the column's schema, or the default schema if none was set
+func (column *Column) Schema() Schema {
+	schema, _ := column.storedSchema()
+	return schema
+}
+
+// helper returning the schema and whether one was ever stored
+func (column *Column) storedSchema() (Schema, bool) {
+	column.mu.RLock()
+	defer column.mu.RUnlock()
+	if column.schema == nil {
+		return Schema{Metric: Cosine}, false
+	}
+	return *column.schema, true
+}
+
+// SetSchema persists a new schema for the column, replacing the previous one
+// Existing vectors are not revalidated, only appends and queries from now on
+func (column *Column) SetSchema(schema Schema) error {
+	if !column.checkWritable() {
+		return fmt.Errorf("Bruh")
+	}
+	if err := schema.validate(); err != nil {
+		slog.Error("Invalid schema", "column", column.meta.name.String(), "error", err)
+		return err
+	}
+	if schema.Metric == MetricDefault {
+		schema.Metric = Cosine
+	}
+	unlock := column.file.lockWriter()
+	defer unlock()
+	r, unpin := column.file.Pin()
+	defer unpin()
+
+	// the in-memory schema is updated first so a compaction triggered by the append keeps it
+	column.mu.Lock()
+	prev := column.schema
+	if prev != nil {
+		schema.Revision = prev.Revision + 1
+	} else {
+		schema.Revision = 1
+	}
+	column.schema = &schema
+	column.mu.Unlock()
+	if err := column.conn.appendRecord(r, schema.record(column.meta.offset)); err != nil {
+		column.mu.Lock()
+		column.schema = prev
+		column.mu.Unlock()
+		return err
+	}
+	return column.file.commit()
+}
+
+// AddColumnWithSchema adds a column and stores its schema in one step
+func (tbl *Table) AddColumnWithSchema(colName string, vectorLength int64, schema Schema) (*Column, error) {
+	if err := schema.validate(); err != nil {
+		slog.Error("Invalid schema", "column", colName, "error", err)
+		return nil, err
+	}
+	col, err := tbl.AddColumn(colName, vectorLength)
+	if err != nil {
+		return nil, err
+	}
+	return col, col.SetSchema(schema)
+}
+
+func (schema Schema) validate() error {
+	if schema.Element > BinaryElements {
+		return fmt.Errorf("unknown element type %d", schema.Element)
+	}
+	if err := schema.checkMetric(schema.Metric); err != nil {
+		return err
+	}
+	if schema.TimestampUnit > Seconds {
+		return fmt.Errorf("unknown timestamp unit %d", schema.TimestampUnit)
+	}
+	if schema.Element == BinaryElements && schema.Normalized {
+		return fmt.Errorf("binary vectors cannot be normalized")
+	}
+	if len(schema.ModelID) > math.MaxUint16 || len(schema.Description) > math.MaxUint16 {
+		return fmt.Errorf("model id and description must be under 64KB")
+	}
+	return nil
+}
+
+// checkMetric validates a metric the column's vectors are scored by
+func (schema Schema) checkMetric(m Metric) error {
+	if err := m.validate(); err != nil {
+		return err
+	}
+	if m == Hamming && schema.Element != BinaryElements {
+		return fmt.Errorf("hamming distance needs binary vectors")
+	}
+	return nil
+}
+
+// checkVector validates a vector being appended to or queried against the column
+func (schema Schema) checkVector(vec []float32) error {
+	if schema.Element == BinaryElements {
+		return nil // every bit pattern is a valid binary vector
+	}
+	for _, v := range vec {
+		if math.IsNaN(float64(v)) || math.IsInf(float64(v), 0) {
+			return fmt.Errorf("vector contains NaN or Inf")
+		}
+	}
+	if schema.Normalized {
+		if norm := vek32.Norm(vec); math.Abs(float64(norm)-1) > normalizedTolerance {
+			return fmt.Errorf("vector norm %f, column expects normalized vectors", norm)
+		}
+	}
+	return nil
+}
+
+// ValidateQuery checks that a query vector fits the column's length and schema
+func (column *Column) ValidateQuery(target []float32) error {
+	meta := column.metadata()
+	if int64(len(target)) != meta.vectorLength {
+		slog.Error("Query vector length mismatch", "column", meta.name.String(), "query length", len(target), "column vector length", meta.vectorLength)
+		return fmt.Errorf("query vector has length %d, column has %d", len(target), meta.vectorLength)
+	}
+	if err := column.Schema().checkVector(target); err != nil {
+		slog.Error("Query vector does not match column schema", "column", meta.name.String(), "error", err)
+		return err
+	}
+	return nil
+}
+
+func (schema Schema) record(owner int64) catalogRecord {
+	payload := make([]byte, 0, 12+len(schema.ModelID)+len(schema.Description))
+	payload = append(payload, byte(schema.Element), byte(schema.Metric), boolByte(schema.Normalized), byte(schema.TimestampUnit))
+	payload = ByteOrder.AppendUint32(payload, schema.Revision)
+	payload = appendString(payload, schema.ModelID)
+	payload = appendString(payload, schema.Description)
+	return catalogRecord{
+		kind:    recordColumnSchema,
+		version: schemaRecordVersion,
+		owner:   owner,
+		payload: payload,
+	}
+}
+
+func decodeSchema(rec catalogRecord) (Schema, error) {
+	if rec.version != schemaRecordVersion {
+		return Schema{}, fmt.Errorf("unsupported schema version %d", rec.version)
+	}
+	p := rec.payload
+	if len(p) < 8 {
+		return Schema{}, fmt.Errorf("schema record too short")
+	}
+	schema := Schema{
+		Element:       ElementType(p[0]),
+		Metric:        Metric(p[1]),
+		Normalized:    p[2] != 0,
+		TimestampUnit: TimestampUnit(p[3]),
+		Revision:      ByteOrder.Uint32(p[4:8]),
+	}
+	var ok bool
+	p = p[8:]
+	if schema.ModelID, p, ok = readString(p); !ok {
+		return Schema{}, fmt.Errorf("schema record truncated")
+	}
+	if schema.Description, _, ok = readString(p); !ok {
+		return Schema{}, fmt.Errorf("schema record truncated")
+	}
+	return schema, nil
+}
+
+func boolByte(b bool) byte {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// strings in catalog records are a uint16 length followed by the bytes
+func appendString(b []byte, s string) []byte {
+	b = ByteOrder.AppendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+func readString(b []byte) (string, []byte, bool) {
+	if len(b) < 2 {
+		return "", nil, false
+	}
+	n := int(ByteOrder.Uint16(b))
+	if len(b) < 2+n {
+		return "", nil, false
+	}
+	return string(b[2 : 2+n]), b[2+n:], true
+}
+
+func (e ElementType) String() string {
+	switch e {
+	case Float32Elements:
+		return "float32"
+	case BinaryElements:
+		return "binary"
+	default:
+		return fmt.Sprintf("ElementType(%d)", uint8(e))
+	}
+}
+
+func (m Metric) String() string {
+	switch m {
+	case MetricDefault:
+		return "default"
+	case Cosine:
+		return "cosine"
+	case DotProduct:
+		return "dot"
+	case L2:
+		return "l2"
+	case L1:
+		return "l1"
+	case Hamming:
+		return "hamming"
+	default:
+		return fmt.Sprintf("Metric(%d)", uint8(m))
+	}
+}
+
+func (u TimestampUnit) String() string {
+	switch u {
+	case UnitUnspecified:
+		return "unspecified"
+	case Nanoseconds:
+		return "ns"
+	case Microseconds:
+		return "us"
+	case Milliseconds:
+		return "ms"
+	case Seconds:
+		return "s"
+	default:
+		return fmt.Sprintf("TimestampUnit(%d)", uint8(u))
+	}
+}
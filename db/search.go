@@ -0,0 +1,88 @@
+package db
+
+import (
+	"fmt"
+	"log/slog"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// TableSearchResult is a search result tagged with the column it came from
+type TableSearchResult struct {
+	Column string
+	SearchResult
+}
+
+// Search returns the k entries closest to query across every column in the table, best first
+// Columns are searched in parallel with the metric from their schemas, which must agree
+func (tbl *Table) Search(query []float32, k int) ([]TableSearchResult, error) {
+	if k <= 0 {
+		return nil, fmt.Errorf("k must be positive, got %d", k)
+	}
+	tbl.mu.RLock()
+	columns := slices.Clone(tbl.columns)
+	tbl.mu.RUnlock()
+	if len(columns) == 0 {
+		return []TableSearchResult{}, nil
+	}
+
+	metric := columns[0].Schema().Metric
+	for _, col := range columns {
+		if m := col.Schema().Metric; m != metric {
+			slog.Error("Columns disagree on metric", "table", tbl.meta.name.String(), "column", col.meta.name.String(), "metric", m, "want", metric)
+			return nil, fmt.Errorf("table %s mixes %s and %s columns", tbl.meta.name.String(), metric, m)
+		}
+		if err := col.ValidateQuery(query); err != nil {
+			return nil, err
+		}
+	}
+
+	// every column scan is itself parallel, so only a few columns run at once
+	perColumn := make([][]SearchResult, len(columns))
+	errs := make([]error, len(columns))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for range min(runtime.GOMAXPROCS(0), len(columns)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				perColumn[i], errs[i] = columns[i].scanTopK(query, k, metric, nil)
+			}
+		}()
+	}
+	for i := range columns {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	merged := []TableSearchResult{}
+	for i, results := range perColumn {
+		name := columns[i].meta.name.String()
+		for _, res := range results {
+			merged = append(merged, TableSearchResult{Column: name, SearchResult: res})
+		}
+	}
+	// ties are broken by column name and index so output is deterministic
+	slices.SortFunc(merged, func(a, b TableSearchResult) int {
+		switch {
+		case metric.Better(a.Score, b.Score):
+			return -1
+		case metric.Better(b.Score, a.Score):
+			return 1
+		case a.Column != b.Column:
+			return strings.Compare(a.Column, b.Column)
+		default:
+			return int(a.Index - b.Index)
+		}
+	})
+	return merged[:min(k, len(merged))], nil
+}
@@ -0,0 +1,66 @@
+package db
+
+import "testing"
+
+// addSearchColumn adds a two dimensional column holding the given x coordinates on y = 1
+func addSearchColumn(t *testing.T, tbl *Table, name string, schema Schema, xs ...float32) *Column {
+	t.Helper()
+	col, err := tbl.AddColumnWithSchema(name, 2, schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, x := range xs {
+		opts := WriteColumnOptions{Kind: Floats}
+		if err := opts.AddFloats([]float32{x, 1}); err != nil {
+			t.Fatal(err)
+		}
+		if err := col.AddVector(int64(i), opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return col
+}
+
+// Scores of different metrics cannot be merged, so a table mixing them is refused
+// until its columns agree
+func TestTableSearchRejectsMixedMetrics(t *testing.T) {
+	conn := openTestDB(t)
+	tbl, err := conn.AddTable("t", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := addSearchColumn(t, tbl, "a", Schema{Metric: Cosine}, 0, 3)
+	addSearchColumn(t, tbl, "b", Schema{Metric: L2}, 1, 3, 9)
+	query := []float32{3, 1}
+	if results, err := tbl.Search(query, 3); err == nil {
+		t.Fatalf("search across cosine and l2 columns returned %+v", results)
+	}
+
+	if err := a.SetSchema(Schema{Metric: L2}); err != nil {
+		t.Fatal(err)
+	}
+	results, err := tbl.Search(query, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the exact matches tie and go to the column name first
+	want := []struct {
+		column string
+		index  int64
+	}{{"a", 1}, {"b", 1}, {"b", 0}}
+	if len(results) != len(want) {
+		t.Fatalf("got %d results, want %d", len(results), len(want))
+	}
+	for i, w := range want {
+		if got := results[i]; got.Column != w.column || got.Index != w.index {
+			t.Fatalf("result %d is %+v, want %s entry %d", i, got, w.column, w.index)
+		}
+	}
+	if results[0].Score != 0 || results[1].Score != 0 || results[2].Score <= 1 {
+		t.Fatalf("scores are %v, %v and %v", results[0].Score, results[1].Score, results[2].Score)
+	}
+
+	if _, err := tbl.Search([]float32{3}, 3); err == nil {
+		t.Fatal("a query of the wrong length was accepted")
+	}
+}
@@ -0,0 +1,143 @@
+package db
+
+import "log/slog"
+
+// columnView bounds a scan to a consistent prefix of a column's chunk chain
+// A live column view is only bounded by numVectors, a snapshot view also
+// remembers the chain tail so chunks linked in later are never visited
+type columnView struct {
+	meta      ColumnMetadata
+	tailChunk int64 // offset of the last chunk at snapshot time, 0 = live
+	tailCount int64 // vectors in the tail chunk at snapshot time
+}
+
+// Snapshot is a point-in-time view of every column in the DB
+// Columns returned from a snapshot can be scanned with Select, Fetch, the math
+// reducers and Ikeji while writers keep appending to the live columns
+type Snapshot struct {
+	columns map[*Column]*Column
+}
+
+// Snapshot captures the vector count and chain tail of every column
+// Taken under the writer lock, so all columns observe the same point in time
+func (conn *DB) Snapshot() *Snapshot {
+	unlock := conn.file.lockWriter()
+	defer unlock()
+	r, unpin := conn.file.Pin()
+	defer unpin()
+	return conn.snapshotLocked(r)
+}
+
+// helper to freeze every column, caller must hold the writer lock
+func (conn *DB) snapshotLocked(r region) *Snapshot {
+	snap := &Snapshot{columns: map[*Column]*Column{}}
+	conn.mu.RLock()
+	defer conn.mu.RUnlock()
+	for _, tbl := range conn.tables {
+		tbl.mu.RLock()
+		for _, col := range tbl.columns {
+			snap.columns[col] = col.freeze(r)
+		}
+		tbl.mu.RUnlock()
+	}
+	return snap
+}
+
+// Column returns the frozen view of col, or false if col did not exist when
+// the snapshot was taken
+func (snap *Snapshot) Column(col *Column) (*Column, bool) {
+	frozen, ok := snap.columns[col]
+	return frozen, ok
+}
+
+// Snapshot returns a frozen view of a single column
+// Use this when a query only touches one column and a DB-wide snapshot is overkill
+func (column *Column) Snapshot() *Column {
+	if column.snap != nil {
+		return column
+	}
+	unlock := column.file.lockWriter()
+	defer unlock()
+	r, unpin := column.file.Pin()
+	defer unpin()
+	return column.freeze(r)
+}
+
+// helper to build a frozen copy of the column, caller must hold the writer lock
+func (column *Column) freeze(r region) *Column {
+	meta := column.metadata()
+	view := columnView{meta: meta}
+	chunk := meta.firstChunkOffset
+	for chunk != 0 {
+		header := r.chunkHeader(chunk)
+		view.tailChunk, view.tailCount = chunk, header.numVectors
+		chunk = header.nextChunk
+	}
+	schema, _ := column.storedSchema()
+	return &Column{
+		meta:    meta,
+		file:    column.file,
+		conn:    column.conn,
+		snap:    &view,
+		schema:  &schema,
+		indexes: column.indexes,
+		stats:   column.stats,
+	}
+}
+
+// view returns the bounds every scan over this column must respect
+func (column *Column) view() columnView {
+	if column.snap != nil {
+		return *column.snap
+	}
+	return columnView{meta: column.metadata()}
+}
+
+// walk calls fn with every chunk in the view, the bytes of its visible entries and
+// how many there are. Offloaded chunks are faulted in through the region's cache.
+// Stops early if fn returns false, a chunk that cannot be read stops it with an error
+func (v columnView) walk(r region, fn func(chunkPos int64, data []byte, count int64) bool) error {
+	entrySize := 8 + (v.meta.vectorLength * 4)
+	var err error
+	v.chunks(r, func(chunk int64, header ChunkHeader, count int64) bool {
+		var data []byte
+		data, err = r.entries(chunk, header, count*entrySize)
+		if err != nil {
+			slog.Error("Could not read chunk, stopping scan", "column", v.meta.name.String(), "chunk", chunk, "error", err)
+			return false
+		}
+		return fn(chunk, data, count)
+	})
+	return err
+}
+
+// chunks calls fn with every chunk in the view, its header and how many of its
+// entries are visible, without reading any entries. Stops early if fn returns false
+func (v columnView) chunks(r region, fn func(chunkPos int64, header ChunkHeader, count int64) bool) {
+	remaining := v.meta.numVectors
+	chunk := v.meta.firstChunkOffset
+	for chunk != 0 && remaining > 0 {
+		header := r.chunkHeader(chunk)
+		count := min(header.numVectors, remaining)
+		if chunk == v.tailChunk {
+			count = min(count, v.tailCount)
+		}
+		if !fn(chunk, header, count) {
+			return
+		}
+		remaining -= count
+		if chunk == v.tailChunk {
+			return
+		}
+		chunk = header.nextChunk
+	}
+}
+
+// helper for rejecting writes through a frozen column
+func (column *Column) checkWritable() bool {
+	if column.snap != nil {
+		slog.Error("Cannot write to a snapshot column", "column", column.meta.name.String())
+		return false
+	}
+	return true
+}
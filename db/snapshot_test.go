@@ -0,0 +1,51 @@
+package db
+
+import (
+	"context"
+	"testing"
+)
+
+// A chunk that cannot be faulted back in fails the scan instead of cutting it short
+func TestScanReportsUnreadableChunk(t *testing.T) {
+	conn := openTestDB(t)
+	// entries this wide fill a chunk in about a thousand appends
+	col := newTestColumn(t, conn, "wide", 16384)
+	appendTestVectors(t, col, 1100)
+
+	blobs, err := NewDirBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetBlobStore(blobs, 1)
+	moved, err := col.Offload(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved != 1 {
+		t.Fatalf("offloaded %d chunks, want 1", moved)
+	}
+	if _, err := fetchRange(col, 0, 1100); err != nil {
+		t.Fatalf("scan before the blob is lost: %v", err)
+	}
+	if err := blobs.Delete(context.Background(), chunkKey(col.meta.firstChunkOffset)); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetBlobStore(blobs, 1) // drops the chunk the scan above cached
+
+	query := make([]float32, 16384)
+	if _, err := fetchRange(col, 0, 1100); err == nil {
+		t.Error("Select and Fetch succeeded without the offloaded chunk")
+	}
+	if _, err := col.TopK(query, 5, L2); err == nil {
+		t.Error("TopK succeeded without the offloaded chunk")
+	}
+	if _, err := col.Stats(); err == nil {
+		t.Error("Stats succeeded without the offloaded chunk")
+	}
+	if _, err := conn.Execute("SELECT COUNT(*) FROM wide.wide WHERE ts < 2000", nil); err == nil {
+		t.Error("SQL query succeeded without the offloaded chunk")
+	}
+	if _, err := Query(col).Near(query).Limit(5).Run(context.Background()); err == nil {
+		t.Error("query builder succeeded without the offloaded chunk")
+	}
+}
@@ -0,0 +1,466 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// ResultSet holds the rows a query produced, each with one value per column
+// Indexes, timestamps and counts are int64, scores float32, embeddings and vector
+// aggregates []float32 and column names strings. EXPLAIN queries only carry the plan
+type ResultSet struct {
+	Columns []string `json:"columns,omitempty"`
+	Rows    [][]any  `json:"rows,omitempty"`
+	Plan    *Plan    `json:"plan,omitempty"`
+}
+
+// Execute parses and runs a query, reading every column through one snapshot
+// params binds the $name values of the query, vectors as []float32 or []float64
+// and numbers as any Go integer or float
+func (conn *DB) Execute(query string, params map[string]any) (*ResultSet, error) {
+	return conn.ExecuteContext(context.Background(), query, params)
+}
+
+// ExecuteContext is Execute with a context checked between and during operators
+func (conn *DB) ExecuteContext(ctx context.Context, query string, params map[string]any) (*ResultSet, error) {
+	stmt, err := ParseQuery(query)
+	if err != nil {
+		slog.Error("Could not parse query", "error", err)
+		return nil, err
+	}
+	return conn.ExecuteStatement(ctx, stmt, params)
+}
+
+// ExecuteStatement plans and runs a parsed query
+func (conn *DB) ExecuteStatement(ctx context.Context, stmt *Statement, params map[string]any) (*ResultSet, error) {
+	columns, err := conn.statementColumns(stmt)
+	if err != nil {
+		return nil, err
+	}
+	items, err := stmt.resolveItems()
+	if err != nil {
+		return nil, err
+	}
+	frozen := []*Column{}
+	if len(columns) == 1 {
+		frozen = append(frozen, columns[0].Snapshot())
+	} else {
+		snap := conn.Snapshot()
+		for _, col := range columns {
+			// columns created after the table was read are not in the snapshot
+			if view, ok := snap.Column(col); ok {
+				frozen = append(frozen, view)
+			}
+		}
+	}
+
+	// every column is planned before any runs, so a bad parameter fails fast
+	aggregates := slices.ContainsFunc(items, func(item SelectItem) bool { return item.Kind == ItemAggregate })
+	withVec := slices.ContainsFunc(items, func(item SelectItem) bool { return item.Kind == ItemEmbedding })
+	merge := &PlanNode{Operator: "merge", Detail: stmt.Table}
+	aggregateSteps := []*aggregateStep{}
+	rowsSteps := []*rowsStep{}
+	for _, col := range frozen {
+		p, err := col.newPlanner(ctx, queryParams(params))
+		if err != nil {
+			return nil, err
+		}
+		filter, err := p.condition(stmt.Where)
+		if err != nil {
+			return nil, err
+		}
+		var node *PlanNode
+		if aggregates {
+			step := p.aggregate(items, filter)
+			aggregateSteps = append(aggregateSteps, step)
+			node = step.node
+		} else {
+			step, err := p.rows(stmt, filter, withVec)
+			if err != nil {
+				return nil, err
+			}
+			rowsSteps = append(rowsSteps, step)
+			node = step.node
+		}
+		merge.Inputs = append(merge.Inputs, node)
+		merge.Cost += node.Cost
+		merge.EstimatedRows += node.EstimatedRows
+	}
+	if stmt.Limit > 0 {
+		merge.EstimatedRows = min(merge.EstimatedRows, int64(stmt.Limit))
+	}
+
+	rs := &ResultSet{Columns: make([]string, len(items))}
+	for i, item := range items {
+		rs.Columns[i] = item.String()
+	}
+	run := func() (int64, error) {
+		for _, step := range aggregateSteps {
+			row, err := step.run()
+			if err != nil {
+				return 0, err
+			}
+			rs.Rows = append(rs.Rows, row)
+		}
+		rows := []sqlRow{}
+		for _, step := range rowsSteps {
+			colRows, err := step.run()
+			if err != nil {
+				return 0, err
+			}
+			rows = append(rows, colRows...)
+		}
+		if stmt.Order != nil && len(rowsSteps) > 1 {
+			slices.SortStableFunc(rows, stmt.Order.compare)
+		}
+		for _, row := range rows {
+			rs.Rows = append(rs.Rows, row.values(items))
+		}
+		if stmt.Limit > 0 {
+			rs.Rows = rs.Rows[:min(stmt.Limit, len(rs.Rows))]
+		}
+		return int64(len(rs.Rows)), nil
+	}
+
+	plan := &Plan{Root: merge}
+	start := time.Now()
+	if len(merge.Inputs) == 1 {
+		// a single column needs no merge, its operator already records itself
+		plan.Root = merge.Inputs[0]
+		_, err = run()
+	} else {
+		err = merge.measure(run)
+	}
+	plan.Time = time.Since(start)
+	if err != nil {
+		return nil, err
+	}
+	if stmt.Explain {
+		return &ResultSet{Plan: plan}, nil
+	}
+	return rs, nil
+}
+
+// WriteText prints the result set as an aligned table, or the plan of an EXPLAIN query
+func (rs *ResultSet) WriteText(w io.Writer) error {
+	if rs.Plan != nil {
+		return rs.Plan.WriteText(w)
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(rs.Columns, "\t"))
+	for _, row := range rs.Rows {
+		cells := make([]string, len(row))
+		for i, v := range row {
+			cells[i] = fmt.Sprint(v)
+		}
+		fmt.Fprintln(tw, strings.Join(cells, "\t"))
+	}
+	fmt.Fprintf(tw, "(%d rows)\n", len(rs.Rows))
+	return tw.Flush()
+}
+
+// helper resolving the FROM clause to the columns it reads
+func (conn *DB) statementColumns(stmt *Statement) ([]*Column, error) {
+	tbl, ok := conn.GetTableByName(stmt.Table)
+	if !ok {
+		return nil, fmt.Errorf("no table %q", stmt.Table)
+	}
+	if stmt.Column != "" {
+		col, ok := tbl.GetColumnByName(stmt.Column)
+		if !ok {
+			return nil, fmt.Errorf("no column %q in table %s", stmt.Column, stmt.Table)
+		}
+		return []*Column{col}, nil
+	}
+	tbl.mu.RLock()
+	defer tbl.mu.RUnlock()
+	if len(tbl.columns) == 0 {
+		return nil, fmt.Errorf("table %s has no columns", stmt.Table)
+	}
+	return slices.Clone(tbl.columns), nil
+}
+
+// resolveItems expands * and rejects selections the query cannot produce
+func (stmt *Statement) resolveItems() ([]SelectItem, error) {
+	items := stmt.Items
+	if items == nil {
+		if stmt.Column == "" {
+			items = append(items, SelectItem{Kind: ItemColumn})
+		}
+		items = append(items, SelectItem{Kind: ItemIndex}, SelectItem{Kind: ItemTimestamp})
+		if stmt.Order != nil && !stmt.Order.Timestamp {
+			items = append(items, SelectItem{Kind: ItemScore})
+		}
+		items = append(items, SelectItem{Kind: ItemEmbedding})
+	}
+	aggregates := 0
+	for _, item := range items {
+		switch item.Kind {
+		case ItemScore:
+			if stmt.Order == nil || stmt.Order.Timestamp {
+				return nil, fmt.Errorf("score needs ORDER BY a distance function")
+			}
+		case ItemAggregate:
+			aggregates++
+		}
+	}
+	if aggregates > 0 {
+		for _, item := range items {
+			if item.Kind != ItemAggregate && item.Kind != ItemColumn {
+				return nil, fmt.Errorf("cannot select %s next to an aggregate", item)
+			}
+		}
+		if stmt.Order != nil {
+			return nil, fmt.Errorf("aggregates cannot be ordered")
+		}
+	}
+	return items, nil
+}
+
+// sqlRow is an entry matched by a query, vec is a copy and only set when selected
+type sqlRow struct {
+	column string
+	idx    int64
+	ts     uint64
+	score  float32
+	vec    []float32
+}
+
+func (row sqlRow) values(items []SelectItem) []any {
+	values := make([]any, len(items))
+	for i, item := range items {
+		switch item.Kind {
+		case ItemIndex:
+			values[i] = row.idx
+		case ItemTimestamp:
+			values[i] = int64(row.ts)
+		case ItemScore:
+			values[i] = row.score
+		case ItemEmbedding:
+			values[i] = row.vec
+		case ItemColumn:
+			values[i] = row.column
+		}
+	}
+	return values
+}
+
+// compare orders rows by the clause, ties go to column name then index so output is deterministic
+func (o *Ordering) compare(a, b sqlRow) int {
+	c := 0
+	switch {
+	case o.Timestamp && a.ts != b.ts:
+		c = cmpInt(int64(a.ts), int64(b.ts))
+		if o.Desc {
+			c = -c
+		}
+	case !o.Timestamp && o.BestFirst():
+		c = compareScores(o.Metric, a.score, b.score)
+	case !o.Timestamp:
+		c = -compareScores(o.Metric, a.score, b.score)
+	}
+	if c != 0 {
+		return c
+	}
+	if a.column != b.column {
+		return strings.Compare(a.column, b.column)
+	}
+	return cmpInt(a.idx, b.idx)
+}
+
+func compareScores(metric Metric, a, b float32) int {
+	switch {
+	case metric.Better(a, b):
+		return -1
+	case metric.Better(b, a):
+		return 1
+	default:
+		return 0
+	}
+}
+
+func cmpInt(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// fillVectors copies the vector of every row from the column
+func (column *Column) fillVectors(rows []sqlRow) error {
+	hits := NewBitmap()
+	at := map[int64]int{}
+	for i, row := range rows {
+		hits.Add(row.idx)
+		at[row.idx] = i
+	}
+	return column.visitBitmap(hits, func(idx int64, vec Vector) bool {
+		rows[at[idx]].vec = slices.Clone(vec.features)
+		return true
+	})
+}
+
+// aggregate plans the reduction of the filter's entries to one row
+func (p *planner) aggregate(items []SelectItem, filter *bitmapStep) *aggregateStep {
+	names := []string{}
+	cost := filter.node.Cost
+	for _, item := range items {
+		if item.Kind != ItemAggregate {
+			continue
+		}
+		names = append(names, item.String())
+		// COUNT only needs the bitmap, the others read every admitted vector
+		if item.Aggregate != "COUNT" {
+			cost += float64(filter.node.EstimatedRows) * float64(p.dim)
+		}
+	}
+	node := &PlanNode{
+		Operator:      "aggregate",
+		Detail:        fmt.Sprintf("%s %s", p.name, strings.Join(names, ", ")),
+		Cost:          cost,
+		EstimatedRows: 1,
+		Inputs:        []*PlanNode{filter.node},
+	}
+	column := p.column
+	return &aggregateStep{node: node, run: func() ([]any, error) {
+		row := make([]any, len(items))
+		err := node.measure(func() (int64, error) {
+			where, err := filter.run()
+			if err != nil {
+				return 0, err
+			}
+			pool := VariablePool{"where": where}
+			for i, item := range items {
+				var vec []float32
+				switch {
+				case item.Kind == ItemColumn:
+					row[i] = p.name
+				case item.Aggregate == "COUNT":
+					row[i] = where.Cardinality()
+				case item.Aggregate == "SUM":
+					vec, err = column.Sum("where", pool)
+					row[i] = vec
+				case item.Aggregate == "PROD":
+					vec, err = column.Prod("where", pool)
+					row[i] = vec
+				case item.Aggregate == "AVG":
+					row[i], err = column.avg("where", pool, column.Schema().Metric)
+				}
+				if err != nil {
+					return 0, err
+				}
+			}
+			return 1, nil
+		})
+		return row, err
+	}}
+}
+
+// aggregateStep is a planned aggregate producing the column's single row
+type aggregateStep struct {
+	node *PlanNode
+	run  func() ([]any, error)
+}
+
+// queryParams binds the $name values of a query
+type queryParams map[string]any
+
+func (params queryParams) lookup(v Value) (any, error) {
+	value, ok := params[v.Param]
+	if !ok {
+		return nil, fmt.Errorf("parameter $%s is not bound", v.Param)
+	}
+	return value, nil
+}
+
+func (params queryParams) vector(v Value) ([]float32, error) {
+	switch {
+	case v.Vector != nil:
+		return v.Vector, nil
+	case v.Param == "":
+		return nil, fmt.Errorf("expected a vector, found %s", v)
+	}
+	value, err := params.lookup(v)
+	if err != nil {
+		return nil, err
+	}
+	switch vec := value.(type) {
+	case []float32:
+		return vec, nil
+	case []float64:
+		out := make([]float32, len(vec))
+		for i, x := range vec {
+			out[i] = float32(x)
+		}
+		return out, nil
+	default:
+		return nil, fmt.Errorf("parameter $%s is %T, expected a vector", v.Param, value)
+	}
+}
+
+func (params queryParams) number(v Value) (float64, error) {
+	switch {
+	case v.Vector != nil:
+		return 0, fmt.Errorf("expected a number, found %s", v)
+	case v.Param == "":
+		return strconv.ParseFloat(v.Number, 64)
+	}
+	value, err := params.lookup(v)
+	if err != nil {
+		return 0, err
+	}
+	switch x := value.(type) {
+	case int:
+		return float64(x), nil
+	case int64:
+		return float64(x), nil
+	case uint64:
+		return float64(x), nil
+	case float32:
+		return float64(x), nil
+	case float64:
+		return x, nil
+	default:
+		return 0, fmt.Errorf("parameter $%s is %T, expected a number", v.Param, value)
+	}
+}
+
+// integer is number for timestamps, which must not lose precision to a float64
+func (params queryParams) integer(v Value) (int64, error) {
+	if v.Param == "" && v.Vector == nil {
+		if i, err := strconv.ParseInt(v.Number, 10, 64); err == nil {
+			return i, nil
+		}
+	} else if v.Param != "" {
+		value, err := params.lookup(v)
+		if err != nil {
+			return 0, err
+		}
+		switch x := value.(type) {
+		case int:
+			return int64(x), nil
+		case int64:
+			return x, nil
+		}
+	}
+	f, err := params.number(v)
+	if err != nil {
+		return 0, err
+	}
+	if f != math.Trunc(f) || math.Abs(f) > math.MaxInt64 {
+		return 0, fmt.Errorf("expected an integer timestamp, found %s", v)
+	}
+	return int64(f), nil
+}
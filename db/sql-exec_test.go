@@ -0,0 +1,162 @@
+package db
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// newSQLTestDB holds table t with column c, where entry i is (i, 1) at timestamp 10i
+// under L2, and column d, where entry i is (i+0.5, 1) at timestamp 10i+5
+func newSQLTestDB(t *testing.T) *DB {
+	t.Helper()
+	conn := openTestDB(t)
+	tbl, err := conn.AddTable("t", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		name   string
+		offset float32
+		n      int
+	}{{"c", 0, 6}, {"d", 0.5, 3}} {
+		col, err := tbl.AddColumnWithSchema(c.name, 2, Schema{Metric: L2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range c.n {
+			opts := WriteColumnOptions{Kind: Floats}
+			if err := opts.AddFloats([]float32{float32(i) + c.offset, 1}); err != nil {
+				t.Fatal(err)
+			}
+			if err := col.AddVector(int64(10*i)+int64(10*c.offset), opts); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return conn
+}
+
+// sqlRows renders every row on one line so results compare as strings
+func sqlRows(rs *ResultSet) string {
+	rows := []string{}
+	for _, row := range rs.Rows {
+		rows = append(rows, strings.TrimSuffix(fmt.Sprintln(row...), "\n"))
+	}
+	return strings.Join(rows, "; ")
+}
+
+func TestExecuteRows(t *testing.T) {
+	conn := newSQLTestDB(t)
+	cases := []struct {
+		query string
+		want  string
+	}{
+		{"SELECT idx FROM t.c WHERE ts BETWEEN 10 AND 30", "1; 2; 3"},
+		{"SELECT idx FROM t.c WHERE ts BETWEEN 30 AND 30", "3"},
+		{"SELECT idx FROM t.c WHERE ts BETWEEN 31 AND 30", ""},
+		{"SELECT idx FROM t.c WHERE NOT ts BETWEEN 10 AND 30 OR ts = 20", "0; 2; 4; 5"},
+		{"SELECT idx FROM t.c WHERE ts > 10 AND ts <= 40 AND ts != 30", "2; 4"},
+		{"SELECT idx, ts FROM t.c ORDER BY ts DESC LIMIT 2", "5 50; 4 40"},
+		{"SELECT idx FROM t.c ORDER BY l2(embedding, [3.2, 1]) LIMIT 3", "3; 4; 2"},
+		{"SELECT idx FROM t.c ORDER BY l2(embedding, [3.2, 1]) DESC LIMIT 2", "0; 1"},
+		{"SELECT idx FROM t.c WHERE l2(embedding, [3.2, 1]) < 1 ORDER BY ts DESC", "4; 3"},
+		{"SELECT idx, embedding FROM t.c WHERE ts = 50", "5 [5 1]"},
+		// a bare table merges its columns
+		{"SELECT column, idx, ts FROM t WHERE ts BETWEEN 10 AND 20 ORDER BY ts", "c 1 10; d 1 15; c 2 20"},
+		{"SELECT column, idx FROM t ORDER BY l2(embedding, [1.2, 1]) LIMIT 3", "c 1; d 1; d 0"},
+		{"SELECT * FROM t.c WHERE ts = 20", "2 20 [2 1]"},
+		{"SELECT * FROM t.c ORDER BY l2(embedding, [0, 1]) LIMIT 1", "0 0 0 [0 1]"},
+	}
+	for _, c := range cases {
+		t.Run(c.query, func(t *testing.T) {
+			rs, err := conn.Execute(c.query, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := sqlRows(rs); got != c.want {
+				t.Fatalf("rows are %q, want %q", got, c.want)
+			}
+		})
+	}
+}
+
+func TestExecuteAggregates(t *testing.T) {
+	conn := newSQLTestDB(t)
+	rs, err := conn.Execute("SELECT count(*), sum(embedding), avg(embedding), prod(embedding) FROM t.c WHERE ts BETWEEN 10 AND 30", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"count(*)", "sum(embedding)", "avg(embedding)", "prod(embedding)"}; !slices.Equal(rs.Columns, want) {
+		t.Fatalf("columns are %v", rs.Columns)
+	}
+	if got, want := sqlRows(rs), "3 [6 3] [2 1] [6 1]"; got != want {
+		t.Fatalf("aggregates are %q, want %q", got, want)
+	}
+
+	// one row per column of a bare table, nothing matched counts zero
+	rs, err = conn.Execute("SELECT column, count(*) FROM t WHERE ts >= 20", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := sqlRows(rs), "c 4; d 1"; got != want {
+		t.Fatalf("counts are %q, want %q", got, want)
+	}
+	rs, err = conn.Execute("SELECT count(*) FROM t.c WHERE ts > 100", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := sqlRows(rs); got != "0" {
+		t.Fatalf("count over nothing is %q", got)
+	}
+
+	for _, query := range []string{
+		"SELECT idx, count(*) FROM t.c",
+		"SELECT count(*) FROM t.c ORDER BY ts",
+		"SELECT score FROM t.c",
+		"SELECT score FROM t.c ORDER BY ts",
+	} {
+		if _, err := conn.Execute(query, nil); err == nil {
+			t.Errorf("%q ran", query)
+		}
+	}
+}
+
+func TestExecuteParams(t *testing.T) {
+	conn := newSQLTestDB(t)
+	query := "SELECT idx FROM t.c WHERE ts BETWEEN $lo AND $hi AND l2(embedding, $q) < $r"
+	for _, params := range []map[string]any{
+		{"lo": 10, "hi": int64(40), "q": []float32{2, 1}, "r": 1.5},
+		{"lo": uint64(10), "hi": 40.0, "q": []float64{2, 1}, "r": float32(1.5)},
+	} {
+		rs, err := conn.Execute(query, params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := sqlRows(rs), "1; 2; 3"; got != want {
+			t.Fatalf("rows with %v are %q, want %q", params, got, want)
+		}
+	}
+
+	bound := map[string]any{"lo": 10, "hi": 40, "q": []float32{2, 1}, "r": 1.5}
+	for name, value := range map[string]any{
+		"lo": nil,            // unbound
+		"hi": 40.5,           // timestamps are integers
+		"q":  "2, 1",         // not a vector
+		"r":  []float32{1.5}, // not a number
+	} {
+		params := maps.Clone(bound)
+		params[name] = value
+		if value == nil {
+			delete(params, name)
+		}
+		if _, err := conn.Execute(query, params); err == nil {
+			t.Errorf("$%s bound to %v ran", name, value)
+		}
+	}
+	if _, err := conn.Execute("SELECT idx FROM t.c ORDER BY l2(embedding, [1, 2, 3])", nil); err == nil {
+		t.Error("a query vector of the wrong length ran")
+	}
+}
@@ -0,0 +1,145 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+/*
+Query language tokens:
+	identifiers and keywords   letters, digits and underscores, keywords are case insensitive
+	quoted identifiers         anything between double quotes, for names like "clip-01"
+	numbers                    digits with an optional fraction and exponent, signs are parsed as operators
+	parameters                 $ followed by an identifier, bound when the query runs
+	punctuation                , . ( ) [ ] * - = != < <= > >=
+*/
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenKeyword
+	tokenNumber
+	tokenParam
+	tokenPunct
+)
+
+var keywords = map[string]bool{
+	"SELECT": true, "FROM": true, "WHERE": true, "AND": true, "OR": true, "NOT": true,
+	"BETWEEN": true, "ORDER": true, "BY": true, "ASC": true, "DESC": true, "LIMIT": true,
+	"EXPLAIN": true,
+}
+
+type token struct {
+	kind tokenKind
+	text string // keywords are upper cased, parameters drop the $
+	pos  int    // byte offset in the query, for error messages
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokenEOF:
+		return "end of query"
+	case tokenParam:
+		return "$" + t.text
+	default:
+		return fmt.Sprintf("%q", t.text)
+	}
+}
+
+// lex splits a query into tokens, ending with a tokenEOF
+func lex(query string) ([]token, error) {
+	tokens := []token{}
+	for i := 0; i < len(query); {
+		c := rune(query[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case isIdentStart(c):
+			start := i
+			for i < len(query) && isIdentPart(rune(query[i])) {
+				i++
+			}
+			text := query[start:i]
+			if upper := strings.ToUpper(text); keywords[upper] {
+				tokens = append(tokens, token{kind: tokenKeyword, text: upper, pos: start})
+			} else {
+				tokens = append(tokens, token{kind: tokenIdent, text: text, pos: start})
+			}
+		case c == '"':
+			end := strings.IndexByte(query[i+1:], '"')
+			if end < 0 {
+				return nil, fmt.Errorf("syntax error at %d: unterminated quoted name", i)
+			}
+			tokens = append(tokens, token{kind: tokenIdent, text: query[i+1 : i+1+end], pos: i})
+			i += end + 2
+		case c >= '0' && c <= '9':
+			start := i
+			i = scanNumber(query, i)
+			tokens = append(tokens, token{kind: tokenNumber, text: query[start:i], pos: start})
+		case c == '$':
+			start := i
+			i++
+			for i < len(query) && isIdentPart(rune(query[i])) {
+				i++
+			}
+			if i == start+1 {
+				return nil, fmt.Errorf("syntax error at %d: parameter needs a name", start)
+			}
+			tokens = append(tokens, token{kind: tokenParam, text: query[start+1 : i], pos: start})
+		default:
+			start := i
+			if i+1 < len(query) {
+				if two := query[i : i+2]; two == "<=" || two == ">=" || two == "!=" || two == "<>" {
+					if two == "<>" {
+						two = "!="
+					}
+					tokens = append(tokens, token{kind: tokenPunct, text: two, pos: start})
+					i += 2
+					continue
+				}
+			}
+			if !strings.ContainsRune(",.()[]*-=<>", c) {
+				return nil, fmt.Errorf("syntax error at %d: unexpected character %q", start, c)
+			}
+			tokens = append(tokens, token{kind: tokenPunct, text: string(c), pos: start})
+			i++
+		}
+	}
+	return append(tokens, token{kind: tokenEOF, pos: len(query)}), nil
+}
+
+// scanNumber returns the end of the number starting at i
+func scanNumber(query string, i int) int {
+	digits := func() {
+		for i < len(query) && query[i] >= '0' && query[i] <= '9' {
+			i++
+		}
+	}
+	digits()
+	if i < len(query) && query[i] == '.' {
+		i++
+		digits()
+	}
+	if i < len(query) && (query[i] == 'e' || query[i] == 'E') {
+		j := i + 1
+		if j < len(query) && (query[j] == '+' || query[j] == '-') {
+			j++
+		}
+		if j < len(query) && query[j] >= '0' && query[j] <= '9' {
+			i = j
+			digits()
+		}
+	}
+	return i
+}
+
+func isIdentStart(c rune) bool {
+	return c == '_' || (c < unicode.MaxASCII && unicode.IsLetter(c))
+}
+
+func isIdentPart(c rune) bool {
+	return isIdentStart(c) || (c >= '0' && c <= '9')
+}
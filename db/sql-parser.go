@@ -0,0 +1,511 @@
+package db
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+/*
+Query grammar, keywords are case insensitive:
+	query     = [EXPLAIN] SELECT items FROM source [WHERE cond] [ORDER BY order] [LIMIT number]
+	items     = "*" | item {"," item}
+	item      = idx | ts | score | embedding | column | aggregate "(" (embedding | "*") ")"
+	aggregate = SUM | AVG | PROD | COUNT
+	source    = table "." column | table                  a bare table queries every column
+	cond      = and {OR and}
+	and       = not {AND not}
+	not       = NOT not | "(" cond ")" | predicate
+	predicate = ts BETWEEN value AND value | ts op value | distance op value
+	distance  = metric "(" embedding "," value ")"        metric is cosine, dot, l2, l1 or hamming
+	order     = (ts | distance) [ASC | DESC]
+	value     = number | "-" number | $param | "[" number {"," number} "]"
+Distances order best first unless a direction is given. BETWEEN includes both ends.
+EXPLAIN runs the query and returns the plan it ran with instead of its rows
+*/
+
+// Statement is a parsed query
+type Statement struct {
+	Items  []SelectItem
+	Table  string
+	Column string    // empty when the query spans every column of the table
+	Where  Condition // nil admits every entry
+	Order  *Ordering // nil keeps column order
+	Limit  int       // 0 for no limit
+
+	Explain bool
+}
+
+// ItemKind is what a selected value holds
+type ItemKind int
+
+const (
+	ItemIndex     ItemKind = iota // position of the entry in its column
+	ItemTimestamp                 // timestamp of the entry
+	ItemScore                     // score of the ORDER BY distance
+	ItemEmbedding                 // the entry's vector
+	ItemColumn                    // name of the column the entry came from
+	ItemAggregate                 // SUM, AVG, PROD or COUNT over the matching entries
+)
+
+// SelectItem is one value of every result row
+type SelectItem struct {
+	Kind      ItemKind
+	Aggregate string // SUM, AVG, PROD or COUNT for ItemAggregate
+}
+
+func (item SelectItem) String() string {
+	switch item.Kind {
+	case ItemIndex:
+		return "idx"
+	case ItemTimestamp:
+		return "ts"
+	case ItemScore:
+		return "score"
+	case ItemEmbedding:
+		return "embedding"
+	case ItemColumn:
+		return "column"
+	default:
+		if item.Aggregate == "COUNT" {
+			return "count(*)"
+		}
+		return strings.ToLower(item.Aggregate) + "(embedding)"
+	}
+}
+
+// Condition is a WHERE clause, each kind evaluates to a bitmap of entries
+type Condition interface {
+	String() string
+}
+
+// AndCondition admits entries admitted by both sides
+type AndCondition struct{ Left, Right Condition }
+
+// OrCondition admits entries admitted by either side
+type OrCondition struct{ Left, Right Condition }
+
+// NotCondition admits the entries its operand does not
+type NotCondition struct{ Operand Condition }
+
+// TimeCondition compares timestamps, BETWEEN uses both bounds and the other operators Low
+type TimeCondition struct {
+	Op        string // BETWEEN, =, !=, <, <=, > or >=
+	Low, High Value
+}
+
+// DistanceCondition compares the score of every entry against Query with Threshold
+type DistanceCondition struct {
+	Metric    Metric
+	Query     Value
+	Op        string // =, !=, <, <=, > or >=
+	Threshold Value
+}
+
+// Ordering is an ORDER BY clause, by timestamp or by a distance to Query
+type Ordering struct {
+	Timestamp bool
+	Metric    Metric
+	Query     Value
+	Desc      bool
+	Explicit  bool // the direction was written out rather than defaulted
+}
+
+// BestFirst reports whether a distance ordering ranks the closest entries first
+func (o *Ordering) BestFirst() bool {
+	return !o.Timestamp && o.Desc == o.Metric.HigherIsBetter()
+}
+
+// Value is a literal or a parameter bound when the query runs
+type Value struct {
+	Param  string    // name without the $, empty for literals
+	Number string    // number literal as written
+	Vector []float32 // vector literal
+}
+
+func (c AndCondition) String() string { return fmt.Sprintf("(%s AND %s)", c.Left, c.Right) }
+func (c OrCondition) String() string  { return fmt.Sprintf("(%s OR %s)", c.Left, c.Right) }
+func (c NotCondition) String() string { return fmt.Sprintf("NOT %s", c.Operand) }
+
+func (c TimeCondition) String() string {
+	if c.Op == "BETWEEN" {
+		return fmt.Sprintf("ts BETWEEN %s AND %s", c.Low, c.High)
+	}
+	return fmt.Sprintf("ts %s %s", c.Op, c.Low)
+}
+
+func (c DistanceCondition) String() string {
+	return fmt.Sprintf("%s(embedding, %s) %s %s", c.Metric, c.Query, c.Op, c.Threshold)
+}
+
+func (o Ordering) String() string {
+	dir := "ASC"
+	if o.Desc {
+		dir = "DESC"
+	}
+	if o.Timestamp {
+		return "ts " + dir
+	}
+	return fmt.Sprintf("%s(embedding, %s) %s", o.Metric, o.Query, dir)
+}
+
+func (v Value) String() string {
+	switch {
+	case v.Param != "":
+		return "$" + v.Param
+	case v.Vector != nil:
+		return fmt.Sprintf("[%d floats]", len(v.Vector))
+	default:
+		return v.Number
+	}
+}
+
+// ParseQuery parses the text of a query without running it
+func ParseQuery(query string) (*Statement, error) {
+	tokens, err := lex(query)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	stmt, err := p.statement()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokenEOF {
+		return nil, p.errorf(tok, "end of query")
+	}
+	return stmt, nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokenEOF {
+		p.pos++
+	}
+	return tok
+}
+
+// accept consumes the next token if it is the given keyword or punctuation
+func (p *parser) accept(text string) bool {
+	if tok := p.peek(); (tok.kind == tokenKeyword || tok.kind == tokenPunct) && tok.text == text {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(text string) error {
+	if !p.accept(text) {
+		return p.errorf(p.peek(), text)
+	}
+	return nil
+}
+
+func (p *parser) errorf(tok token, want string) error {
+	return fmt.Errorf("syntax error at %d: expected %s, found %s", tok.pos, want, tok)
+}
+
+// acceptIdent consumes the next token if it is the identifier name, ignoring case
+func (p *parser) acceptIdent(name string) bool {
+	if tok := p.peek(); tok.kind == tokenIdent && strings.EqualFold(tok.text, name) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) ident() (string, error) {
+	tok := p.next()
+	if tok.kind != tokenIdent {
+		return "", p.errorf(tok, "a name")
+	}
+	return tok.text, nil
+}
+
+func (p *parser) statement() (*Statement, error) {
+	stmt := &Statement{Explain: p.accept("EXPLAIN")}
+	if err := p.expect("SELECT"); err != nil {
+		return nil, err
+	}
+	if err := p.items(stmt); err != nil {
+		return nil, err
+	}
+	if err := p.expect("FROM"); err != nil {
+		return nil, err
+	}
+	var err error
+	if stmt.Table, err = p.ident(); err != nil {
+		return nil, err
+	}
+	if p.accept(".") {
+		if stmt.Column, err = p.ident(); err != nil {
+			return nil, err
+		}
+	}
+	if p.accept("WHERE") {
+		if stmt.Where, err = p.or(); err != nil {
+			return nil, err
+		}
+	}
+	if p.accept("ORDER") {
+		if err := p.expect("BY"); err != nil {
+			return nil, err
+		}
+		if stmt.Order, err = p.ordering(); err != nil {
+			return nil, err
+		}
+	}
+	if p.accept("LIMIT") {
+		tok := p.next()
+		limit, err := strconv.Atoi(tok.text)
+		if tok.kind != tokenNumber || err != nil || limit <= 0 {
+			return nil, p.errorf(tok, "a positive limit")
+		}
+		stmt.Limit = limit
+	}
+	return stmt, nil
+}
+
+func (p *parser) items(stmt *Statement) error {
+	if p.accept("*") {
+		stmt.Items = nil // expanded by the executor, which knows the source
+		return nil
+	}
+	for {
+		item, err := p.item()
+		if err != nil {
+			return err
+		}
+		stmt.Items = append(stmt.Items, item)
+		if !p.accept(",") {
+			return nil
+		}
+	}
+}
+
+func (p *parser) item() (SelectItem, error) {
+	tok := p.next()
+	if tok.kind != tokenIdent {
+		return SelectItem{}, p.errorf(tok, "a selected value")
+	}
+	switch name := strings.ToUpper(tok.text); name {
+	case "IDX":
+		return SelectItem{Kind: ItemIndex}, nil
+	case "TS":
+		return SelectItem{Kind: ItemTimestamp}, nil
+	case "SCORE":
+		return SelectItem{Kind: ItemScore}, nil
+	case "EMBEDDING":
+		return SelectItem{Kind: ItemEmbedding}, nil
+	case "COLUMN":
+		return SelectItem{Kind: ItemColumn}, nil
+	case "SUM", "AVG", "PROD", "COUNT":
+		if err := p.expect("("); err != nil {
+			return SelectItem{}, err
+		}
+		// only COUNT takes *, every aggregate takes embedding
+		star := name == "COUNT" && p.accept("*")
+		if !star && !p.acceptIdent("embedding") {
+			return SelectItem{}, p.errorf(p.peek(), "embedding")
+		}
+		if err := p.expect(")"); err != nil {
+			return SelectItem{}, err
+		}
+		return SelectItem{Kind: ItemAggregate, Aggregate: name}, nil
+	default:
+		return SelectItem{}, p.errorf(tok, "idx, ts, score, embedding, column or an aggregate")
+	}
+}
+
+func (p *parser) or() (Condition, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("OR") {
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		left = OrCondition{Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) and() (Condition, error) {
+	left, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("AND") {
+		right, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		left = AndCondition{Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) not() (Condition, error) {
+	if p.accept("NOT") {
+		operand, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		return NotCondition{Operand: operand}, nil
+	}
+	if p.accept("(") {
+		cond, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		return cond, p.expect(")")
+	}
+	return p.predicate()
+}
+
+func (p *parser) predicate() (Condition, error) {
+	if p.acceptIdent("ts") {
+		if p.accept("BETWEEN") {
+			low, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("AND"); err != nil {
+				return nil, err
+			}
+			high, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			return TimeCondition{Op: "BETWEEN", Low: low, High: high}, nil
+		}
+		op, err := p.comparison()
+		if err != nil {
+			return nil, err
+		}
+		low, err := p.value()
+		return TimeCondition{Op: op, Low: low}, err
+	}
+	metric, query, err := p.distance()
+	if err != nil {
+		return nil, err
+	}
+	op, err := p.comparison()
+	if err != nil {
+		return nil, err
+	}
+	threshold, err := p.value()
+	return DistanceCondition{Metric: metric, Query: query, Op: op, Threshold: threshold}, err
+}
+
+func (p *parser) comparison() (string, error) {
+	tok := p.next()
+	switch tok.text {
+	case "=", "!=", "<", "<=", ">", ">=":
+		if tok.kind == tokenPunct {
+			return tok.text, nil
+		}
+	}
+	return "", p.errorf(tok, "a comparison")
+}
+
+// distance parses metric(embedding, value), the arguments may come in either order
+func (p *parser) distance() (Metric, Value, error) {
+	tok := p.next()
+	metric, err := ParseMetric(tok.text)
+	if tok.kind != tokenIdent || err != nil {
+		return 0, Value{}, p.errorf(tok, "ts or a distance function")
+	}
+	if err := p.expect("("); err != nil {
+		return 0, Value{}, err
+	}
+	var query Value
+	if p.acceptIdent("embedding") {
+		if err := p.expect(","); err != nil {
+			return 0, Value{}, err
+		}
+		if query, err = p.value(); err != nil {
+			return 0, Value{}, err
+		}
+	} else {
+		if query, err = p.value(); err != nil {
+			return 0, Value{}, err
+		}
+		if err := p.expect(","); err != nil {
+			return 0, Value{}, err
+		}
+		if !p.acceptIdent("embedding") {
+			return 0, Value{}, p.errorf(p.peek(), "embedding")
+		}
+	}
+	return metric, query, p.expect(")")
+}
+
+func (p *parser) ordering() (*Ordering, error) {
+	order := &Ordering{}
+	if p.acceptIdent("ts") {
+		order.Timestamp = true
+	} else {
+		var err error
+		if order.Metric, order.Query, err = p.distance(); err != nil {
+			return nil, err
+		}
+		order.Desc = order.Metric.HigherIsBetter()
+	}
+	switch {
+	case p.accept("ASC"):
+		order.Desc, order.Explicit = false, true
+	case p.accept("DESC"):
+		order.Desc, order.Explicit = true, true
+	}
+	return order, nil
+}
+
+func (p *parser) value() (Value, error) {
+	if tok := p.peek(); tok.kind == tokenParam {
+		p.pos++
+		return Value{Param: tok.text}, nil
+	}
+	if !p.accept("[") {
+		return p.number()
+	}
+	vec := []float32{}
+	for {
+		num, err := p.number()
+		if err != nil {
+			return Value{}, err
+		}
+		f, err := strconv.ParseFloat(num.Number, 32)
+		if err != nil {
+			return Value{}, fmt.Errorf("bad vector element %s: %w", num.Number, err)
+		}
+		vec = append(vec, float32(f))
+		if !p.accept(",") {
+			break
+		}
+	}
+	return Value{Vector: vec}, p.expect("]")
+}
+
+func (p *parser) number() (Value, error) {
+	sign := ""
+	if p.accept("-") {
+		sign = "-"
+	}
+	tok := p.next()
+	if tok.kind != tokenNumber {
+		return Value{}, p.errorf(tok, "a number")
+	}
+	return Value{Number: sign + tok.text}, nil
+}
@@ -0,0 +1,122 @@
+package db
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func TestParseQueryConditions(t *testing.T) {
+	cases := []struct {
+		where string
+		want  string
+	}{
+		{"ts > 1 OR ts < 0 AND NOT ts = 5", "(ts > 1 OR (ts < 0 AND NOT ts = 5))"},
+		{"NOT ts > 1 AND ts < 3", "(NOT ts > 1 AND ts < 3)"},
+		{"NOT (ts > 1 AND ts < 3)", "NOT (ts > 1 AND ts < 3)"},
+		{"NOT NOT ts != 2", "NOT NOT ts != 2"},
+		{"ts = 1 OR ts = 2 OR ts = 3", "((ts = 1 OR ts = 2) OR ts = 3)"},
+		{"ts = 1 AND ts = 2 AND ts = 3", "((ts = 1 AND ts = 2) AND ts = 3)"},
+		{"(ts = 1 OR ts = 2) AND ts = 3", "((ts = 1 OR ts = 2) AND ts = 3)"},
+		// the AND closing a BETWEEN is not a conjunction
+		{"ts BETWEEN 1 AND 5 AND ts != 3", "(ts BETWEEN 1 AND 5 AND ts != 3)"},
+		{"ts between -5 and $hi or ts >= 9", "(ts BETWEEN -5 AND $hi OR ts >= 9)"},
+		{"l2(embedding, [1, 2.5]) <= 0.5", "l2(embedding, [2 floats]) <= 0.5"},
+		{"COSINE($q, embedding) > 0.9 AND ts < 1e3", "(cosine(embedding, $q) > 0.9 AND ts < 1e3)"},
+	}
+	for _, c := range cases {
+		t.Run(c.where, func(t *testing.T) {
+			stmt, err := ParseQuery("SELECT * FROM t WHERE " + c.where)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := stmt.Where.String(); got != c.want {
+				t.Fatalf("parsed as %s, want %s", got, c.want)
+			}
+		})
+	}
+}
+
+func TestParseQueryStatement(t *testing.T) {
+	stmt, err := ParseQuery(`explain select idx, ts, score from "clip-01".c order by dot(embedding, $q) asc limit 5`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	items := []SelectItem{{Kind: ItemIndex}, {Kind: ItemTimestamp}, {Kind: ItemScore}}
+	if !stmt.Explain || stmt.Table != "clip-01" || stmt.Column != "c" || stmt.Limit != 5 || !slices.Equal(stmt.Items, items) {
+		t.Fatalf("statement is %+v", stmt)
+	}
+	if o := stmt.Order; o == nil || o.Metric != DotProduct || o.Query.Param != "q" || o.Desc || !o.Explicit || o.BestFirst() {
+		t.Fatalf("ordering is %+v", stmt.Order)
+	}
+
+	// distances default to best first whichever way their metric points
+	for _, c := range []struct {
+		metric string
+		desc   bool
+	}{{"l2", false}, {"cosine", true}, {"dot", true}, {"hamming", false}} {
+		stmt, err := ParseQuery(fmt.Sprintf("SELECT * FROM t ORDER BY %s(embedding, [1])", c.metric))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stmt.Items != nil || stmt.Order.Desc != c.desc || stmt.Order.Explicit || !stmt.Order.BestFirst() {
+			t.Fatalf("%s ordering is %+v", c.metric, stmt.Order)
+		}
+	}
+
+	stmt, err = ParseQuery("SELECT count(*), sum(embedding), avg(EMBEDDING), prod(embedding) FROM t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, item := range stmt.Items {
+		names = append(names, item.String())
+	}
+	if want := []string{"count(*)", "sum(embedding)", "avg(embedding)", "prod(embedding)"}; !slices.Equal(names, want) {
+		t.Fatalf("aggregates are %v", names)
+	}
+}
+
+func TestParseQueryRejectsMalformed(t *testing.T) {
+	for _, query := range []string{
+		"",
+		"SELECT",
+		"SELECT * FROM",
+		"SELECT idx FROM t WHERE",
+		"SELECT * t",
+		"SELECT ts, FROM t",
+		"SELECT name FROM t",
+		"SELECT sum(*) FROM t",
+		"SELECT count(ts) FROM t",
+		"SELECT * FROM t.",
+		"SELECT * FROM t WHERE ts BETWEEN 1 5",
+		"SELECT * FROM t WHERE ts BETWEEN 1 AND",
+		"SELECT * FROM t WHERE (ts > 1",
+		"SELECT * FROM t WHERE ts > 1)",
+		"SELECT * FROM t WHERE ts > 1 OR",
+		"SELECT * FROM t WHERE NOT",
+		"SELECT * FROM t WHERE ts 1",
+		"SELECT * FROM t WHERE ts == 1",
+		"SELECT * FROM t WHERE ts > #",
+		"SELECT * FROM t WHERE ts > $",
+		"SELECT * FROM t WHERE euclid(embedding, [1]) < 1",
+		"SELECT * FROM t WHERE l2(embedding [1]) < 1",
+		"SELECT * FROM t WHERE l2([1], [1]) < 1",
+		"SELECT * FROM t WHERE l2(embedding, [1,]) < 1",
+		"SELECT * FROM t WHERE l2(embedding, []) < 1",
+		"SELECT * FROM t WHERE l2(embedding, [1]) < [1]x",
+		"SELECT * FROM t ORDER ts",
+		"SELECT * FROM t ORDER BY idx",
+		"SELECT * FROM t ORDER BY ts UP",
+		"SELECT * FROM t LIMIT 0",
+		"SELECT * FROM t LIMIT -1",
+		"SELECT * FROM t LIMIT 2.5",
+		"SELECT * FROM t LIMIT $n",
+		"SELECT * FROM t LIMIT 1 LIMIT 2",
+		`SELECT * FROM "t`,
+	} {
+		if stmt, err := ParseQuery(query); err == nil {
+			t.Errorf("%q parsed as %+v", query, stmt)
+		}
+	}
+}
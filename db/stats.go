@@ -0,0 +1,117 @@
+package db
+
+import (
+	"log/slog"
+	"sync"
+)
+
+/*
+Zone maps keep the smallest and largest timestamp of every chunk so range
+predicates can skip chunks without reading them and the planner can estimate
+how many entries a range admits. They live in memory only, built on first use
+and extended as entries are appended. Entries never change once written, so a
+zone only ever grows and the zones of sealed chunks are never read again.
+*/
+
+// zoneMap summarises the timestamps of one chunk
+type zoneMap struct {
+	chunk int64 // offset of the chunk
+	first int64 // column index of the chunk's first entry
+	count int64 // entries summarised
+	minTs uint64
+	maxTs uint64
+}
+
+// columnStats caches a column's zone maps, shared between the live column and its frozen copies
+type columnStats struct {
+	mu    sync.Mutex
+	zones []zoneMap
+}
+
+// ColumnStats summarises a column for query planning
+type ColumnStats struct {
+	Entries      int64       `json:"entries"`
+	Chunks       int         `json:"chunks"`
+	MinTimestamp uint64      `json:"min_timestamp"`
+	MaxTimestamp uint64      `json:"max_timestamp"`
+	Indexes      []IndexKind `json:"indexes"`
+}
+
+// Stats returns the entry count, timestamp bounds and indexes of the column's view
+func (column *Column) Stats() (ColumnStats, error) {
+	zones, err := column.zoneMaps()
+	if err != nil {
+		return ColumnStats{}, err
+	}
+	stats := ColumnStats{Chunks: len(zones), Indexes: column.Indexes()}
+	for i, zone := range zones {
+		stats.Entries += zone.count
+		if i == 0 || zone.minTs < stats.MinTimestamp {
+			stats.MinTimestamp = zone.minTs
+		}
+		stats.MaxTimestamp = max(stats.MaxTimestamp, zone.maxTs)
+	}
+	return stats, nil
+}
+
+// zoneMaps returns a zone for every chunk in the column's view holding entries, counts
+// limited to the view. A frozen view can see fewer entries than the cached zone
+// summarises, its bounds then cover more than the view does, which is still safe for pruning
+func (column *Column) zoneMaps() ([]zoneMap, error) {
+	view := column.view()
+	r, unpin := column.file.Pin()
+	defer unpin()
+	entrySize := 8 + (view.meta.vectorLength * 4)
+
+	stats := column.stats
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	zones := []zoneMap{}
+	first := int64(0)
+	var err error
+	view.chunks(r, func(chunk int64, header ChunkHeader, count int64) bool {
+		i := len(zones)
+		if i == len(stats.zones) {
+			stats.zones = append(stats.zones, zoneMap{chunk: chunk, first: first})
+		}
+		zone := &stats.zones[i]
+		if zone.count < count {
+			var data []byte
+			data, err = r.entries(chunk, header, count*entrySize)
+			if err != nil {
+				slog.Error("Could not read chunk for zone map", "column", view.meta.name.String(), "chunk", chunk, "error", err)
+				return false
+			}
+			for j := zone.count; j < count; j++ {
+				ts := ByteOrder.Uint64(data[j*entrySize:])
+				if j == 0 || ts < zone.minTs {
+					zone.minTs = ts
+				}
+				zone.maxTs = max(zone.maxTs, ts)
+			}
+			zone.count = count
+		}
+		visible := *zone
+		visible.count = count
+		zones = append(zones, visible)
+		first += count
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return zones, nil
+}
+
+// overlap returns the fraction of the zone's entries estimated to fall in [start, end)
+// assuming timestamps are spread evenly between its bounds, and whether all of them do
+func (zone zoneMap) overlap(start, end uint64) (float64, bool) {
+	if zone.count == 0 || end <= zone.minTs || start > zone.maxTs {
+		return 0, false
+	}
+	if start <= zone.minTs && end > zone.maxTs {
+		return 1, true
+	}
+	lo, hi := max(start, zone.minTs), min(end-1, zone.maxTs)
+	return (float64(hi-lo) + 1) / (float64(zone.maxTs-zone.minTs) + 1), false
+}
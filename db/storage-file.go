@@ -0,0 +1,77 @@
+package db
+
+import (
+	"log/slog"
+	"os"
+)
+
+// FileStorage accesses a .ken file with pread/pwrite instead of mapping it
+// Prefer it on filesystems where mmap is a bad fit (network mounts, FUSE, 32-bit hosts).
+// Scans copy every chunk they touch out of the file, so it trades throughput for predictability
+type FileStorage struct {
+	path string
+	f    *os.File
+	size int64
+}
+
+// OpenFileStorage opens or creates a file backend of at least initialSize bytes
+func OpenFileStorage(path string, initialSize int64) (*FileStorage, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	size := info.Size()
+	if size == 0 {
+		if err := f.Truncate(initialSize); err != nil {
+			f.Close()
+			return nil, err
+		}
+		size = initialSize
+	}
+	return &FileStorage{path: path, f: f, size: size}, nil
+}
+
+func (s *FileStorage) Size() int64 {
+	return s.size
+}
+
+func (s *FileStorage) ReadAt(p []byte, off int64) (int, error) {
+	return s.f.ReadAt(p, off)
+}
+
+func (s *FileStorage) WriteAt(p []byte, off int64) (int, error) {
+	return s.f.WriteAt(p, off)
+}
+
+// Grow extends the file, the new range reads back as zeros
+func (s *FileStorage) Grow(additionalBytes int64) error {
+	newSize := s.size + additionalBytes
+	if err := s.f.Truncate(newSize); err != nil {
+		return err
+	}
+	s.size = newSize
+	slog.Info("File grown", "path", s.path, "newSize", newSize)
+	return nil
+}
+
+// PunchHole releases the disk blocks behind the range
+func (s *FileStorage) PunchHole(off int64, n int64) error {
+	return punchHole(s.f, off, n)
+}
+
+func (s *FileStorage) Flush() error {
+	return s.f.Sync()
+}
+
+func (s *FileStorage) Close() error {
+	if err := s.f.Sync(); err != nil {
+		s.f.Close()
+		return err
+	}
+	return s.f.Close()
+}
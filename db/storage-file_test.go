@@ -0,0 +1,33 @@
+package db
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestFileStorageRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.ken")
+	file, err := OpenFileStorage(path, InitialFileSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fillRoundTrip(t, conn)
+	if err := conn.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err = OpenFileStorage(path, InitialFileSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	checkRoundTrip(t, reopened)
+}
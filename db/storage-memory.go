@@ -0,0 +1,70 @@
+package db
+
+import (
+	"fmt"
+	"sync"
+)
+
+// MemoryStorage keeps the whole database in a Go byte slice
+// Useful for tests and ephemeral workloads, nothing survives Close
+type MemoryStorage struct {
+	mu  sync.RWMutex // guards buf against Grow, byte ranges are coordinated by the db
+	buf []byte
+}
+
+// NewMemoryStorage allocates an in-memory backend of initialSize bytes
+func NewMemoryStorage(initialSize int64) *MemoryStorage {
+	return &MemoryStorage{buf: make([]byte, initialSize)}
+}
+
+// Bytes returns the backing slice, valid until the next Grow
+func (m *MemoryStorage) Bytes() []byte {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.buf
+}
+
+func (m *MemoryStorage) Size() int64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return int64(len(m.buf))
+}
+
+func (m *MemoryStorage) ReadAt(p []byte, off int64) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if off < 0 || off+int64(len(p)) > int64(len(m.buf)) {
+		return 0, fmt.Errorf("read of %d bytes at %d outside memory storage", len(p), off)
+	}
+	return copy(p, m.buf[off:]), nil
+}
+
+func (m *MemoryStorage) WriteAt(p []byte, off int64) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if off < 0 || off+int64(len(p)) > int64(len(m.buf)) {
+		return 0, fmt.Errorf("write of %d bytes at %d outside memory storage", len(p), off)
+	}
+	return copy(m.buf[off:], p), nil
+}
+
+// Grow reallocates the buffer, previously returned Bytes slices keep the old contents
+func (m *MemoryStorage) Grow(additionalBytes int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	grown := make([]byte, int64(len(m.buf))+additionalBytes)
+	copy(grown, m.buf)
+	m.buf = grown
+	return nil
+}
+
+func (m *MemoryStorage) Flush() error {
+	return nil
+}
+
+func (m *MemoryStorage) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.buf = nil
+	return nil
+}
@@ -0,0 +1,82 @@
+package db
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+// fillRoundTrip writes a table spread over enough columns to grow the storage
+func fillRoundTrip(t *testing.T, conn *DB) {
+	t.Helper()
+	tbl, err := conn.AddTable("t", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 6; i++ {
+		col, err := tbl.AddColumn(fmt.Sprintf("c%d", i), int64(i+1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		appendTestVectors(t, col, 100*(i+1))
+	}
+	if err := conn.SetProperty("owner", "tests"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// checkRoundTrip verifies a database written by fillRoundTrip
+func checkRoundTrip(t *testing.T, conn *DB) {
+	t.Helper()
+	tbl, ok := conn.GetTableByName("t")
+	if !ok {
+		t.Fatal("table t is missing")
+	}
+	for i := 0; i < 6; i++ {
+		col, ok := tbl.GetColumnByName(fmt.Sprintf("c%d", i))
+		if !ok {
+			t.Fatalf("column c%d is missing", i)
+		}
+		if col.Length() != 100*(i+1) {
+			t.Fatalf("column c%d has %d entries, want %d", i, col.Length(), 100*(i+1))
+		}
+		vecs, err := fetchRange(col, 0, int64(col.Length()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for j, vec := range vecs {
+			if vec.timestamp != uint64(j) || len(vec.features) != i+1 || vec.features[i] != float32(j) {
+				t.Fatalf("column c%d entry %d is %d %v", i, j, vec.timestamp, vec.features)
+			}
+		}
+	}
+	if owner, _ := conn.GetProperty("owner"); owner != "tests" {
+		t.Fatalf("property owner is %q, want tests", owner)
+	}
+}
+
+func TestMemoryStorageRoundTrip(t *testing.T) {
+	mem := NewMemoryStorage(InitialFileSize)
+	conn, err := Open(mem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fillRoundTrip(t, conn)
+	if mem.Size() <= InitialFileSize {
+		t.Fatalf("storage did not grow past %d bytes", int64(InitialFileSize))
+	}
+	checkRoundTrip(t, conn)
+
+	// Close drops the buffer, so reopen a copy taken while the database is quiet
+	copied := NewMemoryStorage(0)
+	copied.buf = bytes.Clone(mem.Bytes())
+	if err := conn.Close(); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := Open(copied)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	checkRoundTrip(t, reopened)
+}
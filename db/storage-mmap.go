@@ -0,0 +1,126 @@
+package db
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/edsrzf/mmap-go"
+)
+
+// MMapFile wraps a memory-mapped file with its path for easy resizing
+// It is the default Storage and exposes its mapping directly for zero-copy scans
+type MMapFile struct {
+	path   string
+	mapped mmap.MMap
+}
+
+// Bytes returns the underlying byte slice
+// The slice is only valid until the next Grow or Close
+func (m *MMapFile) Bytes() []byte {
+	return m.mapped
+}
+
+func (m *MMapFile) Size() int64 {
+	return int64(len(m.mapped))
+}
+
+func (m *MMapFile) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 || off+int64(len(p)) > m.Size() {
+		return 0, fmt.Errorf("read of %d bytes at %d outside mapping", len(p), off)
+	}
+	return copy(p, m.mapped[off:]), nil
+}
+
+func (m *MMapFile) WriteAt(p []byte, off int64) (int, error) {
+	if off < 0 || off+int64(len(p)) > m.Size() {
+		return 0, fmt.Errorf("write of %d bytes at %d outside mapping", len(p), off)
+	}
+	return copy(m.mapped[off:], p), nil
+}
+
+// Grow increases the file size by additionalBytes and remaps
+func (m *MMapFile) Grow(additionalBytes int64) error {
+	m.mapped.Flush()
+	m.mapped.Unmap()
+
+	f, err := os.OpenFile(m.path, os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	newSize := info.Size() + additionalBytes
+	if err := f.Truncate(newSize); err != nil {
+		return err
+	}
+
+	m.mapped, err = mmap.Map(f, mmap.RDWR, 0)
+	if err != nil {
+		return err
+	}
+	slog.Info("File grown", "path", m.path, "newSize", newSize)
+	return nil
+}
+
+// PunchHole releases the disk blocks behind the range, the mapping reads zeros afterwards
+func (m *MMapFile) PunchHole(off int64, n int64) error {
+	f, err := os.OpenFile(m.path, os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return punchHole(f, off, n)
+}
+
+// FlushRange writes back the pages covering [off, off+n), off must be page aligned
+func (m *MMapFile) FlushRange(off int64, n int64) error {
+	if err := msync(m.mapped[off : off+n]); err != nil {
+		return m.mapped.Flush()
+	}
+	return nil
+}
+
+// Flush writes dirty pages back to the file
+func (m *MMapFile) Flush() error {
+	return m.mapped.Flush()
+}
+
+// Close flushes and unmaps the file
+func (m *MMapFile) Close() error {
+	defer m.mapped.Unmap()
+	return m.mapped.Flush()
+}
+
+// OpenMMapFile opens or creates a memory-mapped file
+func OpenMMapFile(path string, initialSize int64) (*MMapFile, error) {
+	_, err := os.Stat(path)
+	var f *os.File
+	var fileErr error
+	if os.IsNotExist(err) {
+		f, fileErr = os.Create(path)
+		if fileErr == nil {
+			f.Truncate(initialSize)
+		}
+	} else {
+		f, fileErr = os.OpenFile(path, os.O_RDWR, 0644)
+	}
+	if fileErr != nil {
+		return nil, fileErr
+	}
+	defer f.Close()
+
+	mapped, err := mmap.Map(f, mmap.RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MMapFile{
+		path:   path,
+		mapped: mapped,
+	}, nil
+}
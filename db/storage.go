@@ -0,0 +1,263 @@
+package db
+
+import (
+	"bytes"
+	"log/slog"
+	"sync"
+)
+
+// Storage is the byte-addressable backend a .ken database lives in
+// Offsets are absolute, Grow extends the addressable range by additionalBytes
+type Storage interface {
+	ReadAt(p []byte, off int64) (int, error)
+	WriteAt(p []byte, off int64) (int, error)
+	Size() int64
+	Grow(additionalBytes int64) error
+	Flush() error
+	Close() error
+}
+
+// MappedStorage is implemented by backends that can expose their bytes directly
+// Scans over a mapped backend read vectors in place instead of copying them out
+type MappedStorage interface {
+	Storage
+	Bytes() []byte
+}
+
+// store wraps a Storage with the concurrency discipline the db relies on
+// Readers pin the backend while they hold slices into it so Grow can never remap
+// bytes out from under them. Writers serialize on writeMu (single-writer discipline)
+type store struct {
+	backend Storage
+
+	mu       sync.Mutex
+	unpinned *sync.Cond // signalled when pins drops to zero or the last waiter is through
+	pins     int
+	waiting  int // Grow, Close and exclusive calls waiting for the pins to drain
+
+	writeMu sync.Mutex
+
+	tier *tiering // nil unless a blob store is configured, guarded by mu
+
+	dirty        *dirtySet
+	syncMu       sync.Mutex // held across a take and its flush, see syncDirty
+	durabilityMu sync.Mutex
+	durability   Durability
+	flusher      *flusher
+}
+
+func newStore(backend Storage) *store {
+	s := &store{backend: backend, dirty: &dirtySet{}}
+	s.unpinned = sync.NewCond(&s.mu)
+	return s
+}
+
+// Pin returns a view of the backend that stays valid until unpin is called
+// Pins are reentrant, so a goroutine holding a pin may take another one
+func (s *store) Pin() (r region, unpin func()) {
+	s.mu.Lock()
+	s.pins++
+	r = s.regionLocked()
+	s.mu.Unlock()
+
+	var once sync.Once
+	return r, func() {
+		once.Do(func() {
+			s.mu.Lock()
+			s.pins--
+			if s.pins == 0 {
+				s.unpinned.Broadcast()
+			}
+			s.mu.Unlock()
+		})
+	}
+}
+
+// helper to build a region, caller must hold mu
+func (s *store) regionLocked() region {
+	r := region{storage: s.backend, tier: s.tier, dirty: s.dirty}
+	if mapped, ok := s.backend.(MappedStorage); ok {
+		r.mapped = mapped.Bytes()
+	}
+	return r
+}
+
+// exclusive runs fn once no pins are outstanding, with new pins held off until it returns
+// Used for changes readers must never observe half way, like releasing chunk bytes
+func (s *store) exclusive(fn func(r region) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.waitUnpinned()
+	return fn(s.regionLocked())
+}
+
+// lockWriter acquires the single writer lock, all appends and catalog mutations go through it
+func (s *store) lockWriter() func() {
+	s.writeMu.Lock()
+	return s.writeMu.Unlock
+}
+
+// waitUnpinned blocks until no pins are outstanding, caller must hold mu
+func (s *store) waitUnpinned() {
+	s.waiting++
+	for s.pins > 0 {
+		s.unpinned.Wait()
+	}
+	s.waiting--
+	if s.waiting == 0 {
+		s.unpinned.Broadcast()
+	}
+}
+
+// repin trades a pin for a fresh one, first letting through every Grow, Close or
+// exclusive call waiting for the pins to drain. Slices from the old region are invalid
+// afterwards. The caller must hold no other pin or the waiters can never run
+func (s *store) repin(unpin func()) (region, func()) {
+	unpin()
+	s.mu.Lock()
+	for s.waiting > 0 {
+		s.unpinned.Wait()
+	}
+	s.mu.Unlock()
+	return s.Pin()
+}
+
+// Grow extends the backend, blocking until every outstanding pin has been released,
+// so the caller must not hold one
+func (s *store) Grow(additionalBytes int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.waitUnpinned()
+	return s.backend.Grow(additionalBytes)
+}
+
+// Flush persists the backend without waiting for readers
+func (s *store) Flush() error {
+	return s.backend.Flush()
+}
+
+// Close flushes and releases the backend once outstanding pins are released
+func (s *store) Close() error {
+	s.stopFlusher()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.waitUnpinned()
+	return s.backend.Close()
+}
+
+// region is a pinned view of a store
+// Mapped backends are accessed in place, anything else goes through ReadAt/WriteAt
+type region struct {
+	storage Storage
+	mapped  []byte
+	tier    *tiering
+	dirty   *dirtySet
+}
+
+func (r region) size() int64 {
+	if r.mapped != nil {
+		return int64(len(r.mapped))
+	}
+	return r.storage.Size()
+}
+
+// read returns n bytes at off
+// For mapped backends this aliases the storage, so never modify the result
+func (r region) read(off int64, n int64) ([]byte, error) {
+	if r.mapped != nil {
+		return r.mapped[off : off+n], nil
+	}
+	buf := make([]byte, n)
+	if _, err := r.storage.ReadAt(buf, off); err != nil {
+		slog.Error("Storage read failed", "offset", off, "length", n, "error", err)
+		return nil, err
+	}
+	return buf, nil
+}
+
+// slice is read for the fixed size headers and catalog records, a failed read
+// comes back zeroed and is only logged. Entry data goes through read
+func (r region) slice(off int64, n int64) []byte {
+	buf, err := r.read(off, n)
+	if err != nil {
+		return make([]byte, n)
+	}
+	return buf
+}
+
+// write copies p into the storage at off and marks the range dirty
+func (r region) write(off int64, p []byte) error {
+	r.dirty.add(off, int64(len(p)))
+	if r.mapped != nil {
+		copy(r.mapped[off:], p)
+		return nil
+	}
+	if _, err := r.storage.WriteAt(p, off); err != nil {
+		slog.Error("Storage write failed", "offset", off, "length", len(p), "error", err)
+		return err
+	}
+	return nil
+}
+
+func (r region) uint64(off int64) uint64 {
+	return ByteOrder.Uint64(r.slice(off, 8))
+}
+
+func (r region) putUint64(off int64, v uint64) error {
+	var buf [8]byte
+	ByteOrder.PutUint64(buf[:], v)
+	return r.write(off, buf[:])
+}
+
+func (r region) chunkHeader(off int64) ChunkHeader {
+	return ReadChunkHeader(r.slice(off, ChunkHeaderSize), 0)
+}
+
+func (r region) writeChunkHeader(off int64, header ChunkHeader) error {
+	var buf [ChunkHeaderSize]byte
+	header.WriteTo(buf[:], 0)
+	return r.write(off, buf[:])
+}
+
+func (r region) metadataCursor() int64 {
+	return GetMetadataCursorPos(r.slice(0, HeaderSize))
+}
+
+func (r region) dataCursor() int64 {
+	return GetDataCursorPos(r.slice(0, HeaderSize))
+}
+
+// setMetadataCursor and setDataCursor follow the Set*CursorPos direction rules
+func (r region) setMetadataCursor(v int64, dir Direction) error {
+	header := bytes.Clone(r.slice(0, HeaderSize))
+	SetMetadataCursorPos(header, v, dir)
+	return r.write(0, header[:8])
+}
+
+func (r region) setDataCursor(v int64, dir Direction) error {
+	header := bytes.Clone(r.slice(0, HeaderSize))
+	SetDataCursorPos(header, v, dir)
+	return r.write(8, header[8:16])
+}
+
+func (r region) tableMetadata(off int64) TableMetadata {
+	meta := ReadTableMetadata(r.slice(off, TableMetadataSize), 0)
+	meta.offset = off
+	return meta
+}
+
+func (r region) writeTableMetadata(meta TableMetadata) error {
+	buf := make([]byte, TableMetadataSize)
+	meta.encode(buf)
+	return r.write(meta.offset, buf)
+}
+
+func (r region) columnMetadata(off int64) ColumnMetadata {
+	return ReadColumnMetadata(r.slice(off, ColumnMetadataSize), 0)
+}
+
+func (r region) writeColumnMetadata(meta ColumnMetadata) error {
+	buf := make([]byte, ColumnMetadataSize)
+	meta.encode(buf)
+	return r.write(meta.offset, buf)
+}
@@ -6,20 +6,25 @@ import (
 )
 
 func (tbl *Table) AddColumn(colName string, vectorLength int64) (*Column, error) {
+	unlock := tbl.file.lockWriter()
+	defer unlock()
+
+	tbl.mu.RLock()
 	currColCount := int64(len(tbl.columns))
+	tbl.mu.RUnlock()
 	if currColCount >= tbl.meta.numColumns {
 		slog.Error("Add column error", "Table", tbl.meta.name.String(), "Max columns", tbl.meta.numColumns)
 		return nil, fmt.Errorf("Pain")
 	}
-	b := tbl.file.Bytes()
-
-	// Check if we need to grow the file for a new chunk
-	firstChunkOffset := GetDataCursorPos(b)
-	if firstChunkOffset+ChunkSize > int64(len(b)) {
-		if err := tbl.file.Grow(ChunkSize * 4); err != nil {
-			return nil, err
-		}
-		b = tbl.file.Bytes() // refresh after grow
+	firstChunkOffset, err := tbl.conn.allocChunk()
+	if err != nil {
+		return nil, err
+	}
+	r, unpin := tbl.file.Pin()
+	defer unpin()
+	// a chunk off the free list still holds the header of the blob it was part of
+	if err := r.writeChunkHeader(firstChunkOffset, ChunkHeader{}); err != nil {
+		return nil, err
 	}
 
 	pos := tbl.meta.offset + TableMetadataSize + (ColumnMetadataSize * currColCount)
@@ -30,23 +35,28 @@ func (tbl *Table) AddColumn(colName string, vectorLength int64) (*Column, error)
 		firstChunkOffset: firstChunkOffset,
 		offset:           pos,
 	}
-	meta.WriteTo(b)
-
-	newColumn := Column{
-		meta: meta,
-		file: tbl.file,
+	if err := r.writeColumnMetadata(meta); err != nil {
+		return nil, err
 	}
 
-	tbl.columns = append(tbl.columns, &newColumn)
+	newColumn := &Column{
+		meta:    meta,
+		file:    tbl.file,
+		conn:    tbl.conn,
+		indexes: newIndexSet(),
+		stats:   &columnStats{},
+	}
 
-	// move data cursor 64MB forward
-	nextChunkPos := meta.firstChunkOffset + ChunkSize
-	SetDataCursorPos(b, nextChunkPos, RIGHT)
+	tbl.mu.Lock()
+	tbl.columns = append(tbl.columns, newColumn)
+	tbl.mu.Unlock()
 
-	return &newColumn, nil
+	return newColumn, tbl.file.commit()
 }
 
 func (tbl *Table) GetColumnByName(name string) (*Column, bool) {
+	tbl.mu.RLock()
+	defer tbl.mu.RUnlock()
 	for _, col := range tbl.columns {
 		if col.meta.name.String() == name {
 			return col, true
@@ -56,6 +66,8 @@ func (tbl *Table) GetColumnByName(name string) (*Column, bool) {
 }
 
 func (tbl *Table) ListColumnNames() []string {
+	tbl.mu.RLock()
+	defer tbl.mu.RUnlock()
 	names := []string{}
 	for _, col := range tbl.columns {
 		names = append(names, col.meta.name.String())
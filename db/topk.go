@@ -0,0 +1,165 @@
+package db
+
+import (
+	"container/heap"
+	"fmt"
+	"log/slog"
+	"runtime"
+	"slices"
+	"sync"
+)
+
+// SearchResult is one ranked entry returned by a nearest-neighbor search
+type SearchResult struct {
+	Index     int64   // position of the entry in the column
+	Timestamp uint64  // timestamp stored with the entry
+	Score     float32 // metric value against the query, see Metric.HigherIsBetter
+}
+
+// scanBatchSize is how many entries a worker scores per job, chunks are split so
+// even a single-chunk column is scanned in parallel
+const scanBatchSize = 1024
+
+// TopK returns the k entries closest to query under metric, best first
+// MetricDefault scores by the schema's metric. It is an exact search: every entry in the column's view is scored
+func (column *Column) TopK(query []float32, k int, metric Metric) ([]SearchResult, error) {
+	return column.TopKFiltered(query, k, metric, nil)
+}
+
+// scanJob is a contiguous run of entries inside one chunk
+type scanJob struct {
+	base  int64  // column index of the first entry
+	data  []byte // entry bytes, aliasing storage for mapped backends
+	count int64
+}
+
+// scanTopK runs a parallel exact scan over the column's view
+// Only entries set in bitmap are scored, a nil bitmap scores everything
+func (column *Column) scanTopK(query []float32, k int, metric Metric, bitmap *Bitmap) ([]SearchResult, error) {
+	if k <= 0 {
+		return nil, fmt.Errorf("k must be positive, got %d", k)
+	}
+	view := column.view()
+	// every worker sizes its heap for k, which can never hold more than the view's entries
+	k = int(min(int64(k), view.meta.numVectors))
+	if bitmap != nil {
+		k = int(min(int64(k), bitmap.Cardinality()))
+	}
+	if k == 0 {
+		return []SearchResult{}, nil
+	}
+	r, unpin := column.file.Pin()
+	defer unpin()
+	vecLen := int(view.meta.vectorLength)
+	entrySize := 8 + (view.meta.vectorLength * 4)
+
+	workers := runtime.GOMAXPROCS(0)
+	jobs := make(chan scanJob, workers)
+	heaps := make([]*resultHeap, workers)
+	var wg sync.WaitGroup
+	for w := range workers {
+		heaps[w] = newResultHeap(k, metric)
+		wg.Add(1)
+		go func(h *resultHeap) {
+			defer wg.Done()
+			for job := range jobs {
+				for i := int64(0); i < job.count; i++ {
+					if !admits(bitmap, job.base+i) {
+						continue
+					}
+					entry := job.data[i*entrySize:]
+					h.offer(SearchResult{
+						Index:     job.base + i,
+						Timestamp: ByteOrder.Uint64(entry),
+						Score:     metric.Score(readVec(entry[8:], vecLen), query),
+					})
+				}
+			}
+		}(heaps[w])
+	}
+
+	// the workers must be done with the chunk bytes before we unpin
+	last := int64(-1)
+	if bitmap != nil {
+		last = bitmap.last()
+	}
+	idx := int64(0)
+	err := view.walk(r, func(chunkPos int64, data []byte, count int64) bool {
+		for start := int64(0); start < count; start += scanBatchSize {
+			n := min(scanBatchSize, count-start)
+			jobs <- scanJob{base: idx + start, data: data[start*entrySize:], count: n}
+		}
+		idx += count
+		// entries past the last bit are never admitted
+		return bitmap == nil || idx <= last
+	})
+	close(jobs)
+	wg.Wait()
+	if err != nil {
+		return nil, err
+	}
+
+	merged := newResultHeap(k, metric)
+	for _, h := range heaps {
+		for _, res := range h.items {
+			merged.offer(res)
+		}
+	}
+	results := merged.sorted()
+	slog.Debug("Top-k scan finished", "column", view.meta.name.String(), "scanned", idx, "returned", len(results))
+	return results, nil
+}
+
+// resultHeap keeps the k best results seen so far with the worst one on top
+type resultHeap struct {
+	k      int
+	metric Metric
+	items  []SearchResult
+}
+
+func newResultHeap(k int, metric Metric) *resultHeap {
+	return &resultHeap{k: k, metric: metric, items: make([]SearchResult, 0, k)}
+}
+
+func (h *resultHeap) Len() int { return len(h.items) }
+
+// the root must be the worst result, so Less inverts the ranking
+func (h *resultHeap) Less(i, j int) bool { return h.metric.Better(h.items[j].Score, h.items[i].Score) }
+
+func (h *resultHeap) Swap(i, j int) { h.items[i], h.items[j] = h.items[j], h.items[i] }
+
+func (h *resultHeap) Push(x any) { h.items = append(h.items, x.(SearchResult)) }
+
+func (h *resultHeap) Pop() any {
+	last := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	return last
+}
+
+// offer adds res if it ranks among the k best
+func (h *resultHeap) offer(res SearchResult) {
+	if len(h.items) < h.k {
+		heap.Push(h, res)
+		return
+	}
+	if h.k > 0 && h.metric.Better(res.Score, h.items[0].Score) {
+		h.items[0] = res
+		heap.Fix(h, 0)
+	}
+}
+
+// sorted returns the results best first, ties broken by index so output is deterministic
+func (h *resultHeap) sorted() []SearchResult {
+	results := slices.Clone(h.items)
+	slices.SortFunc(results, func(a, b SearchResult) int {
+		switch {
+		case h.metric.Better(a.Score, b.Score):
+			return -1
+		case h.metric.Better(b.Score, a.Score):
+			return 1
+		default:
+			return int(a.Index - b.Index)
+		}
+	})
+	return results
+}
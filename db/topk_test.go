@@ -0,0 +1,56 @@
+package db
+
+import (
+	"math"
+	"testing"
+)
+
+func TestTopKBoundsK(t *testing.T) {
+	conn := openTestDB(t)
+	col := newTestColumn(t, conn, "c", 4)
+	appendTestVectors(t, col, 50)
+	query := []float32{10, 10, 10, 10}
+
+	for _, k := range []int{0, -1} {
+		if _, err := col.TopK(query, k, L2); err == nil {
+			t.Fatalf("TopK accepted k = %d", k)
+		}
+	}
+	// the heaps are sized for the column, not for k
+	results, err := col.TopK(query, math.MaxInt, L2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 50 {
+		t.Fatalf("got %d results, want all 50 entries", len(results))
+	}
+	if results[0].Index != 10 {
+		t.Fatalf("best result is entry %d, want 10", results[0].Index)
+	}
+
+	filter := TimeRangeFilter(0, 5)
+	results, err = col.TopKFiltered(query, math.MaxInt, L2, filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 5 || results[0].Index != 4 {
+		t.Fatalf("filtered results are %v, want entries 4 to 0", results)
+	}
+
+	if err := col.BuildIVF(IVFParams{Metric: L2, Seed: 1}); err != nil {
+		t.Fatal(err)
+	}
+	results, err = col.SearchIVF(query, math.MaxInt, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 50 {
+		t.Fatalf("IVF returned %d results, want all 50 entries", len(results))
+	}
+
+	empty := newTestColumn(t, conn, "empty", 4)
+	results, err = empty.TopK(query, math.MaxInt, L2)
+	if err != nil || len(results) != 0 {
+		t.Fatalf("empty column returned %v %v", results, err)
+	}
+}
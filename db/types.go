@@ -3,10 +3,7 @@ package db
 import (
 	"bytes"
 	"encoding/binary"
-	"log/slog"
-	"os"
-
-	"github.com/edsrzf/mmap-go"
+	"sync"
 )
 
 /*
@@ -27,7 +24,8 @@ const (
 const (
 	HeaderSize          = 16
 	MetadataRegionStart = 16
-	DataRegionStart     = 16 * 1024 * 1024 // First 16MB reserved for metadata
+	DataRegionStart     = 16 * 1024 * 1024                  // First 16MB reserved for metadata
+	InitialFileSize     = DataRegionStart + (ChunkSize * 4) //~270MB
 )
 
 type Direction int
@@ -37,101 +35,41 @@ const (
 	RIGHT
 )
 
-// MMapFile wraps a memory-mapped file with its path for easy resizing
-type MMapFile struct {
-	path   string
-	mapped mmap.MMap
-}
-
-// Bytes returns the underlying byte slice
-func (m *MMapFile) Bytes() []byte {
-	return m.mapped
-}
-
-// Grow increases the file size by additionalBytes and remaps
-func (m *MMapFile) Grow(additionalBytes int64) error {
-	m.mapped.Flush()
-	m.mapped.Unmap()
-
-	f, err := os.OpenFile(m.path, os.O_RDWR, 0644)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-
-	info, err := f.Stat()
-	if err != nil {
-		return err
-	}
-	newSize := info.Size() + additionalBytes
-	if err := f.Truncate(newSize); err != nil {
-		return err
-	}
-
-	m.mapped, err = mmap.Map(f, mmap.RDWR, 0)
-	if err != nil {
-		return err
-	}
-	slog.Info("File grown", "path", m.path, "newSize", newSize)
-	return nil
-}
-
-// Close flushes and unmaps the file
-func (m *MMapFile) Close() error {
-	defer m.mapped.Unmap()
-	return m.mapped.Flush()
-}
-
-// OpenMMapFile opens or creates a memory-mapped file
-func OpenMMapFile(path string, initialSize int64) (*MMapFile, error) {
-	_, err := os.Stat(path)
-	var f *os.File
-	var fileErr error
-	if os.IsNotExist(err) {
-		f, fileErr = os.Create(path)
-		if fileErr == nil {
-			f.Truncate(initialSize)
-		}
-	} else {
-		f, fileErr = os.OpenFile(path, os.O_RDWR, 0644)
-	}
-	if fileErr != nil {
-		return nil, fileErr
-	}
-	defer f.Close()
-
-	mapped, err := mmap.Map(f, mmap.RDWR, 0)
-	if err != nil {
-		return nil, err
-	}
-
-	return &MMapFile{
-		path:   path,
-		mapped: mapped,
-	}, nil
-}
-
 // first 8 bytes in the mmaped region are always reserved
 // for the current cursorPosition (meaning the next) writeable
 // position, and the next 8 bytes are reserved for the number of
 // tables in the DB
 
+// ChunkLocation records where the entries of a chunk currently live
+// Stored in the top byte of the header's vector count, so older files read as Local
+type ChunkLocation uint8
+
+const (
+	Local  ChunkLocation = iota // entries are in the .ken file
+	Remote                      // entries were offloaded to the blob store
+)
+
+const chunkCountMask = 1<<56 - 1
+
 // Chunk header starts off each chunk
 type ChunkHeader struct {
 	nextChunk  int64 // offset of next chunk, 0 = last
 	numVectors int64 // how many vectors in THIS chunk
+	location   ChunkLocation
 }
 
 func ReadChunkHeader(b []byte, offset int64) ChunkHeader {
+	count := ByteOrder.Uint64(b[offset+8 : offset+16])
 	return ChunkHeader{
 		nextChunk:  int64(ByteOrder.Uint64(b[offset : offset+8])),
-		numVectors: int64(ByteOrder.Uint64(b[offset+8 : offset+16])),
+		numVectors: int64(count & chunkCountMask),
+		location:   ChunkLocation(count >> 56),
 	}
 }
 
 func (header *ChunkHeader) WriteTo(b []byte, offset int64) {
 	ByteOrder.PutUint64(b[offset:], uint64(header.nextChunk))
-	ByteOrder.PutUint64(b[offset+8:], uint64(header.numVectors))
+	ByteOrder.PutUint64(b[offset+8:], uint64(header.numVectors)|uint64(header.location)<<56)
 }
 
 // Fixed size for a name
@@ -174,12 +112,16 @@ func ReadColumnMetadata(b []byte, offset int64) ColumnMetadata {
 }
 
 func (meta *ColumnMetadata) WriteTo(b []byte) {
-	offset := meta.offset
-	copy(b[offset:], meta.name[:])
-	ByteOrder.PutUint64(b[offset+NameSize:], uint64(meta.vectorLength))
-	ByteOrder.PutUint64(b[offset+NameSize+8:], uint64(meta.numVectors))
-	ByteOrder.PutUint64(b[offset+NameSize+16:], uint64(meta.firstChunkOffset))
-	ByteOrder.PutUint64(b[offset+NameSize+24:], uint64(meta.offset))
+	meta.encode(b[meta.offset:])
+}
+
+// encode writes the metadata record to the start of b
+func (meta *ColumnMetadata) encode(b []byte) {
+	copy(b, meta.name[:])
+	ByteOrder.PutUint64(b[NameSize:], uint64(meta.vectorLength))
+	ByteOrder.PutUint64(b[NameSize+8:], uint64(meta.numVectors))
+	ByteOrder.PutUint64(b[NameSize+16:], uint64(meta.firstChunkOffset))
+	ByteOrder.PutUint64(b[NameSize+24:], uint64(meta.offset))
 }
 
 type TableMetadata struct {
@@ -197,26 +139,52 @@ func ReadTableMetadata(b []byte, offset int64) TableMetadata {
 }
 
 func (meta *TableMetadata) WriteTo(b []byte) {
-	offset := meta.offset
-	copy(b[offset:], meta.name[:])
-	ByteOrder.PutUint64(b[offset+NameSize:], uint64(meta.numColumns))
-	ByteOrder.PutUint64(b[offset+NameSize+8:], uint64(offset))
+	meta.encode(b[meta.offset:])
+}
+
+// encode writes the metadata record to the start of b
+func (meta *TableMetadata) encode(b []byte) {
+	copy(b, meta.name[:])
+	ByteOrder.PutUint64(b[NameSize:], uint64(meta.numColumns))
+	ByteOrder.PutUint64(b[NameSize+8:], uint64(meta.offset))
 }
 
+// All handles are safe for concurrent use. mu guards the in-memory fields only,
+// the mapped bytes are protected by pinning and the file's writer lock
 type Column struct {
+	mu   sync.RWMutex
 	meta ColumnMetadata
-	file *MMapFile
+	file *store
+	conn *DB
+	snap *columnView // set on frozen columns returned from a snapshot
+
+	schema  *Schema      // nil until SetSchema, replaced rather than mutated
+	indexes *indexSet    // shared with frozen copies
+	stats   *columnStats // shared with frozen copies
+}
+
+// metadata returns a copy of the column metadata that is safe to read without holding mu
+func (column *Column) metadata() ColumnMetadata {
+	column.mu.RLock()
+	defer column.mu.RUnlock()
+	return column.meta
 }
 
 type Table struct {
+	mu      sync.RWMutex
 	meta    TableMetadata
 	columns []*Column
-	file    *MMapFile
+	file    *store
+	conn    *DB
+	props   properties
 }
 
 type DB struct {
-	tables []*Table
-	file   *MMapFile
+	mu         sync.RWMutex
+	tables     []*Table
+	file       *store
+	props      properties
+	freeChunks []int64 // chunks released by blobs, only touched under the writer lock
 }
 
 func GetMetadataCursorPos(b []byte) int64 {
@@ -3,10 +3,24 @@ package main
 import (
 	"fmt"
 	"kendb/db"
+	"log/slog"
 	"os"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		cmd, ok := commands[os.Args[1]]
+		if !ok {
+			usage()
+			os.Exit(2)
+		}
+		if err := cmd(os.Args[2:]); err != nil {
+			slog.Error("Command failed", "command", os.Args[1], "error", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	/*
 		err := parsers.ParseParquet("small.parquet", new(parsers.RecordEmbedding), 10)
 		if err != nil {